@@ -0,0 +1,401 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigFileEnv names the config file when -config is not given
+const ConfigFileEnv = "CONFIG_FILE"
+
+// Config holds every setting of the service. A key is resolved from, in order
+// of precedence, its command line flag (-database.host), its env var
+// (DB_HOST, or DB_HOST_FILE naming a file holding the value), the YAML or TOML
+// config file and finally its default. config.example.yaml documents every key.
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Database  DatabaseConfig  `yaml:"database"`
+	JWT       JWTConfig       `yaml:"jwt"`
+	Exchange  ExchangeConfig  `yaml:"exchange"`
+	Risk      RiskConfig      `yaml:"risk"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Mail      MailConfig      `yaml:"mail"`
+}
+
+// ServerConfig is the HTTP listener
+type ServerConfig struct {
+	Addr string `yaml:"addr" env:"SERVER_ADDR" default:":5000"`
+	// AppURL is the base of the links put in mails
+	AppURL string `yaml:"app_url" env:"APP_URL" default:"http://localhost:5000"`
+	// ShutdownTimeout bounds how long in-flight requests and workers get on SIGINT or SIGTERM
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SERVER_SHUTDOWN_TIMEOUT" default:"15s"`
+	// TrustedProxies is a comma separated list of addresses or CIDRs whose
+	// X-Forwarded-For header is believed, empty trusts no proxy
+	TrustedProxies string `yaml:"trusted_proxies" env:"SERVER_TRUSTED_PROXIES"`
+}
+
+// Proxies splits TrustedProxies, nil when no proxy is trusted
+func (conf ServerConfig) Proxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(conf.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// DatabaseConfig is the database connection. Driver is one of DriverMySQL,
+// DriverPostgres or DriverSQLite; SQLite only uses Path.
+type DatabaseConfig struct {
+	Driver string `yaml:"driver" env:"DB_DRIVER" default:"mysql"`
+	Host   string `yaml:"host" env:"DB_HOST" default:"127.0.0.1"`
+	// Port zero is the default port of the driver
+	Port     int    `yaml:"port" env:"DB_PORT"`
+	User     string `yaml:"user" env:"DB_USER"`
+	Password string `yaml:"password" env:"DB_PASSWORD"`
+	Name     string `yaml:"name" env:"DB_NAME"`
+	SSLMode  string `yaml:"ssl_mode" env:"DB_SSL_MODE" default:"disable"`
+	// Path is the SQLite file, ":memory:" keeps the database in memory
+	Path string `yaml:"path" env:"DB_PATH" default:"strategy_robot.db"`
+}
+
+// JWTConfig signs access tokens
+type JWTConfig struct {
+	Secret string `yaml:"secret" env:"JWT_SECRET"`
+	Issuer string `yaml:"issuer" env:"JWT_ISSUER" default:"marco"`
+}
+
+// ExchangeConfig covers bound Binance keys and the request budget shared by them
+type ExchangeConfig struct {
+	// MasterKey is the base64 encoded 32 byte key wrapping the data keys of secrets
+	MasterKey      string        `yaml:"master_key" env:"MASTER_KEY"`
+	HealthInterval time.Duration `yaml:"health_interval" env:"EXCHANGE_HEALTH_INTERVAL" default:"1h"`
+	WeightLimit    int           `yaml:"weight_limit" env:"EXCHANGE_WEIGHT_LIMIT" default:"1200"`
+	CancelReserve  int           `yaml:"cancel_reserve" env:"EXCHANGE_CANCEL_RESERVE" default:"100"`
+	OrdersPer10s   int           `yaml:"orders_per_10s" env:"EXCHANGE_ORDERS_PER_10S" default:"50"`
+	OrdersPerDay   int           `yaml:"orders_per_day" env:"EXCHANGE_ORDERS_PER_DAY" default:"160000"`
+	MaxWait        time.Duration `yaml:"max_wait" env:"EXCHANGE_MAX_WAIT" default:"5s"`
+	// RetryAttempts counts the first try of a Binance call, 1 disables retries
+	RetryAttempts     int           `yaml:"retry_attempts" env:"EXCHANGE_RETRY_ATTEMPTS" default:"3"`
+	RetryBaseDelay    time.Duration `yaml:"retry_base_delay" env:"EXCHANGE_RETRY_BASE_DELAY" default:"200ms"`
+	RetryMaxDelay     time.Duration `yaml:"retry_max_delay" env:"EXCHANGE_RETRY_MAX_DELAY" default:"2s"`
+	ReconcileInterval time.Duration `yaml:"reconcile_interval" env:"EXCHANGE_RECONCILE_INTERVAL" default:"5m"`
+	// ReconcileLookback is how far back trades are scanned for orders placed elsewhere
+	ReconcileLookback time.Duration `yaml:"reconcile_lookback" env:"EXCHANGE_RECONCILE_LOOKBACK" default:"24h"`
+	// SnapshotInterval is how often the balances of bound keys are stored for the portfolio history
+	SnapshotInterval time.Duration `yaml:"snapshot_interval" env:"EXCHANGE_SNAPSHOT_INTERVAL" default:"1h"`
+	// RebalanceInterval is how often rebalance robots check their drift and schedule
+	RebalanceInterval time.Duration `yaml:"rebalance_interval" env:"EXCHANGE_REBALANCE_INTERVAL" default:"5m"`
+	// OrderBookIdle closes a local order book nobody asked for within it
+	OrderBookIdle time.Duration `yaml:"order_book_idle" env:"EXCHANGE_ORDER_BOOK_IDLE" default:"10m"`
+	MaxOrderBooks int           `yaml:"max_order_books" env:"EXCHANGE_MAX_ORDER_BOOKS" default:"50"`
+	// TickerStaleAfter marks cached tickers stale, and reconnects their stream, after that long without a message
+	TickerStaleAfter time.Duration `yaml:"ticker_stale_after" env:"EXCHANGE_TICKER_STALE_AFTER" default:"30s"`
+}
+
+// RiskConfig caps single orders, zero disables a cap
+type RiskConfig struct {
+	MaxOrderQuantity float64 `yaml:"max_order_quantity" env:"RISK_MAX_ORDER_QUANTITY" default:"0"`
+	MaxOrderNotional float64 `yaml:"max_order_notional" env:"RISK_MAX_ORDER_NOTIONAL" default:"0"`
+}
+
+// RateLimitConfig is how many requests a minute each route group allows one
+// caller. A caller is a personal access token, the user of a session login or,
+// on anonymous routes, the client IP.
+type RateLimitConfig struct {
+	Auth      int `yaml:"auth" env:"RATE_LIMIT_AUTH" default:"20"`
+	Users     int `yaml:"users" env:"RATE_LIMIT_USERS" default:"60"`
+	Robots    int `yaml:"robots" env:"RATE_LIMIT_ROBOTS" default:"60"`
+	Binance   int `yaml:"binance" env:"RATE_LIMIT_BINANCE" default:"30"`
+	Portfolio int `yaml:"portfolio" env:"RATE_LIMIT_PORTFOLIO" default:"30"`
+	Market    int `yaml:"market" env:"RATE_LIMIT_MARKET" default:"120"`
+	Admin     int `yaml:"admin" env:"RATE_LIMIT_ADMIN" default:"120"`
+}
+
+// Mail drivers accepted in MailConfig.Driver
+const (
+	MailDriverSMTP = "smtp"
+	// MailDriverLog only logs mails, it is meant for local development
+	MailDriverLog = "log"
+)
+
+// MailConfig describes the SMTP relay used for account mails. The smtp driver
+// needs Host and From, the log driver never delivers anything.
+type MailConfig struct {
+	Driver   string `yaml:"driver" env:"MAIL_DRIVER" default:"smtp"`
+	Host     string `yaml:"smtp_host" env:"SMTP_HOST"`
+	Port     int    `yaml:"smtp_port" env:"SMTP_PORT" default:"587"`
+	User     string `yaml:"smtp_user" env:"SMTP_USER"`
+	Password string `yaml:"smtp_password" env:"SMTP_PASSWORD"`
+	From     string `yaml:"from" env:"MAIL_FROM"`
+}
+
+// setting is one leaf of Config
+type setting struct {
+	key   string
+	env   string
+	def   string
+	value reflect.Value
+}
+
+// Load resolves the config from args, the env and the config file and
+// validates it. The arguments left after the flags are returned.
+func Load(args []string) (Config, []string, error) {
+	_ = godotenv.Load()
+
+	var conf Config
+	settings := settingsOf(reflect.ValueOf(&conf).Elem(), "")
+
+	flags := flag.NewFlagSet("strategy_robot", flag.ContinueOnError)
+	path := flags.String("config", os.Getenv(ConfigFileEnv), "YAML or TOML config file")
+	fromFlags := make(map[string]string)
+	for _, s := range settings {
+		flags.Var(&flagValue{key: s.key, values: fromFlags}, s.key, "overrides "+s.env)
+	}
+	if err := flags.Parse(args); err != nil {
+		return conf, nil, err
+	}
+
+	fromFile := make(map[string]string)
+	if *path != "" {
+		var err error
+		if fromFile, err = readFile(*path); err != nil {
+			return conf, nil, err
+		}
+	}
+	known := make(map[string]bool)
+	for _, s := range settings {
+		known[s.key] = true
+	}
+	for key := range fromFile {
+		if !known[key] {
+			return conf, nil, fmt.Errorf("unknown key %q in %s", key, *path)
+		}
+	}
+
+	for _, s := range settings {
+		raw, source := s.def, "default"
+		if v, ok := fromFile[s.key]; ok {
+			raw, source = v, *path
+		}
+		v, ok, err := lookupEnv(s.env)
+		if err != nil {
+			return conf, nil, err
+		}
+		if ok {
+			raw, source = v, s.env
+		}
+		if v, ok := fromFlags[s.key]; ok {
+			raw, source = v, "-"+s.key
+		}
+		if err := set(s.value, raw); err != nil {
+			return conf, nil, fmt.Errorf("%s from %s: %v", s.key, source, err)
+		}
+	}
+	return conf, flags.Args(), conf.Validate()
+}
+
+// Validate reports every setting the service cannot start with
+func (conf Config) Validate() error {
+	var problems []string
+	require := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	require(conf.Server.Addr != "", "server.addr is required")
+	require(conf.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	for _, proxy := range conf.Server.Proxies() {
+		_, _, cidrErr := net.ParseCIDR(proxy)
+		require(cidrErr == nil || net.ParseIP(proxy) != nil, "server.trusted_proxies: %q is not an address or CIDR", proxy)
+	}
+	switch conf.Database.Driver {
+	case DriverMySQL, DriverPostgres:
+		require(conf.Database.Host != "", "database.host is required")
+		require(conf.Database.Port >= 0 && conf.Database.Port < 65536, "database.port must be a TCP port")
+		require(conf.Database.User != "", "database.user is required")
+		require(conf.Database.Name != "", "database.name is required")
+	case DriverSQLite:
+		require(conf.Database.Path != "", "database.path is required")
+	default:
+		problems = append(problems, fmt.Sprintf("database.driver must be %s, %s or %s", DriverMySQL, DriverPostgres, DriverSQLite))
+	}
+	require(len(conf.JWT.Secret) >= 16, "jwt.secret must be at least 16 characters")
+	if _, err := DecodeMasterKey(conf.Exchange.MasterKey); err != nil {
+		problems = append(problems, "exchange.master_key: "+err.Error())
+	}
+	require(conf.Exchange.HealthInterval > 0, "exchange.health_interval must be positive")
+	require(conf.Exchange.WeightLimit > conf.Exchange.CancelReserve, "exchange.weight_limit must exceed exchange.cancel_reserve")
+	require(conf.Exchange.CancelReserve >= 0, "exchange.cancel_reserve must not be negative")
+	require(conf.Exchange.OrdersPer10s > 0 && conf.Exchange.OrdersPerDay > 0, "exchange order limits must be positive")
+	require(conf.Exchange.MaxWait >= 0, "exchange.max_wait must not be negative")
+	require(conf.Exchange.RetryAttempts >= 1, "exchange.retry_attempts must be at least 1")
+	require(conf.Exchange.ReconcileInterval > 0 && conf.Exchange.ReconcileLookback > 0, "exchange.reconcile_interval and exchange.reconcile_lookback must be positive")
+	require(conf.Exchange.SnapshotInterval > 0, "exchange.snapshot_interval must be positive")
+	require(conf.Exchange.RebalanceInterval > 0, "exchange.rebalance_interval must be positive")
+	require(conf.Exchange.OrderBookIdle > 0 && conf.Exchange.MaxOrderBooks > 0, "exchange.order_book_idle and exchange.max_order_books must be positive")
+	require(conf.Exchange.TickerStaleAfter > 0, "exchange.ticker_stale_after must be positive")
+	require(conf.Exchange.RetryBaseDelay > 0 && conf.Exchange.RetryBaseDelay <= conf.Exchange.RetryMaxDelay, "exchange.retry_base_delay must be positive and at most exchange.retry_max_delay")
+	require(conf.Risk.MaxOrderQuantity >= 0 && conf.Risk.MaxOrderNotional >= 0, "risk limits must not be negative")
+	limits := conf.RateLimit
+	require(limits.Auth > 0 && limits.Users > 0 && limits.Robots > 0 && limits.Binance > 0 && limits.Portfolio > 0 && limits.Market > 0 && limits.Admin > 0, "rate_limit values must be positive")
+	switch conf.Mail.Driver {
+	case MailDriverSMTP:
+		require(conf.Mail.Host != "" && conf.Mail.From != "", "mail.smtp_host and mail.from are required with the smtp mail driver")
+	case MailDriverLog:
+	default:
+		problems = append(problems, fmt.Sprintf("mail.driver must be %s or %s", MailDriverSMTP, MailDriverLog))
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func settingsOf(v reflect.Value, prefix string) []setting {
+	var settings []setting
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key := prefix + field.Tag.Get("yaml")
+		if field.Type.Kind() == reflect.Struct {
+			settings = append(settings, settingsOf(v.Field(i), key+".")...)
+			continue
+		}
+		settings = append(settings, setting{
+			key:   key,
+			env:   field.Tag.Get("env"),
+			def:   field.Tag.Get("default"),
+			value: v.Field(i),
+		})
+	}
+	return settings
+}
+
+func set(v reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int:
+		if raw == "" {
+			v.SetInt(0)
+			return nil
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// lookupEnv reads name, or the file named by name_FILE so secrets can be mounted
+func lookupEnv(name string) (string, bool, error) {
+	if v, ok := os.LookupEnv(name); ok {
+		return v, true, nil
+	}
+	path, ok := os.LookupEnv(name + "_FILE")
+	if !ok {
+		return "", false, nil
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %v", name, err)
+	}
+	return strings.TrimSpace(string(raw)), true, nil
+}
+
+// readFile flattens a YAML or TOML file into dotted keys
+func readFile(path string) (map[string]string, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tree map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, &tree)
+	case ".toml":
+		err = toml.Unmarshal(raw, &tree)
+	default:
+		return nil, fmt.Errorf("config file %s must end in .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	values := make(map[string]string)
+	return values, flatten("", tree, values)
+}
+
+func flatten(prefix string, node interface{}, values map[string]string) error {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for k, v := range n {
+			if err := flatten(prefix+k+".", v, values); err != nil {
+				return err
+			}
+		}
+	case map[interface{}]interface{}:
+		for k, v := range n {
+			if err := flatten(prefix+fmt.Sprint(k)+".", v, values); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		return fmt.Errorf("%s: lists are not supported", strings.TrimSuffix(prefix, "."))
+	case nil:
+	default:
+		values[strings.TrimSuffix(prefix, ".")] = fmt.Sprint(n)
+	}
+	return nil
+}
+
+// flagValue records the flags that were actually given so unset flags do not
+// shadow the env or the file
+type flagValue struct {
+	key    string
+	values map[string]string
+}
+
+func (f *flagValue) String() string {
+	if f.values == nil {
+		return ""
+	}
+	return f.values[f.key]
+}
+
+func (f *flagValue) Set(v string) error {
+	f.values[f.key] = v
+	return nil
+}
@@ -2,43 +2,65 @@ package config
 
 import (
 	"fmt"
-	"os"
 
-	"github.com/joho/godotenv"
-	"github.com/myomyintko/strategy_robot/model"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
-//SetupDatabaseConnection is creating a new connection to our database
-func SetupDatabaseConnection() *gorm.DB {
-	errEnv := godotenv.Load()
-	if errEnv != nil {
-		panic("Failed to load env file")
-	}
-
-	dbUser := os.Getenv("DB_USER")
-	dbPass := os.Getenv("DB_PASSWORD")
-	dbHost := os.Getenv("DB_HOST")
-	dbName := os.Getenv("DB_NAME")
+// Database drivers accepted in DatabaseConfig.Driver
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
 
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:3306)/%s?charset=utf8&parseTime=True&loc=Local", dbUser, dbPass, dbHost, dbName)
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+//SetupDatabaseConnection is creating a new connection to our database, the schema is
+//managed by the migration package
+func SetupDatabaseConnection(conf DatabaseConfig) (*gorm.DB, error) {
+	db, err := gorm.Open(dialector(conf), &gorm.Config{})
 	if err != nil {
-		panic("Failed to create a connection to database")
+		return nil, fmt.Errorf("failed to connect to %s database: %w", conf.Driver, err)
 	}
-	errMigrate := db.AutoMigrate(&model.Robot{}, &model.User{}, &model.BinanceAPI{}, &model.Order{})
-	if errMigrate != nil {
-		return nil
+	if conf.Driver == DriverSQLite && conf.Path == ":memory:" {
+		// every connection would otherwise open its own empty database
+		dbSQL, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		dbSQL.SetMaxOpenConns(1)
+	}
+	return db, nil
+}
+
+func dialector(conf DatabaseConfig) gorm.Dialector {
+	switch conf.Driver {
+	case DriverPostgres:
+		port := conf.Port
+		if port == 0 {
+			port = 5432
+		}
+		dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s TimeZone=UTC",
+			conf.Host, port, conf.User, conf.Password, conf.Name, conf.SSLMode)
+		return postgres.Open(dsn)
+	case DriverSQLite:
+		return sqlite.Open(conf.Path + "?_busy_timeout=5000&_foreign_keys=on")
+	default:
+		port := conf.Port
+		if port == 0 {
+			port = 3306
+		}
+		dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8&parseTime=True&loc=Local", conf.User, conf.Password, conf.Host, port, conf.Name)
+		return mysql.Open(dsn)
 	}
-	return db
 }
 
 //CloseDatabaseConnection method is closing a connection between your app and your db
-func CloseDatabaseConnection(db *gorm.DB) {
+func CloseDatabaseConnection(db *gorm.DB) error {
 	dbSQL, err := db.DB()
 	if err != nil {
-		panic("Failed to close connection from database")
+		return err
 	}
-	panic(dbSQL.Close())
+	return dbSQL.Close()
 }
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// DecodeMasterKey decodes the base64 master key used to wrap the data keys of
+// exchange secrets, which must be 32 bytes long
+func DecodeMasterKey(encoded string) ([]byte, error) {
+	if encoded == "" {
+		return nil, fmt.Errorf("master key is not set")
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("master key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// LoadMasterKey reads a master key from the env var name or from the file
+// named by name+"_FILE"
+func LoadMasterKey(name string) ([]byte, error) {
+	encoded, _, err := lookupEnv(name)
+	if err != nil {
+		return nil, err
+	}
+	if encoded == "" {
+		return nil, fmt.Errorf("neither %s nor %s_FILE is set", name, name)
+	}
+	return DecodeMasterKey(encoded)
+}
@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myomyintko/strategy_robot/dto"
+	"github.com/myomyintko/strategy_robot/helper"
+	"github.com/myomyintko/strategy_robot/model"
+	"github.com/myomyintko/strategy_robot/service"
+)
+
+//AdminController is the user management surface of the admin role
+type AdminController interface {
+	Users(context *gin.Context)
+	SetRole(context *gin.Context)
+	Disable(context *gin.Context)
+	Enable(context *gin.Context)
+	ResetPassword(context *gin.Context)
+	Impersonate(context *gin.Context)
+}
+
+type adminController struct {
+	adminService   service.AdminService
+	authService    service.AuthService
+	sessionService service.SessionService
+	jwtService     service.JWTService
+}
+
+func NewAdminController(adminServ service.AdminService, authServ service.AuthService, sessionServ service.SessionService, jwtServ service.JWTService) AdminController {
+	return &adminController{
+		adminService:   adminServ,
+		authService:    authServ,
+		sessionService: sessionServ,
+		jwtService:     jwtServ,
+	}
+}
+
+func (c *adminController) Users(context *gin.Context) {
+	res := helper.BuildResponse(true, "OK", c.adminService.AllUser())
+	context.JSON(http.StatusOK, res)
+}
+
+func (c *adminController) SetRole(context *gin.Context) {
+	userID, ok := userIDParam(context)
+	if !ok {
+		return
+	}
+	var roleDTO dto.RoleUpdateDTO
+	if errDTO := context.ShouldBind(&roleDTO); errDTO != nil {
+		helper.Fail(context, helper.ValidationError("Failed to process request", errDTO))
+		return
+	}
+	if err := c.adminService.SetRole(userID, roleDTO.Role); err != nil {
+		helper.Fail(context, err)
+		return
+	}
+	res := helper.BuildResponse(true, "Role updated", helper.EmptyObj{})
+	context.JSON(http.StatusOK, res)
+}
+
+func (c *adminController) Disable(context *gin.Context) {
+	c.setDisabled(context, true)
+}
+
+func (c *adminController) Enable(context *gin.Context) {
+	c.setDisabled(context, false)
+}
+
+func (c *adminController) setDisabled(context *gin.Context, disabled bool) {
+	userID, ok := userIDParam(context)
+	if !ok {
+		return
+	}
+	if userID == helper.CurrentPrincipal(context).UserID {
+		helper.Fail(context, helper.ValidationError("Failed to process request", errors.New("You cannot disable yourself")))
+		return
+	}
+	if err := c.adminService.SetDisabled(userID, disabled); err != nil {
+		helper.Fail(context, err)
+		return
+	}
+	res := helper.BuildResponse(true, "OK", helper.EmptyObj{})
+	context.JSON(http.StatusOK, res)
+}
+
+func (c *adminController) ResetPassword(context *gin.Context) {
+	userID, ok := userIDParam(context)
+	if !ok {
+		return
+	}
+	password, err := c.adminService.ResetPassword(userID)
+	if err != nil {
+		helper.Fail(context, err)
+		return
+	}
+	res := helper.BuildResponse(true, "Hand this temporary password to the user", gin.H{"password": password})
+	context.JSON(http.StatusOK, res)
+}
+
+//Impersonate issues a read-only token acting as another user, it has no refresh token
+func (c *adminController) Impersonate(context *gin.Context) {
+	userID, ok := userIDParam(context)
+	if !ok {
+		return
+	}
+	user := c.authService.FindByID(userID)
+	if user.ID == 0 {
+		helper.Fail(context, service.ErrUserNotFound)
+		return
+	}
+	admin := helper.CurrentPrincipal(context)
+	session, _ := c.sessionService.Start(user.ID, fmt.Sprintf("impersonated by user %d", admin.UserID), context.ClientIP())
+	token, err := c.jwtService.GenerateToken(helper.Principal{
+		UserID:         user.ID,
+		SessionID:      session.ID,
+		Roles:          []string{model.RoleViewer},
+		Scopes:         model.ReadPermissions,
+		ImpersonatorID: admin.UserID,
+	})
+	if err != nil {
+		helper.Fail(context, err)
+		return
+	}
+	res := helper.BuildResponse(true, "Read-only token for "+user.Email, gin.H{"token": token})
+	context.JSON(http.StatusOK, res)
+}
+
+func userIDParam(context *gin.Context) (uint64, bool) {
+	userID, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		helper.Fail(context, helper.ValidationError("No param id was found", err))
+		return 0, false
+	}
+	return userID, true
+}
@@ -1,8 +1,9 @@
 package controller
 
 import (
+	"errors"
+	"log"
 	"net/http"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/myomyintko/strategy_robot/dto"
@@ -15,18 +16,30 @@ import (
 type AuthController interface {
 	Login(ctx *gin.Context)
 	Register(ctx *gin.Context)
+	Refresh(ctx *gin.Context)
+	Logout(ctx *gin.Context)
+	VerifyEmail(ctx *gin.Context)
+	ResendVerification(ctx *gin.Context)
+	ForgotPassword(ctx *gin.Context)
+	ResetPassword(ctx *gin.Context)
 }
 
 type authController struct {
-	authService service.AuthService
-	jwtService  service.JWTService
+	authService    service.AuthService
+	jwtService     service.JWTService
+	sessionService service.SessionService
+	totpService    service.TOTPService
+	loginGuard     service.LoginGuardService
 }
 
 //NewAuthController creates a new instance of AuthController
-func NewAuthController(authService service.AuthService, jwtService service.JWTService) AuthController {
+func NewAuthController(authService service.AuthService, jwtService service.JWTService, sessionService service.SessionService, totpService service.TOTPService, loginGuard service.LoginGuardService) AuthController {
 	return &authController{
-		authService: authService,
-		jwtService:  jwtService,
+		authService:    authService,
+		jwtService:     jwtService,
+		sessionService: sessionService,
+		totpService:    totpService,
+		loginGuard:     loginGuard,
 	}
 }
 
@@ -34,38 +47,189 @@ func (c *authController) Login(ctx *gin.Context) {
 	var loginDTO dto.LoginDTO
 	errDTO := ctx.ShouldBind(&loginDTO)
 	if errDTO != nil {
-		response := helper.BuildErrorResponse("Failed to process request", errDTO.Error(), helper.EmptyObj{})
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, helper.ValidationError("Failed to process request", errDTO))
+		return
+	}
+	if wait := c.loginGuard.Allow(loginDTO.Email, ctx.ClientIP()); wait > 0 {
+		helper.Fail(ctx, helper.RateLimitedError("Too many failed attempts", wait, errors.New("Try again later")))
 		return
 	}
 	authResult := c.authService.VerifyCredential(loginDTO.Email, loginDTO.Password)
 	if v, ok := authResult.(model.User); ok {
-		generatedToken := c.jwtService.GenerateToken(strconv.FormatUint(v.ID, 10))
-		v.Token = generatedToken
+		if v.DisabledAt != nil {
+			helper.Fail(ctx, helper.ForbiddenError("Account disabled", errors.New("Contact an administrator")))
+			return
+		}
+		if v.TOTPEnabled {
+			if loginDTO.Code == "" {
+				helper.Fail(ctx, helper.AuthError("Two-factor code required", errors.New("totp_required")))
+				return
+			}
+			if err := c.totpService.VerifyLogin(v.ID, loginDTO.Code); err != nil {
+				c.loginGuard.Fail(loginDTO.Email, ctx.ClientIP())
+				helper.Fail(ctx, helper.AuthError("Please check again your credential", err))
+				return
+			}
+		}
+		c.loginGuard.Succeed(loginDTO.Email)
+		if err := c.authService.CheckLogin(v); err != nil {
+			helper.Fail(ctx, err)
+			return
+		}
+		session, refreshToken := c.sessionService.Start(v.ID, ctx.Request.UserAgent(), ctx.ClientIP())
+		token, err := c.jwtService.GenerateToken(principalOf(v, session))
+		if err != nil {
+			helper.Fail(ctx, err)
+			return
+		}
+		v.Token = token
+		v.RefreshToken = refreshToken
 		response := helper.BuildResponse(true, "OK!", v)
 		ctx.JSON(http.StatusOK, response)
 		return
 	}
-	response := helper.BuildErrorResponse("Please check again your credential", "Invalid Credential", helper.EmptyObj{})
-	ctx.AbortWithStatusJSON(http.StatusUnauthorized, response)
+	c.loginGuard.Fail(loginDTO.Email, ctx.ClientIP())
+	helper.Fail(ctx, helper.AuthError("Please check again your credential", errors.New("Invalid Credential")))
 }
 
 func (c *authController) Register(ctx *gin.Context) {
 	var registerDTO dto.RegisterDTO
 	errDTO := ctx.ShouldBind(&registerDTO)
 	if errDTO != nil {
-		response := helper.BuildErrorResponse("Failed to process request", errDTO.Error(), helper.EmptyObj{})
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, helper.ValidationError("Failed to process request", errDTO))
 		return
 	}
 	if !c.authService.IsDuplicateEmail(registerDTO.Email) {
-		response := helper.BuildErrorResponse("Failed to process request", "Duplicate email", helper.EmptyObj{})
-		ctx.JSON(http.StatusConflict, response)
+		helper.Fail(ctx, helper.ConflictError("Failed to process request", errors.New("Duplicate email")))
+		return
+	}
+	createdUser, err := c.authService.CreateUser(registerDTO)
+	if err != nil {
+		helper.Fail(ctx, err)
+		return
+	}
+	if err := c.authService.SendVerification(createdUser); err != nil {
+		log.Printf("Failed to send verification mail to user %d: %v", createdUser.ID, err)
+	}
+	session, refreshToken := c.sessionService.Start(createdUser.ID, ctx.Request.UserAgent(), ctx.ClientIP())
+	createdUser.Token, err = c.jwtService.GenerateToken(principalOf(createdUser, session))
+	if err != nil {
+		helper.Fail(ctx, err)
 		return
 	}
-	createdUser := c.authService.CreateUser(registerDTO)
-	token := c.jwtService.GenerateToken(strconv.FormatUint(createdUser.ID, 10))
-	createdUser.Token = token
+	createdUser.RefreshToken = refreshToken
 	response := helper.BuildResponse(true, "OK!", createdUser)
 	ctx.JSON(http.StatusCreated, response)
 }
+
+//Refresh exchanges a refresh token for a new access and refresh token pair
+func (c *authController) Refresh(ctx *gin.Context) {
+	var refreshDTO dto.RefreshDTO
+	errDTO := ctx.ShouldBind(&refreshDTO)
+	if errDTO != nil {
+		helper.Fail(ctx, helper.ValidationError("Failed to process request", errDTO))
+		return
+	}
+	session, refreshToken, err := c.sessionService.Refresh(refreshDTO.RefreshToken, ctx.Request.UserAgent(), ctx.ClientIP())
+	if err != nil {
+		message := "Please login again"
+		if errors.Is(err, service.ErrRefreshTokenReused) {
+			message = "Session was revoked"
+		}
+		helper.Fail(ctx, helper.AuthError(message, err))
+		return
+	}
+	user := c.authService.FindByID(session.UserID)
+	if user.DisabledAt != nil {
+		helper.Fail(ctx, helper.ForbiddenError("Account disabled", errors.New("Contact an administrator")))
+		return
+	}
+	user.Token, err = c.jwtService.GenerateToken(principalOf(user, session))
+	if err != nil {
+		helper.Fail(ctx, err)
+		return
+	}
+	user.RefreshToken = refreshToken
+	response := helper.BuildResponse(true, "OK!", user)
+	ctx.JSON(http.StatusOK, response)
+}
+
+//Logout revokes the session the access token belongs to
+func (c *authController) Logout(ctx *gin.Context) {
+	principal := helper.CurrentPrincipal(ctx)
+	c.sessionService.Revoke(principal.UserID, principal.SessionID)
+	response := helper.BuildResponse(true, "Logged out", helper.EmptyObj{})
+	ctx.JSON(http.StatusOK, response)
+}
+
+//VerifyEmail confirms the address a verification mail was sent to
+func (c *authController) VerifyEmail(ctx *gin.Context) {
+	var tokenDTO dto.EmailTokenDTO
+	errDTO := ctx.ShouldBind(&tokenDTO)
+	if errDTO != nil {
+		helper.Fail(ctx, helper.ValidationError("Failed to process request", errDTO))
+		return
+	}
+	if err := c.authService.VerifyEmail(tokenDTO.Token); err != nil {
+		helper.Fail(ctx, helper.ValidationError("Failed to verify email", err))
+		return
+	}
+	response := helper.BuildResponse(true, "Email verified", helper.EmptyObj{})
+	ctx.JSON(http.StatusOK, response)
+}
+
+//ResendVerification mails a fresh verification link to the caller
+func (c *authController) ResendVerification(ctx *gin.Context) {
+	user := c.authService.FindByID(helper.CurrentPrincipal(ctx).UserID)
+	if user.EmailVerifiedAt != nil {
+		helper.Fail(ctx, helper.ConflictError("Failed to process request", errors.New("Email already verified")))
+		return
+	}
+	if err := c.authService.SendVerification(user); err != nil {
+		helper.Fail(ctx, helper.UpstreamError("Failed to send mail", err))
+		return
+	}
+	response := helper.BuildResponse(true, "Verification mail sent", helper.EmptyObj{})
+	ctx.JSON(http.StatusOK, response)
+}
+
+//ForgotPassword always answers the same way so it cannot be used to probe for accounts
+func (c *authController) ForgotPassword(ctx *gin.Context) {
+	var forgotDTO dto.ForgotPasswordDTO
+	errDTO := ctx.ShouldBind(&forgotDTO)
+	if errDTO != nil {
+		helper.Fail(ctx, helper.ValidationError("Failed to process request", errDTO))
+		return
+	}
+	if err := c.authService.ForgotPassword(forgotDTO.Email); err != nil {
+		log.Printf("Failed to send password reset mail: %v", err)
+	}
+	response := helper.BuildResponse(true, "If the address is registered a reset link has been sent", helper.EmptyObj{})
+	ctx.JSON(http.StatusOK, response)
+}
+
+//ResetPassword sets a new password from a mailed token and logs out every session
+func (c *authController) ResetPassword(ctx *gin.Context) {
+	var resetDTO dto.ResetPasswordDTO
+	errDTO := ctx.ShouldBind(&resetDTO)
+	if errDTO != nil {
+		helper.Fail(ctx, helper.ValidationError("Failed to process request", errDTO))
+		return
+	}
+	if err := c.authService.ResetPassword(resetDTO.Token, resetDTO.Password); err != nil {
+		helper.Fail(ctx, helper.ValidationError("Failed to reset password", err))
+		return
+	}
+	response := helper.BuildResponse(true, "Password changed", helper.EmptyObj{})
+	ctx.JSON(http.StatusOK, response)
+}
+
+//principalOf is what a password login is allowed to do
+func principalOf(user model.User, session model.Session) helper.Principal {
+	return helper.Principal{
+		UserID:    user.ID,
+		SessionID: session.ID,
+		Roles:     []string{user.Role},
+		Scopes:    []string{helper.ScopeAll},
+	}
+}
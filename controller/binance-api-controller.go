@@ -1,13 +1,11 @@
 package controller
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/myomyintko/strategy_robot/dto"
 	"github.com/myomyintko/strategy_robot/helper"
@@ -15,11 +13,6 @@ import (
 	"github.com/myomyintko/strategy_robot/service"
 )
 
-var (
-	keys []model.BinanceAPI
-	key  model.BinanceAPI
-)
-
 type APIController interface {
 	All(context *gin.Context)
 	FindByID(context *gin.Context)
@@ -31,18 +24,18 @@ type APIController interface {
 
 type apiController struct {
 	apiService service.APIService
-	jwtService service.JWTService
+	clients    service.ClientRegistry
 }
 
-func NewAPIController(apiServ service.APIService, jwtServ service.JWTService) APIController {
+func NewAPIController(apiServ service.APIService, clients service.ClientRegistry) APIController {
 	return &apiController{
 		apiService: apiServ,
-		jwtService: jwtServ,
+		clients:    clients,
 	}
 }
 
 func (c *apiController) All(context *gin.Context) {
-	keys = c.apiService.All()
+	keys := c.apiService.All()
 	res := helper.BuildResponse(true, "OK", keys)
 	context.JSON(http.StatusOK, res)
 }
@@ -50,74 +43,49 @@ func (c *apiController) All(context *gin.Context) {
 func (c *apiController) FindByID(context *gin.Context) {
 	id, err := strconv.ParseUint(context.Param("id"), 0, 0)
 	if err != nil {
-		res := helper.BuildErrorResponse("No param id was found", err.Error(), helper.EmptyObj{})
-		context.AbortWithStatusJSON(http.StatusBadRequest, res)
+		helper.Fail(context, helper.ValidationError("No param id was found", err))
 		return
 	}
-	key = c.apiService.FindByID(id)
-	fmt.Println(key)
-	//if (api == model.BinanceAPIResponse{}) {
-	//	res := helper.BuildErrorResponse("Data not found", "No data with given id", helper.EmptyObj{})
-	//	context.JSON(http.StatusNotFound, res)
-	//} else {
-	//	res := helper.BuildResponse(true, "OK", api)
-	//	context.JSON(http.StatusOK, res)
-	//}
+	userID := helper.CurrentPrincipal(context).UserID
+	key := c.apiService.FindByID(userID, id)
+	if key.ID == 0 {
+		helper.Fail(context, helper.NotFoundError("Data not found", errors.New("No data with given id")))
+		return
+	}
+	res := helper.BuildResponse(true, "OK", key)
+	context.JSON(http.StatusOK, res)
 }
 
+//FindByUserID lists the keys bound by the authenticated user only
 func (c *apiController) FindByUserID(context *gin.Context) {
-	id, err := strconv.ParseUint(context.Param("id"), 0, 0)
-	if err != nil {
-		res := helper.BuildErrorResponse("No param id was found", err.Error(), helper.EmptyObj{})
-		context.AbortWithStatusJSON(http.StatusBadRequest, res)
-		return
-	}
-	key = c.apiService.FindByUserID(id)
-	fmt.Println(key)
-	//if (key == model.BinanceAPIResponse{}) {
-	//	res := helper.BuildErrorResponse("Data not found", "No data with given id", helper.EmptyObj{})
-	//	context.JSON(http.StatusNotFound, res)
-	//} else {
-	//	res := helper.BuildResponse(true, "OK", key)
-	//	context.JSON(http.StatusOK, res)
-	//}
+	userID := helper.CurrentPrincipal(context).UserID
+	keys := c.apiService.AllByUserID(userID)
+	res := helper.BuildResponse(true, "OK", keys)
+	context.JSON(http.StatusOK, res)
 }
 
 func (c *apiController) Insert(context *gin.Context) {
 	var apiCreateDTO dto.APICreateDTO
 	errDTO := context.ShouldBind(&apiCreateDTO)
 	if errDTO != nil {
-		res := helper.BuildErrorResponse("Failed to process request", errDTO.Error(), helper.EmptyObj{})
-		context.JSON(http.StatusBadRequest, res)
+		helper.Fail(context, helper.ValidationError("Failed to process request", errDTO))
 		return
 	}
-	authHeader := context.GetHeader("Authorization")
-	splitToken := strings.Split(authHeader, "Bearer ")
-	authHeader = splitToken[1]
+	userID := helper.CurrentPrincipal(context).UserID
 
-	userID, errToken := c.getUserIDByToken(authHeader)
-	if errToken != nil {
-		response := helper.BuildErrorResponse("Token Error", errToken.Error(), helper.EmptyObj{})
-		context.JSON(http.StatusBadRequest, response)
-		return
-	}
-	convertedUserID, err := strconv.ParseUint(userID, 10, 64)
-	if err != nil {
-		response := helper.BuildErrorResponse("Parse Error", err.Error(), helper.EmptyObj{})
-		context.JSON(http.StatusInternalServerError, response)
-		return
-	}
-
-	user := c.apiService.FindByUserID(convertedUserID)
+	user := c.apiService.FindByUserID(userID)
 	if user.ID != 0 {
-		response := helper.BuildErrorResponse("User already bound keys", "", helper.EmptyObj{})
-		context.JSON(http.StatusInternalServerError, response)
+		helper.Fail(context, helper.ConflictError("User already bound keys", nil))
 		return
 	}
 
-	apiCreateDTO.UserID = convertedUserID
+	apiCreateDTO.UserID = userID
 	apiCreateDTO.BoundAt = time.Now()
-	result := c.apiService.Insert(apiCreateDTO)
+	result, err := c.apiService.Insert(apiCreateDTO)
+	if err != nil {
+		helper.Fail(context, err)
+		return
+	}
 	response := helper.BuildResponse(true, "OK", result)
 	context.JSON(http.StatusCreated, response)
 }
@@ -126,37 +94,24 @@ func (c *apiController) Update(context *gin.Context) {
 	var apiUpdateDTO dto.APIUpdateDTO
 	errDTO := context.ShouldBind(&apiUpdateDTO)
 	if errDTO != nil {
-		res := helper.BuildErrorResponse("Failed to process request", errDTO.Error(), helper.EmptyObj{})
-		context.JSON(http.StatusBadRequest, res)
+		helper.Fail(context, helper.ValidationError("Failed to process request", errDTO))
 		return
 	}
 
-	authHeader := context.GetHeader("Authorization")
-	splitToken := strings.Split(authHeader, "Bearer ")
-	authHeader = splitToken[1]
-
-	token, errToken := c.jwtService.ValidateToken(authHeader)
-	if errToken != nil {
-		response := helper.BuildErrorResponse("Token Error", errToken.Error(), helper.EmptyObj{})
-		context.JSON(http.StatusBadRequest, response)
-		return
-	}
-	claims := token.Claims.(jwt.MapClaims)
-	userID, err := strconv.ParseUint(fmt.Sprintf("%v", claims["user_id"]), 10, 64)
-	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		context.JSON(http.StatusBadRequest, response)
-		return
-	}
+	userID := helper.CurrentPrincipal(context).UserID
 
 	if c.apiService.IsAllowedToEdit(userID, apiUpdateDTO.ID) {
 		apiUpdateDTO.UserID = userID
-		result := c.apiService.Update(apiUpdateDTO)
+		result, err := c.apiService.Update(apiUpdateDTO)
+		c.clients.Invalidate(apiUpdateDTO.ID)
+		if err != nil {
+			helper.Fail(context, err)
+			return
+		}
 		response := helper.BuildResponse(true, "OK", result)
 		context.JSON(http.StatusOK, response)
 	} else {
-		response := helper.BuildErrorResponse("You dont have permission", "You are not the owner", helper.EmptyObj{})
-		context.JSON(http.StatusForbidden, response)
+		helper.Fail(context, helper.ForbiddenError("You dont have permission", errors.New("You are not the owner")))
 	}
 }
 
@@ -164,43 +119,18 @@ func (c *apiController) Delete(context *gin.Context) {
 	var key model.BinanceAPI
 	id, err := strconv.ParseUint(context.Param("id"), 0, 0)
 	if err != nil {
-		response := helper.BuildErrorResponse("Failed tou get id", "No param id were found", helper.EmptyObj{})
-		context.JSON(http.StatusBadRequest, response)
-	}
-	key.ID = id
-	authHeader := context.GetHeader("Authorization")
-	splitToken := strings.Split(authHeader, "Bearer ")
-	authHeader = splitToken[1]
-
-	token, errToken := c.jwtService.ValidateToken(authHeader)
-	if errToken != nil {
-		response := helper.BuildErrorResponse("Token Error", errToken.Error(), helper.EmptyObj{})
-		context.JSON(http.StatusBadRequest, response)
-		return
-	}
-	claims := token.Claims.(jwt.MapClaims)
-	userID, err := strconv.ParseUint(fmt.Sprintf("%v", claims["user_id"]), 10, 64)
-	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		context.JSON(http.StatusBadRequest, response)
+		helper.Fail(context, helper.ValidationError("Failed tou get id", errors.New("No param id were found")))
 		return
 	}
+	key.ID = id
+	userID := helper.CurrentPrincipal(context).UserID
 	if !c.apiService.IsAllowedToEdit(userID, key.ID) {
-		response := helper.BuildErrorResponse("You dont have permission", "You are not the owner", helper.EmptyObj{})
-		context.JSON(http.StatusForbidden, response)
+		helper.Fail(context, helper.ForbiddenError("You dont have permission", errors.New("You are not the owner")))
 		return
 	}
+	key.UserID = userID
 	c.apiService.Delete(key)
+	c.clients.Invalidate(key.ID)
 	res := helper.BuildResponse(true, "Deleted", helper.EmptyObj{})
 	context.JSON(http.StatusOK, res)
 }
-
-func (c *apiController) getUserIDByToken(token string) (string, error) {
-	aToken, err := c.jwtService.ValidateToken(token)
-	if err != nil {
-		return "", err
-	}
-	claims := aToken.Claims.(jwt.MapClaims)
-	id := fmt.Sprintf("%v", claims["user_id"])
-	return id, nil
-}
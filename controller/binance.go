@@ -2,30 +2,25 @@ package controller
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/adshao/go-binance/v2"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
-	"github.com/myomyintko/strategy_robot/config"
 	"github.com/myomyintko/strategy_robot/dto"
 	"github.com/myomyintko/strategy_robot/helper"
-	"github.com/myomyintko/strategy_robot/repository"
 	"github.com/myomyintko/strategy_robot/service"
 )
 
-var client *binance.Client
+// IdempotencyKeyHeader names an order when the body has no idempotency_key
+const IdempotencyKeyHeader = "Idempotency-Key"
 
-func getBindKey(userId uint64) {
-	user := service.NewAPIService(repository.NewAPIRepository(config.SetupDatabaseConnection()))
-	res := user.FindByUserID(userId)
-	apiKey := res.APIKey
-	secretKey := res.SecretKey
-	client = binance.NewClient(apiKey, secretKey)
+func (c *binanceController) getBindKey(userId uint64) (*binance.Client, error) {
+	return c.clients.ForUser(userId)
 }
 
 type BinanceController interface {
@@ -41,118 +36,103 @@ type BinanceController interface {
 	ListOrders(context *gin.Context)
 	WsListKline(context *gin.Context)
 	GetAccount(context *gin.Context)
+	ListDiscrepancies(context *gin.Context)
 }
 
+//symbolInfoLevels is how many levels per side GetSymbolInfo returns, the default of the REST depth call it replaced
+const symbolInfoLevels = 100
+
 type binanceController struct {
+	// ctx ends streams when the app shuts down
+	ctx            context.Context
 	binanceService service.BinanceService
-	jwtService     service.JWTService
+	apiService     service.APIService
+	robotService   service.RobotService
+	clients        service.ClientRegistry
+	caller         service.BinanceCaller
+	orderBooks     service.OrderBookService
 }
 
-func NewBinanceController(binSer service.BinanceService, jwtSer service.JWTService) BinanceController {
+func NewBinanceController(ctx context.Context, binSer service.BinanceService, apiSer service.APIService, robotSer service.RobotService, clients service.ClientRegistry, caller service.BinanceCaller, books service.OrderBookService) BinanceController {
 	return &binanceController{
+		ctx:            ctx,
 		binanceService: binSer,
-		jwtService:     jwtSer,
+		apiService:     apiSer,
+		robotService:   robotSer,
+		clients:        clients,
+		caller:         caller,
+		orderBooks:     books,
 	}
 }
 
 func (c *binanceController) StartUserStream(ctx *gin.Context) {
 	var bindStreamDTO dto.BindStreamDTO
-	authHeader := ctx.GetHeader("Authorization")
-	splitToken := strings.Split(authHeader, "Bearer ")
-	authHeader = splitToken[1]
-	token, errToken := c.jwtService.ValidateToken(authHeader)
-	if errToken != nil {
-		response := helper.BuildErrorResponse("Token Error", errToken.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
-		return
-	}
-	claims := token.Claims.(jwt.MapClaims)
-	userID, err := strconv.ParseUint(fmt.Sprintf("%v", claims["user_id"]), 10, 64)
+	userID := helper.CurrentPrincipal(ctx).UserID
+	client, err := c.getBindKey(userID)
 	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, err)
 		return
 	}
-	getBindKey(userID)
-	res, err := client.NewStartUserStreamService().Do(context.Background())
+	var res string
+	err = c.caller.Call(ctx.Request.Context(), client, true, func(client *binance.Client) (err error) {
+		res, err = client.NewStartUserStreamService().Do(ctx.Request.Context())
+		return err
+	})
 	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, service.ExchangeError("Failed to start user stream", err))
 		return
 	}
 	if res == "" {
-		response := helper.BuildErrorResponse("Parameter 'listenKey' was empty.", "listenKey", helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, helper.ExchangeError("Parameter 'listenKey' was empty.", 0, errors.New("listenKey")))
 		return
 	}
 	bindStreamDTO.UserID = userID
 	bindStreamDTO.StreamKey = res
 	bindStreamDTO.StreamedAt = time.Now()
-	api := service.NewAPIService(repository.NewAPIRepository(config.SetupDatabaseConnection()))
-	result := api.BindStream(bindStreamDTO)
+	result, err := c.apiService.BindStream(bindStreamDTO)
+	if err != nil {
+		helper.Fail(ctx, err)
+		return
+	}
 	response := helper.BuildResponse(true, "Stream Successfully", result)
 	ctx.JSON(http.StatusOK, response)
 }
 
 func (c *binanceController) KeepAliveUserStream(ctx *gin.Context) {
-	authHeader := ctx.GetHeader("Authorization")
-	splitToken := strings.Split(authHeader, "Bearer ")
-	authHeader = splitToken[1]
-	token, errToken := c.jwtService.ValidateToken(authHeader)
-	if errToken != nil {
-		response := helper.BuildErrorResponse("Token Error", errToken.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
-		return
-	}
-	claims := token.Claims.(jwt.MapClaims)
-	userID, UserIdErr := strconv.ParseUint(fmt.Sprintf("%v", claims["user_id"]), 10, 64)
-	if UserIdErr != nil {
-		response := helper.BuildErrorResponse("error", UserIdErr.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+	userID := helper.CurrentPrincipal(ctx).UserID
+	client, err := c.getBindKey(userID)
+	if err != nil {
+		helper.Fail(ctx, err)
 		return
 	}
-	getBindKey(userID)
 
 	streamKey := c.getStreamKey(userID)
 	if streamKey == "" {
-		response := helper.BuildErrorResponse("Stream Key was Empty", "", helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, helper.NotFoundError("Stream Key was Empty", errors.New("Start a user stream first")))
 		return
 	}
 
-	stream := client.NewKeepaliveUserStreamService().ListenKey(streamKey).Do(context.Background())
-	response := helper.BuildResponse(true, "User Stream Keep Alive Success", stream)
-	ctx.JSON(http.StatusBadRequest, response)
+	err = c.caller.Call(ctx.Request.Context(), client, true, func(client *binance.Client) error {
+		return client.NewKeepaliveUserStreamService().ListenKey(streamKey).Do(ctx.Request.Context())
+	})
+	if err != nil {
+		helper.Fail(ctx, service.ExchangeError("Failed to keep user stream alive", err))
+		return
+	}
+	response := helper.BuildResponse(true, "User Stream Keep Alive Success", helper.EmptyObj{})
+	ctx.JSON(http.StatusOK, response)
 }
 
+//GetSymbolInfo is the order book of symbol, served from the local book instead of a REST depth call
 func (c *binanceController) GetSymbolInfo(ctx *gin.Context) {
-	authHeader := ctx.GetHeader("Authorization")
-	splitToken := strings.Split(authHeader, "Bearer ")
-	authHeader = splitToken[1]
-	token, errToken := c.jwtService.ValidateToken(authHeader)
-	if errToken != nil {
-		response := helper.BuildErrorResponse("Token Error", errToken.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
-		return
-	}
-	claims := token.Claims.(jwt.MapClaims)
-	userID, UserIDErr := strconv.ParseUint(fmt.Sprintf("%v", claims["user_id"]), 10, 64)
-	if UserIDErr != nil {
-		response := helper.BuildErrorResponse("error", UserIDErr.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
-		return
-	}
-	getBindKey(userID)
 	var symbol = ctx.Query("symbol")
 	if symbol == "" {
-		response := helper.BuildErrorResponse("Symbol was empty", "Param was error", helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, helper.ValidationError("Symbol was empty", errors.New("Param was error")))
+		return
 	}
-	res, err := client.NewDepthService().Symbol(symbol).
-		Do(context.Background())
+	res, err := c.orderBooks.Depth(ctx.Request.Context(), symbol, symbolInfoLevels)
 	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, err)
 		return
 	}
 	response := helper.BuildResponse(true, "Symbol Info of "+symbol, res)
@@ -160,135 +140,104 @@ func (c *binanceController) GetSymbolInfo(ctx *gin.Context) {
 }
 
 func (c *binanceController) GetCrypto(ctx *gin.Context) {
-	authHeader := ctx.GetHeader("Authorization")
-	splitToken := strings.Split(authHeader, "Bearer ")
-	authHeader = splitToken[1]
-	token, errToken := c.jwtService.ValidateToken(authHeader)
-	if errToken != nil {
-		response := helper.BuildErrorResponse("Token Error", errToken.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
-		return
-	}
-	claims := token.Claims.(jwt.MapClaims)
-	userID, UserIDErr := strconv.ParseUint(fmt.Sprintf("%v", claims["user_id"]), 10, 64)
-	if UserIDErr != nil {
-		response := helper.BuildErrorResponse("error", UserIDErr.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+	userID := helper.CurrentPrincipal(ctx).UserID
+	client, err := c.getBindKey(userID)
+	if err != nil {
+		helper.Fail(ctx, err)
 		return
 	}
-	getBindKey(userID)
-	crypto, err := client.NewGetDepositAddressService().Coin("BTC").Do(context.Background())
+	var crypto *binance.GetDepositAddressResponse
+	err = c.caller.Call(ctx.Request.Context(), client, true, func(client *binance.Client) (err error) {
+		crypto, err = client.NewGetDepositAddressService().Coin("BTC").Do(ctx.Request.Context())
+		return err
+	})
 	if err != nil {
-		response := helper.BuildErrorResponse("NewListDepositsService error", err.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, service.ExchangeError("NewListDepositsService error", err))
 		return
 	}
-	fmt.Println(crypto)
 	response := helper.BuildResponse(true, "Deposit Address for "+crypto.Coin, crypto)
-	ctx.JSON(http.StatusBadRequest, response)
+	ctx.JSON(http.StatusOK, response)
 }
 
 func (c *binanceController) CreateOrder(ctx *gin.Context) {
 	var orderCreateDTO dto.CreateOrderDTO
 	errDTO := ctx.ShouldBind(&orderCreateDTO)
 	if errDTO != nil {
-		res := helper.BuildErrorResponse("Failed to process request", errDTO.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, res)
-	}
-	authHeader := ctx.GetHeader("Authorization")
-	splitToken := strings.Split(authHeader, "Bearer ")
-	authHeader = splitToken[1]
-	token, errToken := c.jwtService.ValidateToken(authHeader)
-	if errToken != nil {
-		response := helper.BuildErrorResponse("Token Error", errToken.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, helper.ValidationError("Failed to process request", errDTO))
 		return
 	}
-	claims := token.Claims.(jwt.MapClaims)
-	userID, err := strconv.ParseUint(fmt.Sprintf("%v", claims["user_id"]), 10, 64)
+	userID := helper.CurrentPrincipal(ctx).UserID
+	client, err := c.getBindKey(userID)
 	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, err)
 		return
 	}
-	getBindKey(userID)
 
 	robotID, _ := strconv.ParseUint(ctx.Query("robot"), 10, 64)
-	if CheckRobotByUser(robotID, userID) == "" {
-		response := helper.BuildErrorResponse("error", "Invalid user or no robot", helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+	if c.robotSymbol(robotID, userID) == "" {
+		helper.Fail(ctx, helper.NotFoundError("Robot not found", errors.New("Invalid user or no robot")))
 		return
 	}
-	symbol := CheckRobotByUser(robotID, userID)
+	symbol := c.robotSymbol(robotID, userID)
 	sideType := ctx.Query("type")
 	if sideType == "" {
-		response := helper.BuildErrorResponse("Type is empty", "there is no param with type", helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, helper.ValidationError("Type is empty", errors.New("there is no param with type")))
 		return
 	}
-
-	order, err := client.NewCreateOrderService().Symbol(symbol).
-		Side(binance.SideType(sideType)).Type(binance.OrderTypeLimit).
-		TimeInForce(binance.TimeInForceTypeGTC).Quantity(orderCreateDTO.Quantity).
-		Price(orderCreateDTO.Price).Do(context.Background())
-	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+	if orderCreateDTO.IdempotencyKey == "" {
+		orderCreateDTO.IdempotencyKey = ctx.GetHeader(IdempotencyKeyHeader)
+	}
+	if orderCreateDTO.IdempotencyKey == "" || len(orderCreateDTO.IdempotencyKey) > 64 {
+		helper.Fail(ctx, helper.ValidationError("Idempotency key is required", errors.New("Send 1 to 64 characters as idempotency_key or the "+IdempotencyKeyHeader+" header")))
 		return
 	}
 
-	orderCreateDTO.OrderId = order.OrderID
-	orderCreateDTO.ClientOrderId = order.ClientOrderID
+	orderCreateDTO.Symbol = symbol
+	orderCreateDTO.Side = strings.ToUpper(sideType)
 	orderCreateDTO.RobotID = robotID
+	orderCreateDTO.UserID = userID
 
-	result := c.binanceService.Insert(orderCreateDTO)
+	result, err := c.binanceService.PlaceOrder(ctx.Request.Context(), client, orderCreateDTO)
+	if err != nil {
+		helper.Fail(ctx, err)
+		return
+	}
 	response := helper.BuildResponse(true, "Order was created successful", result)
 	ctx.JSON(http.StatusCreated, response)
 }
 
 func (c *binanceController) GetOrder(ctx *gin.Context) {
-	authHeader := ctx.GetHeader("Authorization")
-	splitToken := strings.Split(authHeader, "Bearer ")
-	authHeader = splitToken[1]
-	token, errToken := c.jwtService.ValidateToken(authHeader)
-	if errToken != nil {
-		response := helper.BuildErrorResponse("Token Error", errToken.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
-		return
-	}
-	claims := token.Claims.(jwt.MapClaims)
-	userID, err := strconv.ParseUint(fmt.Sprintf("%v", claims["user_id"]), 10, 64)
+	userID := helper.CurrentPrincipal(ctx).UserID
+	client, err := c.getBindKey(userID)
 	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, err)
 		return
 	}
-	getBindKey(userID)
 
 	robotID, err := strconv.ParseUint(ctx.Query("robot"), 10, 64)
 	if err != nil {
-		response := helper.BuildErrorResponse("ParseUint error", err.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, helper.ValidationError("ParseUint error", err))
 		return
 	}
-	if CheckRobotByUser(robotID, userID) == "" {
-		response := helper.BuildErrorResponse("error", "Invalid user or no robot", helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+	if c.robotSymbol(robotID, userID) == "" {
+		helper.Fail(ctx, helper.NotFoundError("Robot not found", errors.New("Invalid user or no robot")))
 		return
 	}
 
-	symbol := CheckRobotByUser(robotID, userID)
+	symbol := c.robotSymbol(robotID, userID)
 	orderId, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
 	if err != nil {
-		response := helper.BuildErrorResponse("ParseInt error", err.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, helper.ValidationError("ParseInt error", err))
 		return
 	}
-	order, err := client.NewGetOrderService().Symbol(symbol).
-		OrderID(orderId).Do(context.Background())
+	var order *binance.Order
+	err = c.caller.Call(ctx.Request.Context(), client, true, func(client *binance.Client) (err error) {
+		order, err = client.NewGetOrderService().Symbol(symbol).
+			OrderID(orderId).Do(ctx.Request.Context())
+		return err
+	})
 	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, service.ExchangeError("Failed to load order", err))
 		return
 	}
 	response := helper.BuildResponse(true, "Order", order)
@@ -296,42 +245,32 @@ func (c *binanceController) GetOrder(ctx *gin.Context) {
 }
 
 func (c *binanceController) CancelOrder(ctx *gin.Context) {
-	authHeader := ctx.GetHeader("Authorization")
-	splitToken := strings.Split(authHeader, "Bearer ")
-	authHeader = splitToken[1]
-	token, errToken := c.jwtService.ValidateToken(authHeader)
-	if errToken != nil {
-		response := helper.BuildErrorResponse("Token Error", errToken.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
-		return
-	}
-	claims := token.Claims.(jwt.MapClaims)
-	userID, err := strconv.ParseUint(fmt.Sprintf("%v", claims["user_id"]), 10, 64)
+	userID := helper.CurrentPrincipal(ctx).UserID
+	client, err := c.getBindKey(userID)
 	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, err)
 		return
 	}
-	getBindKey(userID)
 
 	robotID, _ := strconv.ParseUint(ctx.Query("robot"), 10, 64)
-	if CheckRobotByUser(robotID, userID) == "" {
-		response := helper.BuildErrorResponse("error", "Invalid user or no robot", helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+	if c.robotSymbol(robotID, userID) == "" {
+		helper.Fail(ctx, helper.NotFoundError("Robot not found", errors.New("Invalid user or no robot")))
 		return
 	}
-	symbol := CheckRobotByUser(robotID, userID)
+	symbol := c.robotSymbol(robotID, userID)
 	orderId, IdError := strconv.ParseInt(ctx.Param("id"), 10, 64)
 	if IdError != nil {
-		response := helper.BuildErrorResponse("Invalid order id", IdError.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, helper.ValidationError("Invalid order id", IdError))
 		return
 	}
-	_, binanceErr := client.NewCancelOrderService().Symbol(symbol).
-		OrderID(orderId).Do(context.Background())
+	// a resent cancel would report an unknown order once the first one went through
+	binanceErr := c.caller.Call(ctx.Request.Context(), client, false, func(client *binance.Client) error {
+		_, err := client.NewCancelOrderService().Symbol(symbol).
+			OrderID(orderId).Do(ctx.Request.Context())
+		return err
+	})
 	if binanceErr != nil {
-		response := helper.BuildErrorResponse("There is no order", binanceErr.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, service.ExchangeError("There is no order", binanceErr))
 		return
 	}
 	response := helper.BuildResponse(true, "Order cancel successful", helper.EmptyObj{})
@@ -339,36 +278,27 @@ func (c *binanceController) CancelOrder(ctx *gin.Context) {
 }
 
 func (c *binanceController) ListOpenOrders(ctx *gin.Context) {
-	authHeader := ctx.GetHeader("Authorization")
-	splitToken := strings.Split(authHeader, "Bearer ")
-	authHeader = splitToken[1]
-	token, errToken := c.jwtService.ValidateToken(authHeader)
-	if errToken != nil {
-		response := helper.BuildErrorResponse("Token Error", errToken.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
-		return
-	}
-	claims := token.Claims.(jwt.MapClaims)
-	userID, err := strconv.ParseUint(fmt.Sprintf("%v", claims["user_id"]), 10, 64)
+	userID := helper.CurrentPrincipal(ctx).UserID
+	client, err := c.getBindKey(userID)
 	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, err)
 		return
 	}
-	getBindKey(userID)
 
 	robotID, _ := strconv.ParseUint(ctx.Query("robot"), 10, 64)
-	if CheckRobotByUser(robotID, userID) == "" {
-		response := helper.BuildErrorResponse("error", "Invalid user or no robot", helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+	if c.robotSymbol(robotID, userID) == "" {
+		helper.Fail(ctx, helper.NotFoundError("Robot not found", errors.New("Invalid user or no robot")))
 		return
 	}
-	symbol := CheckRobotByUser(robotID, userID)
-	openOrders, err := client.NewListOpenOrdersService().Symbol(symbol).
-		Do(context.Background())
+	symbol := c.robotSymbol(robotID, userID)
+	var openOrders []*binance.Order
+	err = c.caller.Call(ctx.Request.Context(), client, true, func(client *binance.Client) (err error) {
+		openOrders, err = client.NewListOpenOrdersService().Symbol(symbol).
+			Do(ctx.Request.Context())
+		return err
+	})
 	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, service.ExchangeError("Failed to list open orders", err))
 		return
 	}
 	response := helper.BuildResponse(true, "Open orders", openOrders)
@@ -376,36 +306,27 @@ func (c *binanceController) ListOpenOrders(ctx *gin.Context) {
 }
 
 func (c *binanceController) ListOrders(ctx *gin.Context) {
-	authHeader := ctx.GetHeader("Authorization")
-	splitToken := strings.Split(authHeader, "Bearer ")
-	authHeader = splitToken[1]
-	token, errToken := c.jwtService.ValidateToken(authHeader)
-	if errToken != nil {
-		response := helper.BuildErrorResponse("Token Error", errToken.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
-		return
-	}
-	claims := token.Claims.(jwt.MapClaims)
-	userID, err := strconv.ParseUint(fmt.Sprintf("%v", claims["user_id"]), 10, 64)
+	userID := helper.CurrentPrincipal(ctx).UserID
+	client, err := c.getBindKey(userID)
 	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, err)
 		return
 	}
-	getBindKey(userID)
 
 	robotID, _ := strconv.ParseUint(ctx.Query("robot"), 10, 64)
-	if CheckRobotByUser(robotID, userID) == "" {
-		response := helper.BuildErrorResponse("error", "Invalid user or no robot", helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+	if c.robotSymbol(robotID, userID) == "" {
+		helper.Fail(ctx, helper.NotFoundError("Robot not found", errors.New("Invalid user or no robot")))
 		return
 	}
-	symbol := CheckRobotByUser(robotID, userID)
-	orders, err := client.NewListOrdersService().Symbol(symbol).
-		Do(context.Background())
+	symbol := c.robotSymbol(robotID, userID)
+	var orders []*binance.Order
+	err = c.caller.Call(ctx.Request.Context(), client, true, func(client *binance.Client) (err error) {
+		orders, err = client.NewListOrdersService().Symbol(symbol).
+			Do(ctx.Request.Context())
+		return err
+	})
 	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, service.ExchangeError("Failed to list orders", err))
 		return
 	}
 	response := helper.BuildResponse(true, "List Orders", orders)
@@ -413,45 +334,44 @@ func (c *binanceController) ListOrders(ctx *gin.Context) {
 }
 
 func (c *binanceController) WsListKline(ctx *gin.Context) {
-	authHeader := ctx.GetHeader("Authorization")
-	splitToken := strings.Split(authHeader, "Bearer ")
-	authHeader = splitToken[1]
-	token, errToken := c.jwtService.ValidateToken(authHeader)
-	if errToken != nil {
-		response := helper.BuildErrorResponse("Token Error", errToken.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
-		return
-	}
-	claims := token.Claims.(jwt.MapClaims)
-	userID, err := strconv.ParseUint(fmt.Sprintf("%v", claims["user_id"]), 10, 64)
+	userID := helper.CurrentPrincipal(ctx).UserID
+	_, err := c.getBindKey(userID)
 	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, err)
 		return
 	}
-	getBindKey(userID)
 	robotID, _ := strconv.ParseUint(ctx.Query("robot"), 10, 64)
-	if CheckRobotByUser(robotID, userID) == "" {
-		response := helper.BuildErrorResponse("error", "Invalid user or no robot", helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+	if c.robotSymbol(robotID, userID) == "" {
+		helper.Fail(ctx, helper.NotFoundError("Robot not found", errors.New("Invalid user or no robot")))
 		return
 	}
-	symbol := CheckRobotByUser(robotID, userID)
+	symbol := c.robotSymbol(robotID, userID)
 
 	var interval = ctx.Query("interval")
 
+	// events are relayed as server-sent events, the handler waits below until the
+	// stream is stopped so nothing is written after it returned
 	wsKlineHandler := func(event *binance.WsKlineEvent) {
-		fmt.Println(event)
+		ctx.SSEvent("kline", event)
+		ctx.Writer.Flush()
 	}
 	errHandler := func(err error) {
-		fmt.Println(err)
+		log.Printf("Kline stream of %s failed: %v", symbol, err)
 	}
-	doneC, _, err := binance.WsKlineServe(symbol, interval, wsKlineHandler, errHandler)
+	doneC, stopC, err := binance.WsKlineServe(symbol, interval, wsKlineHandler, errHandler)
 	if err != nil {
-		fmt.Println(err)
+		helper.Fail(ctx, helper.UpstreamError("Failed to open kline stream", err))
 		return
 	}
-	<-doneC
+	select {
+	case <-doneC:
+	case <-ctx.Request.Context().Done():
+		close(stopC)
+		<-doneC
+	case <-c.ctx.Done():
+		close(stopC)
+		<-doneC
+	}
 }
 
 //WebSocket
@@ -514,87 +434,70 @@ func (c *binanceController) WsListKline(ctx *gin.Context) {
 //}
 
 func (c *binanceController) GetAccount(ctx *gin.Context) {
-	authHeader := ctx.GetHeader("Authorization")
-	splitToken := strings.Split(authHeader, "Bearer ")
-	authHeader = splitToken[1]
-	token, errToken := c.jwtService.ValidateToken(authHeader)
-	if errToken != nil {
-		response := helper.BuildErrorResponse("Token Error", errToken.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
-		return
-	}
-	claims := token.Claims.(jwt.MapClaims)
-	userID, err := strconv.ParseUint(fmt.Sprintf("%v", claims["user_id"]), 10, 64)
+	userID := helper.CurrentPrincipal(ctx).UserID
+	client, err := c.getBindKey(userID)
 	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, err)
 		return
 	}
-	getBindKey(userID)
 
-	res, err := client.NewGetAccountService().Do(context.Background())
+	var res *binance.Account
+	err = c.caller.Call(ctx.Request.Context(), client, true, func(client *binance.Client) (err error) {
+		res, err = client.NewGetAccountService().Do(ctx.Request.Context())
+		return err
+	})
 	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, service.ExchangeError("Failed to load account", err))
 		return
 	}
 	response := helper.BuildResponse(true, "Account info", res)
 	ctx.JSON(http.StatusOK, response)
 }
 func (c *binanceController) WsListOrdes(ctx *gin.Context) {
-	authHeader := ctx.GetHeader("Authorization")
-	splitToken := strings.Split(authHeader, "Bearer ")
-	authHeader = splitToken[1]
-	token, errToken := c.jwtService.ValidateToken(authHeader)
-	if errToken != nil {
-		response := helper.BuildErrorResponse("Token Error", errToken.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
-		return
-	}
-	claims := token.Claims.(jwt.MapClaims)
-	userID, err := strconv.ParseUint(fmt.Sprintf("%v", claims["user_id"]), 10, 64)
+	userID := helper.CurrentPrincipal(ctx).UserID
+	client, err := c.getBindKey(userID)
 	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, err)
 		return
 	}
-	getBindKey(userID)
 
 	robotID, _ := strconv.ParseUint(ctx.Query("robot"), 10, 64)
-	if CheckRobotByUser(robotID, userID) == "" {
-		response := helper.BuildErrorResponse("error", "Invalid user or no robot", helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
-		return
-	}
-	symbol := CheckRobotByUser(robotID, userID)
-	trades, err := client.NewAggTradesService().
-		Symbol(symbol).StartTime(1508673256594).EndTime(1508673256595).
-		Do(context.Background())
+	if c.robotSymbol(robotID, userID) == "" {
+		helper.Fail(ctx, helper.NotFoundError("Robot not found", errors.New("Invalid user or no robot")))
+		return
+	}
+	symbol := c.robotSymbol(robotID, userID)
+	var trades []*binance.AggTrade
+	err = c.caller.Call(ctx.Request.Context(), client, true, func(client *binance.Client) (err error) {
+		trades, err = client.NewAggTradesService().
+			Symbol(symbol).StartTime(1508673256594).EndTime(1508673256595).
+			Do(ctx.Request.Context())
+		return err
+	})
 	if err != nil {
-		response := helper.BuildErrorResponse("Ws list orders error", err.Error(), helper.EmptyObj{})
-		ctx.JSON(http.StatusBadRequest, response)
+		helper.Fail(ctx, service.ExchangeError("Ws list orders error", err))
 		return
 	}
 	response := helper.BuildResponse(true, "WS List Orders", trades)
 	ctx.JSON(http.StatusOK, response)
 }
 
-func CheckRobotByUser(robotID, userId uint64) string {
-	robot := service.NewRobotService(repository.NewRobotRepository(config.SetupDatabaseConnection()))
-	res := robot.FindByID(robotID)
-	robotSymbol := ""
-	if res.UserID != userId {
-		return robotSymbol
-	}
-	robotSymbol = res.Symbol
-	return robotSymbol
+//robotSymbol is the symbol robotID trades, empty when it is not a robot of userId
+func (c *binanceController) robotSymbol(robotID, userId uint64) string {
+	return c.robotService.FindByID(userId, robotID).Symbol
 }
 
 func (c *binanceController) getStreamKey(userId uint64) string {
-	key := service.NewAPIService(repository.NewAPIRepository(config.SetupDatabaseConnection()))
-	res := key.FindByUserID(userId)
+	res := c.apiService.FindByUserID(userId)
 	if res.StreamKey == "" {
 		return ""
 	}
 	return res.StreamKey
 }
+
+//ListDiscrepancies shows what order reconciliation found and repaired for the user
+func (c *binanceController) ListDiscrepancies(ctx *gin.Context) {
+	userID := helper.CurrentPrincipal(ctx).UserID
+	response := helper.BuildResponse(true, "Order discrepancies", c.binanceService.Discrepancies(userID))
+	ctx.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/myomyintko/strategy_robot/helper"
+	"github.com/myomyintko/strategy_robot/model"
+	"github.com/myomyintko/strategy_robot/service"
+)
+
+//defaultDepthLevels is how many levels per side a depth request returns without levels
+const defaultDepthLevels = 20
+
+//maxDepthLevels caps levels
+const maxDepthLevels = 1000
+
+//MarketController serves market data from the local books and the ticker cache
+type MarketController interface {
+	Depth(context *gin.Context)
+	Tickers(context *gin.Context)
+}
+
+type marketController struct {
+	orderBookService  service.OrderBookService
+	marketDataService service.MarketDataService
+}
+
+func NewMarketController(orderBookServ service.OrderBookService, marketDataServ service.MarketDataService) MarketController {
+	return &marketController{
+		orderBookService:  orderBookServ,
+		marketDataService: marketDataServ,
+	}
+}
+
+//Depth is the top of the book of symbol, with quantity given it also estimates a buy and a sell of it
+func (c *marketController) Depth(context *gin.Context) {
+	symbol := context.Query("symbol")
+	if symbol == "" {
+		helper.Fail(context, helper.ValidationError("Symbol was empty", errors.New("Param was error")))
+		return
+	}
+	levels, err := strconv.Atoi(context.DefaultQuery("levels", strconv.Itoa(defaultDepthLevels)))
+	if err != nil || levels < 1 || levels > maxDepthLevels {
+		helper.Fail(context, helper.ValidationError("Invalid levels", errors.New("levels must be between 1 and 1000")))
+		return
+	}
+	depth, err := c.orderBookService.Depth(context.Request.Context(), symbol, levels)
+	if err != nil {
+		helper.Fail(context, err)
+		return
+	}
+	res := gin.H{"depth": depth}
+	if raw := context.Query("quantity"); raw != "" {
+		quantity, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			helper.Fail(context, helper.ValidationError("Invalid quantity", err))
+			return
+		}
+		fills := []model.FillEstimate{}
+		for _, side := range []binance.SideType{binance.SideTypeBuy, binance.SideTypeSell} {
+			fill, err := c.orderBookService.EstimateFill(context.Request.Context(), symbol, string(side), quantity)
+			if err != nil {
+				helper.Fail(context, err)
+				return
+			}
+			fills = append(fills, fill)
+		}
+		res["fills"] = fills
+	}
+	context.JSON(http.StatusOK, helper.BuildResponse(true, "OK", res))
+}
+
+//Tickers lists the cached ticker of every symbol, or of the comma separated symbols given
+func (c *marketController) Tickers(context *gin.Context) {
+	var symbols []string
+	for _, symbol := range strings.Split(context.Query("symbols"), ",") {
+		if symbol = strings.TrimSpace(symbol); symbol != "" {
+			symbols = append(symbols, symbol)
+		}
+	}
+	context.JSON(http.StatusOK, helper.BuildResponse(true, "OK", c.marketDataService.Tickers(symbols...)))
+}
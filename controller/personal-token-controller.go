@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myomyintko/strategy_robot/dto"
+	"github.com/myomyintko/strategy_robot/helper"
+	"github.com/myomyintko/strategy_robot/service"
+)
+
+//PersonalTokenController manages the personal access tokens of the authenticated user
+type PersonalTokenController interface {
+	All(context *gin.Context)
+	Insert(context *gin.Context)
+	Delete(context *gin.Context)
+}
+
+type personalTokenController struct {
+	tokenService service.PersonalTokenService
+}
+
+func NewPersonalTokenController(tokenServ service.PersonalTokenService) PersonalTokenController {
+	return &personalTokenController{
+		tokenService: tokenServ,
+	}
+}
+
+func (c *personalTokenController) All(context *gin.Context) {
+	tokens := c.tokenService.FindByUserID(helper.CurrentPrincipal(context).UserID)
+	res := helper.BuildResponse(true, "OK", tokens)
+	context.JSON(http.StatusOK, res)
+}
+
+func (c *personalTokenController) Insert(context *gin.Context) {
+	var tokenCreateDTO dto.PersonalTokenCreateDTO
+	if errDTO := context.ShouldBind(&tokenCreateDTO); errDTO != nil {
+		helper.Fail(context, helper.ValidationError("Failed to process request", errDTO))
+		return
+	}
+	token, err := c.tokenService.Create(helper.CurrentPrincipal(context).UserID, tokenCreateDTO)
+	if err != nil {
+		helper.Fail(context, err)
+		return
+	}
+	res := helper.BuildResponse(true, "Store this token, it is shown once", token)
+	context.JSON(http.StatusCreated, res)
+}
+
+func (c *personalTokenController) Delete(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		helper.Fail(context, helper.ValidationError("No param id was found", err))
+		return
+	}
+	if !c.tokenService.Revoke(helper.CurrentPrincipal(context).UserID, id) {
+		helper.Fail(context, helper.NotFoundError("Data not found", errors.New("No active token with given id")))
+		return
+	}
+	res := helper.BuildResponse(true, "Revoked", helper.EmptyObj{})
+	context.JSON(http.StatusOK, res)
+}
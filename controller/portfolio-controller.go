@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myomyintko/strategy_robot/helper"
+	"github.com/myomyintko/strategy_robot/model"
+	"github.com/myomyintko/strategy_robot/service"
+)
+
+//defaultHistoryWindow is the history returned when no from is given
+const defaultHistoryWindow = 30 * 24 * time.Hour
+
+//PortfolioController shows the value of the bound key, live and over time
+type PortfolioController interface {
+	Portfolio(context *gin.Context)
+	History(context *gin.Context)
+}
+
+type portfolioController struct {
+	portfolioService service.PortfolioService
+	clients          service.ClientRegistry
+}
+
+func NewPortfolioController(portfolioServ service.PortfolioService, clients service.ClientRegistry) PortfolioController {
+	return &portfolioController{
+		portfolioService: portfolioServ,
+		clients:          clients,
+	}
+}
+
+//Portfolio is the allocation by asset of the live balances
+func (c *portfolioController) Portfolio(context *gin.Context) {
+	quote, ok := quoteParam(context)
+	if !ok {
+		return
+	}
+	client, err := c.clients.ForUser(helper.CurrentPrincipal(context).UserID)
+	if err != nil {
+		helper.Fail(context, err)
+		return
+	}
+	portfolio, err := c.portfolioService.Valuate(context.Request.Context(), client, quote)
+	if err != nil {
+		helper.Fail(context, err)
+		return
+	}
+	res := helper.BuildResponse(true, "OK", portfolio)
+	context.JSON(http.StatusOK, res)
+}
+
+//History is the equity time series of the stored snapshots, from and to are RFC 3339
+func (c *portfolioController) History(context *gin.Context) {
+	quote, ok := quoteParam(context)
+	if !ok {
+		return
+	}
+	to, ok := timeParam(context, "to", time.Now())
+	if !ok {
+		return
+	}
+	from, ok := timeParam(context, "from", to.Add(-defaultHistoryWindow))
+	if !ok {
+		return
+	}
+	if from.After(to) {
+		helper.Fail(context, helper.ValidationError("Invalid range", fmt.Errorf("from %s is after to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))))
+		return
+	}
+	history := c.portfolioService.History(helper.CurrentPrincipal(context).UserID, quote, from, to)
+	res := helper.BuildResponse(true, "OK", history)
+	context.JSON(http.StatusOK, res)
+}
+
+func quoteParam(context *gin.Context) (string, bool) {
+	quote := strings.ToUpper(context.DefaultQuery("quote", model.QuoteUSDT))
+	if quote != model.QuoteUSDT && quote != model.QuoteBTC {
+		helper.Fail(context, helper.ValidationError("Invalid quote", fmt.Errorf("quote must be %s or %s", model.QuoteUSDT, model.QuoteBTC)))
+		return "", false
+	}
+	return quote, true
+}
+
+func timeParam(context *gin.Context, name string, def time.Time) (time.Time, bool) {
+	raw := context.Query(name)
+	if raw == "" {
+		return def, true
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		helper.Fail(context, helper.ValidationError("Invalid "+name, err))
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -1,12 +1,10 @@
 package controller
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
 	"strconv"
-	"strings"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/myomyintko/strategy_robot/dto"
 	"github.com/myomyintko/strategy_robot/helper"
@@ -14,11 +12,6 @@ import (
 	"github.com/myomyintko/strategy_robot/service"
 )
 
-var (
-	robot  model.Robot
-	robots []model.Robot
-)
-
 type RobotController interface {
 	All(context *gin.Context)
 	FindByID(context *gin.Context)
@@ -26,22 +19,25 @@ type RobotController interface {
 	Insert(context *gin.Context)
 	Update(context *gin.Context)
 	Delete(context *gin.Context)
+	Rebalance(context *gin.Context)
 }
 
 type robotController struct {
 	robotService service.RobotService
-	jwtService   service.JWTService
+	rebalancer   service.Rebalancer
+	clients      service.ClientRegistry
 }
 
-func NewRobotController(robotServ service.RobotService, jwtServ service.JWTService) RobotController {
+func NewRobotController(robotServ service.RobotService, rebalancer service.Rebalancer, clients service.ClientRegistry) RobotController {
 	return &robotController{
 		robotService: robotServ,
-		jwtService:   jwtServ,
+		rebalancer:   rebalancer,
+		clients:      clients,
 	}
 }
 
 func (c *robotController) All(context *gin.Context) {
-	robots = c.robotService.All()
+	robots := c.robotService.All()
 	res := helper.BuildResponse(true, "OK", robots)
 	context.JSON(http.StatusOK, res)
 }
@@ -49,12 +45,12 @@ func (c *robotController) All(context *gin.Context) {
 func (c *robotController) FindByID(context *gin.Context) {
 	id, err := strconv.ParseUint(context.Param("id"), 0, 0)
 	if err != nil {
-		res := helper.BuildErrorResponse("No param id was found", err.Error(), helper.EmptyObj{})
-		context.AbortWithStatusJSON(http.StatusBadRequest, res)
+		helper.Fail(context, helper.ValidationError("No param id was found", err))
 		return
 	}
+	userID := helper.CurrentPrincipal(context).UserID
 
-	robot = c.robotService.FindByID(id)
+	robot := c.robotService.FindByID(userID, id)
 	if robot.ID == 0 {
 		res := helper.BuildResponse(true, "No robot yet!", helper.EmptyObj{})
 		context.JSON(http.StatusOK, res)
@@ -65,24 +61,8 @@ func (c *robotController) FindByID(context *gin.Context) {
 }
 
 func (c *robotController) FindByUserID(context *gin.Context) {
-
-	authHeader := context.GetHeader("Authorization")
-	splitToken := strings.Split(authHeader, "Bearer ")
-	authHeader = splitToken[1]
-
-	userID, errToken := c.getUserIDByToken(authHeader)
-	if errToken != nil {
-		res := helper.BuildErrorResponse("Failed to process request", errToken.Error(), helper.EmptyObj{})
-		context.JSON(http.StatusBadRequest, res)
-		return
-	}
-	convertedUserID, err := strconv.ParseUint(userID, 10, 64)
-	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		context.JSON(http.StatusBadRequest, response)
-		return
-	}
-	robot = c.robotService.FindByUserID(convertedUserID)
+	userID := helper.CurrentPrincipal(context).UserID
+	robot := c.robotService.FindByUserID(userID)
 
 	if robot.ID == 0 {
 		res := helper.BuildResponse(true, "No robot yet!", helper.EmptyObj{})
@@ -97,32 +77,20 @@ func (c *robotController) Insert(context *gin.Context) {
 	var robotCreateDTO dto.RobotCreateDTO
 	errDTO := context.ShouldBind(&robotCreateDTO)
 	if errDTO != nil {
-		res := helper.BuildErrorResponse("Failed to process request", errDTO.Error(), helper.EmptyObj{})
-		context.JSON(http.StatusBadRequest, res)
+		helper.Fail(context, helper.ValidationError("Failed to process request", errDTO))
 		return
 	}
-	authHeader := context.GetHeader("Authorization")
-	splitToken := strings.Split(authHeader, "Bearer ")
-	authHeader = splitToken[1]
-	userID, errToken := c.getUserIDByToken(authHeader)
-	if errToken != nil {
-		res := helper.BuildErrorResponse("Failed to process request", errToken.Error(), helper.EmptyObj{})
-		context.JSON(http.StatusBadRequest, res)
+	userID := helper.CurrentPrincipal(context).UserID
+	if robotCreateDTO.Kind != model.RobotKindRebalance && c.robotService.IsUserExistRobot(robotCreateDTO.Symbol, userID) {
+		helper.Fail(context, helper.ConflictError("Failed to process request", errors.New("Duplicate Robot")))
 		return
 	}
-	convertedUserID, err := strconv.ParseUint(userID, 10, 64)
+	robotCreateDTO.UserID = userID
+	result, err := c.robotService.Insert(robotCreateDTO)
 	if err != nil {
-		res := helper.BuildErrorResponse("Parse Error", err.Error(), helper.EmptyObj{})
-		context.JSON(http.StatusBadRequest, res)
+		helper.Fail(context, err)
 		return
 	}
-	if c.robotService.IsUserExistRobot(robotCreateDTO.Symbol, convertedUserID) {
-		response := helper.BuildErrorResponse("Failed to process request", "Duplicate Robot", helper.EmptyObj{})
-		context.JSON(http.StatusConflict, response)
-		return
-	}
-	robotCreateDTO.UserID = convertedUserID
-	result := c.robotService.Insert(robotCreateDTO)
 	response := helper.BuildResponse(true, "OK", result)
 	context.JSON(http.StatusCreated, response)
 }
@@ -131,44 +99,29 @@ func (c *robotController) Update(context *gin.Context) {
 	var robotUpdateDTO dto.RobotUpdateDTO
 	errDTO := context.ShouldBind(&robotUpdateDTO)
 	if errDTO != nil {
-		res := helper.BuildErrorResponse("Failed to process request", errDTO.Error(), helper.EmptyObj{})
-		context.JSON(http.StatusBadRequest, res)
-		return
-	}
-	authHeader := context.GetHeader("Authorization")
-	splitToken := strings.Split(authHeader, "Bearer ")
-	authHeader = splitToken[1]
-
-	token, errToken := c.jwtService.ValidateToken(authHeader)
-	if errToken != nil {
-		response := helper.BuildErrorResponse("Token Error", errToken.Error(), helper.EmptyObj{})
-		context.JSON(http.StatusBadRequest, response)
-		return
-	}
-	claims := token.Claims.(jwt.MapClaims)
-	userID, err := strconv.ParseUint(fmt.Sprintf("%v", claims["user_id"]), 10, 64)
-	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		context.JSON(http.StatusBadRequest, response)
+		helper.Fail(context, helper.ValidationError("Failed to process request", errDTO))
 		return
 	}
+	userID := helper.CurrentPrincipal(context).UserID
 	robotID, ParamErr := strconv.ParseUint(context.Param("id"), 10, 64)
 	if ParamErr != nil {
-		response := helper.BuildErrorResponse("Param error", ParamErr.Error(), helper.EmptyObj{})
-		context.JSON(http.StatusBadRequest, response)
+		helper.Fail(context, helper.ValidationError("Param error", ParamErr))
 		return
 	}
 
 	if c.robotService.IsAllowedToEdit(userID, robotID) {
 		robotUpdateDTO.ID = robotID
 		robotUpdateDTO.UserID = userID
-		result := c.robotService.Update(robotUpdateDTO)
+		result, err := c.robotService.Update(robotUpdateDTO)
+		if err != nil {
+			helper.Fail(context, err)
+			return
+		}
 		response := helper.BuildResponse(true, "OK", result)
 		context.JSON(http.StatusOK, response)
 		return
 	} else {
-		response := helper.BuildErrorResponse("You dont have permission", "You are not the owner", helper.EmptyObj{})
-		context.JSON(http.StatusForbidden, response)
+		helper.Fail(context, helper.ForbiddenError("You dont have permission", errors.New("You are not the owner")))
 		return
 	}
 }
@@ -177,43 +130,44 @@ func (c *robotController) Delete(context *gin.Context) {
 	var robot model.Robot
 	id, err := strconv.ParseUint(context.Param("id"), 0, 0)
 	if err != nil {
-		response := helper.BuildErrorResponse("Failed tou get id", "No param id were found", helper.EmptyObj{})
-		context.JSON(http.StatusBadRequest, response)
-	}
-	robot.ID = id
-	authHeader := context.GetHeader("Authorization")
-	splitToken := strings.Split(authHeader, "Bearer ")
-	authHeader = splitToken[1]
-
-	token, errToken := c.jwtService.ValidateToken(authHeader)
-	if errToken != nil {
-		response := helper.BuildErrorResponse("Token Error", errToken.Error(), helper.EmptyObj{})
-		context.JSON(http.StatusBadRequest, response)
-		return
-	}
-	claims := token.Claims.(jwt.MapClaims)
-	userID, err := strconv.ParseUint(fmt.Sprintf("%v", claims["user_id"]), 10, 64)
-	if err != nil {
-		response := helper.BuildErrorResponse("error", err.Error(), helper.EmptyObj{})
-		context.JSON(http.StatusBadRequest, response)
+		helper.Fail(context, helper.ValidationError("Failed tou get id", errors.New("No param id were found")))
 		return
 	}
+	robot.ID = id
+	userID := helper.CurrentPrincipal(context).UserID
 	if !c.robotService.IsAllowedToEdit(userID, robot.ID) {
-		response := helper.BuildErrorResponse("You dont have permission", "You are not the owner", helper.EmptyObj{})
-		context.JSON(http.StatusForbidden, response)
+		helper.Fail(context, helper.ForbiddenError("You dont have permission", errors.New("You are not the owner")))
 		return
 	}
+	robot.UserID = userID
 	c.robotService.Delete(robot)
 	res := helper.BuildResponse(true, "Deleted", helper.EmptyObj{})
 	context.JSON(http.StatusAccepted, res)
 }
 
-func (c *robotController) getUserIDByToken(token string) (string, error) {
-	aToken, err := c.jwtService.ValidateToken(token)
+//Rebalance previews the trades a rebalance robot would place now without placing them
+func (c *robotController) Rebalance(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
 	if err != nil {
-		return "", err
+		helper.Fail(context, helper.ValidationError("No param id was found", err))
+		return
 	}
-	claims := aToken.Claims.(jwt.MapClaims)
-	id := fmt.Sprintf("%v", claims["user_id"])
-	return id, nil
+	userID := helper.CurrentPrincipal(context).UserID
+	robot := c.robotService.FindByID(userID, id)
+	if robot.ID == 0 {
+		helper.Fail(context, helper.NotFoundError("Robot not found", errors.New("Invalid user or no robot")))
+		return
+	}
+	client, err := c.clients.ForUser(userID)
+	if err != nil {
+		helper.Fail(context, err)
+		return
+	}
+	plan, err := c.rebalancer.Plan(context.Request.Context(), client, robot)
+	if err != nil {
+		helper.Fail(context, err)
+		return
+	}
+	res := helper.BuildResponse(true, "OK", plan)
+	context.JSON(http.StatusOK, res)
 }
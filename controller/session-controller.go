@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myomyintko/strategy_robot/helper"
+	"github.com/myomyintko/strategy_robot/service"
+)
+
+//SessionController lets a user see and revoke their logins
+type SessionController interface {
+	All(context *gin.Context)
+	Revoke(context *gin.Context)
+	RevokeAll(context *gin.Context)
+}
+
+type sessionController struct {
+	sessionService service.SessionService
+}
+
+func NewSessionController(sessionServ service.SessionService) SessionController {
+	return &sessionController{
+		sessionService: sessionServ,
+	}
+}
+
+func (c *sessionController) All(context *gin.Context) {
+	principal := helper.CurrentPrincipal(context)
+	sessions := c.sessionService.FindByUserID(principal.UserID)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == principal.SessionID
+	}
+	res := helper.BuildResponse(true, "OK", sessions)
+	context.JSON(http.StatusOK, res)
+}
+
+func (c *sessionController) Revoke(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		helper.Fail(context, helper.ValidationError("No param id was found", err))
+		return
+	}
+	if !c.sessionService.Revoke(helper.CurrentPrincipal(context).UserID, id) {
+		helper.Fail(context, helper.NotFoundError("Data not found", errors.New("No active session with given id")))
+		return
+	}
+	res := helper.BuildResponse(true, "Revoked", helper.EmptyObj{})
+	context.JSON(http.StatusOK, res)
+}
+
+func (c *sessionController) RevokeAll(context *gin.Context) {
+	c.sessionService.RevokeAll(helper.CurrentPrincipal(context).UserID)
+	res := helper.BuildResponse(true, "Revoked", helper.EmptyObj{})
+	context.JSON(http.StatusOK, res)
+}
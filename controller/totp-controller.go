@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myomyintko/strategy_robot/dto"
+	"github.com/myomyintko/strategy_robot/helper"
+	"github.com/myomyintko/strategy_robot/service"
+)
+
+//TOTPController manages two-factor enrollment of the authenticated user
+type TOTPController interface {
+	Enroll(context *gin.Context)
+	Activate(context *gin.Context)
+	Disable(context *gin.Context)
+}
+
+type totpController struct {
+	totpService service.TOTPService
+}
+
+func NewTOTPController(totpServ service.TOTPService) TOTPController {
+	return &totpController{
+		totpService: totpServ,
+	}
+}
+
+func (c *totpController) Enroll(context *gin.Context) {
+	secret, uri, err := c.totpService.Enroll(helper.CurrentPrincipal(context).UserID)
+	if err != nil {
+		helper.Fail(context, err)
+		return
+	}
+	res := helper.BuildResponse(true, "Confirm with a code to enable", gin.H{"secret": secret, "uri": uri})
+	context.JSON(http.StatusOK, res)
+}
+
+func (c *totpController) Activate(context *gin.Context) {
+	var codeDTO dto.TOTPCodeDTO
+	if errDTO := context.ShouldBind(&codeDTO); errDTO != nil {
+		helper.Fail(context, helper.ValidationError("Failed to process request", errDTO))
+		return
+	}
+	codes, err := c.totpService.Activate(helper.CurrentPrincipal(context).UserID, codeDTO.Code)
+	if err != nil {
+		helper.Fail(context, codeRejected("Failed to enable two-factor authentication", err))
+		return
+	}
+	res := helper.BuildResponse(true, "Store these recovery codes, they are shown once", gin.H{"recovery_codes": codes})
+	context.JSON(http.StatusOK, res)
+}
+
+func (c *totpController) Disable(context *gin.Context) {
+	var codeDTO dto.TOTPCodeDTO
+	if errDTO := context.ShouldBind(&codeDTO); errDTO != nil {
+		helper.Fail(context, helper.ValidationError("Failed to process request", errDTO))
+		return
+	}
+	if err := c.totpService.Disable(helper.CurrentPrincipal(context).UserID, codeDTO.Code); err != nil {
+		helper.Fail(context, codeRejected("Failed to disable two-factor authentication", err))
+		return
+	}
+	res := helper.BuildResponse(true, "Two-factor authentication disabled", helper.EmptyObj{})
+	context.JSON(http.StatusOK, res)
+}
+
+//codeRejected keeps a wrong code from looking like an expired login, the caller is signed in
+func codeRejected(message string, err error) error {
+	if errors.Is(err, service.ErrTOTPInvalid) {
+		return helper.UnprocessableError(message, err)
+	}
+	return err
+}
@@ -0,0 +1,6 @@
+package dto
+
+//RoleUpdateDTO is used by an admin to change the role of a user
+type RoleUpdateDTO struct {
+	Role string `json:"role" form:"role" binding:"required"`
+}
@@ -11,4 +11,26 @@ type RegisterDTO struct {
 type LoginDTO struct {
 	Email    string `json:"email" form:"email" binding:"required,email"`
 	Password string `json:"password" form:"password" binding:"required"`
+	Code     string `json:"code,omitempty" form:"code,omitempty"`
+}
+
+//TOTPCodeDTO is used when client confirms or disables two-factor authentication
+type TOTPCodeDTO struct {
+	Code string `json:"code" form:"code" binding:"required"`
+}
+
+//EmailTokenDTO carries a token mailed to the user
+type EmailTokenDTO struct {
+	Token string `json:"token" form:"token" binding:"required"`
+}
+
+//ForgotPasswordDTO is used when client post from /forgot-password url
+type ForgotPasswordDTO struct {
+	Email string `json:"email" form:"email" binding:"required,email"`
+}
+
+//ResetPasswordDTO is used when client post from /reset-password url
+type ResetPasswordDTO struct {
+	Token    string `json:"token" form:"token" binding:"required"`
+	Password string `json:"password" form:"password" binding:"required"`
 }
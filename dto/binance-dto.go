@@ -10,9 +10,9 @@ type APIUpdateDTO struct {
 }
 
 type BindStreamDTO struct {
-	ID        uint64 `json:"id" form:"id"`
-	UserID    uint64 `json:"user_id,omitempty"  form:"user_id,omitempty"`
-	StreamKey string `json:"stream" form:"stream" binding:"required"`
+	ID         uint64 `json:"id" form:"id"`
+	UserID     uint64 `json:"user_id,omitempty"  form:"user_id,omitempty"`
+	StreamKey  string `json:"stream" form:"stream" binding:"required"`
 	StreamedAt time.Time
 }
 
@@ -20,13 +20,16 @@ type APICreateDTO struct {
 	APIKey    string `json:"api" form:"api" binding:"required"`
 	SecretKey string `json:"secret" form:"secret" binding:"required"`
 	UserID    uint64 `json:"user_id,omitempty"  form:"user_id,omitempty"`
-	BoundAt time.Time
+	BoundAt   time.Time
 }
 
 type CreateOrderDTO struct {
-	OrderId       int64  `json:"order_id"`
-	ClientOrderId string `json:"client_order_id"`
-	Price         string `json:"price" form:"price" binding:"required"`
-	Quantity      string `json:"quantity" form:"quantity" binding:"required"`
-	RobotID       uint64 `json:"robot_id,omitempty" form:"robot_id,omitempty"`
+	// IdempotencyKey names the order, resending it never places a second one
+	IdempotencyKey string `json:"idempotency_key" form:"idempotency_key" binding:"max=64"`
+	Symbol         string `json:"symbol,omitempty" form:"symbol,omitempty"`
+	Side           string `json:"side,omitempty" form:"side,omitempty"`
+	Price          string `json:"price" form:"price" binding:"required"`
+	Quantity       string `json:"quantity" form:"quantity" binding:"required"`
+	RobotID        uint64 `json:"robot_id,omitempty" form:"robot_id,omitempty"`
+	UserID         uint64 `json:"user_id,omitempty" form:"user_id,omitempty"`
 }
@@ -0,0 +1,8 @@
+package dto
+
+//PersonalTokenCreateDTO is used when client post from /users/tokens url
+type PersonalTokenCreateDTO struct {
+	Name          string   `json:"name" form:"name" binding:"required"`
+	Scopes        []string `json:"scopes" form:"scopes" binding:"required"`
+	ExpiresInDays int      `json:"expires_in_days" form:"expires_in_days" binding:"omitempty,min=1,max=365"`
+}
@@ -1,12 +1,29 @@
 package dto
 
+//RobotSettingsDTO is what create and update share, symbol robots need Symbol and rebalance robots Targets
+type RobotSettingsDTO struct {
+	Kind            string           `json:"kind" form:"kind" binding:"omitempty,oneof=symbol rebalance"`
+	Symbol          string           `json:"symbol" form:"symbol"`
+	QuoteAsset      string           `json:"quote_asset" form:"quote_asset"`
+	Targets         []RobotTargetDTO `json:"targets" form:"targets" binding:"dive"`
+	Threshold       float64          `json:"threshold" form:"threshold" binding:"gte=0,lte=100"`
+	IntervalMinutes int              `json:"interval_minutes" form:"interval_minutes" binding:"gte=0"`
+	MaxTurnover     float64          `json:"max_turnover" form:"max_turnover" binding:"gte=0,lte=100"`
+}
+
+//RobotTargetDTO is the weight in percent a rebalance robot gives Asset
+type RobotTargetDTO struct {
+	Asset  string  `json:"asset" form:"asset" binding:"required,max=16"`
+	Weight float64 `json:"weight" form:"weight" binding:"gt=0,lte=100"`
+}
+
 type RobotUpdateDTO struct {
-	ID     uint64 `json:"id" form:"id"`
-	Symbol   string `json:"symbol" form:"symbol" binding:"required"`
+	ID uint64 `json:"id" form:"id"`
+	RobotSettingsDTO
 	UserID uint64 `json:"user_id,omitempty"  form:"user_id,omitempty"`
 }
 
 type RobotCreateDTO struct {
-	Symbol   string `json:"symbol" form:"symbol" binding:"required"`
+	RobotSettingsDTO
 	UserID uint64 `json:"user_id,omitempty"  form:"user_id,omitempty"`
 }
@@ -0,0 +1,6 @@
+package dto
+
+//RefreshDTO is used when client post from /refresh url
+type RefreshDTO struct {
+	RefreshToken string `json:"refresh_token" form:"refresh_token" binding:"required"`
+}
@@ -3,12 +3,17 @@ module github.com/myomyintko/strategy_robot
 go 1.15
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/adshao/go-binance/v2 v2.3.4
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/gin-gonic/gin v1.7.7
 	github.com/joho/godotenv v1.3.0
 	github.com/mashingan/smapping v0.1.3
+	github.com/pquerna/otp v1.3.0
 	golang.org/x/crypto v0.0.0-20201203163018-be400aefbc4c
+	gopkg.in/yaml.v2 v2.2.8
 	gorm.io/driver/mysql v1.0.3
+	gorm.io/driver/postgres v1.0.5
+	gorm.io/driver/sqlite v1.1.4
 	gorm.io/gorm v1.20.8
 )
@@ -0,0 +1,145 @@
+package helper
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorKind classifies an Error and decides its HTTP status
+type ErrorKind int
+
+const (
+	// KindInternal is a fault on our side, its details are never shown to the caller
+	KindInternal ErrorKind = iota
+	// KindValidation is a malformed request
+	KindValidation
+	// KindAuth is a missing or invalid credential
+	KindAuth
+	// KindForbidden is an authenticated caller acting outside their rights
+	KindForbidden
+	// KindNotFound is a missing resource
+	KindNotFound
+	// KindConflict clashes with the current state, such as a duplicate
+	KindConflict
+	// KindUnprocessable is well formed but refused by a business rule
+	KindUnprocessable
+	// KindRateLimited is throttled by us or by the exchange
+	KindRateLimited
+	// KindExchange is a call the exchange rejected, Code carries the Binance error code
+	KindExchange
+	// KindUpstream is a failure of another dependency such as the mail relay
+	KindUpstream
+)
+
+var kindStatus = map[ErrorKind]int{
+	KindInternal:      http.StatusInternalServerError,
+	KindValidation:    http.StatusBadRequest,
+	KindAuth:          http.StatusUnauthorized,
+	KindForbidden:     http.StatusForbidden,
+	KindNotFound:      http.StatusNotFound,
+	KindConflict:      http.StatusConflict,
+	KindUnprocessable: http.StatusUnprocessableEntity,
+	KindRateLimited:   http.StatusTooManyRequests,
+	KindExchange:      http.StatusBadGateway,
+	KindUpstream:      http.StatusBadGateway,
+}
+
+// Error is the error services return and the error middleware renders
+type Error struct {
+	Kind ErrorKind
+	// Message is the summary put in Response.Message
+	Message string
+	// Err is the cause, it may be nil
+	Err error
+	// Code is the Binance error code of a KindExchange error
+	Code int
+	// RetryAfter is sent as the Retry-After header of a KindRateLimited error
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Status is the HTTP status of the error
+func (e *Error) Status() int {
+	if status, ok := kindStatus[e.Kind]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+//ValidationError is a malformed request
+func ValidationError(message string, err error) *Error {
+	return &Error{Kind: KindValidation, Message: message, Err: err}
+}
+
+//AuthError is a missing or invalid credential
+func AuthError(message string, err error) *Error {
+	return &Error{Kind: KindAuth, Message: message, Err: err}
+}
+
+//ForbiddenError is a caller acting outside their rights
+func ForbiddenError(message string, err error) *Error {
+	return &Error{Kind: KindForbidden, Message: message, Err: err}
+}
+
+//NotFoundError is a missing resource
+func NotFoundError(message string, err error) *Error {
+	return &Error{Kind: KindNotFound, Message: message, Err: err}
+}
+
+//ConflictError clashes with the current state
+func ConflictError(message string, err error) *Error {
+	return &Error{Kind: KindConflict, Message: message, Err: err}
+}
+
+//UnprocessableError is refused by a business rule
+func UnprocessableError(message string, err error) *Error {
+	return &Error{Kind: KindUnprocessable, Message: message, Err: err}
+}
+
+//RateLimitedError asks the caller to come back after retryAfter, zero when unknown
+func RateLimitedError(message string, retryAfter time.Duration, err error) *Error {
+	return &Error{Kind: KindRateLimited, Message: message, Err: err, RetryAfter: retryAfter}
+}
+
+//ExchangeError is a call the exchange rejected with the Binance error code
+func ExchangeError(message string, code int, err error) *Error {
+	return &Error{Kind: KindExchange, Message: message, Err: err, Code: code}
+}
+
+//UpstreamError is a failure of a dependency other than the exchange
+func UpstreamError(message string, err error) *Error {
+	return &Error{Kind: KindUpstream, Message: message, Err: err}
+}
+
+//InternalError is a fault on our side
+func InternalError(message string, err error) *Error {
+	return &Error{Kind: KindInternal, Message: message, Err: err}
+}
+
+//AsError returns err as an *Error, errors of any other type are internal
+func AsError(err error) *Error {
+	var typed *Error
+	if errors.As(err, &typed) {
+		return typed
+	}
+	return InternalError("Internal error", err)
+}
+
+//Fail records err on the request and stops the handler chain, the error middleware renders it
+func Fail(ctx *gin.Context, err error) {
+	_ = ctx.Error(err)
+	ctx.Abort()
+}
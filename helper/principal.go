@@ -0,0 +1,65 @@
+package helper
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const principalKey = "principal"
+
+// ScopeAll grants every scope, it is what a password login receives
+const ScopeAll = "*"
+
+// Principal is the authenticated caller as established by the auth middleware
+type Principal struct {
+	UserID    uint64
+	SessionID uint64
+	// TokenID is the personal access token used, zero for session logins
+	TokenID uint64
+	Roles   []string
+	Scopes  []string
+	// ImpersonatorID is the admin acting as UserID, zero for the user themselves
+	ImpersonatorID uint64
+}
+
+// ID returns the user ID in the string form the user service expects
+func (p Principal) ID() string {
+	return strconv.FormatUint(p.UserID, 10)
+}
+
+// HasRole reports whether the principal was granted role
+func (p Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the principal may act within scope
+func (p Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == ScopeAll || s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPrincipal stores the authenticated caller on the request context
+func SetPrincipal(ctx *gin.Context, p Principal) {
+	ctx.Set(principalKey, p)
+}
+
+// CurrentPrincipal returns the caller set by the auth middleware, or the zero
+// Principal on routes that are not behind it
+func CurrentPrincipal(ctx *gin.Context) Principal {
+	if v, ok := ctx.Get(principalKey); ok {
+		if p, ok := v.(Principal); ok {
+			return p
+		}
+	}
+	return Principal{}
+}
@@ -7,7 +7,9 @@ type Response struct {
 	Status  bool        `json:"status"`
 	Message string      `json:"message"`
 	Errors  interface{} `json:"errors"`
-	Data    interface{} `json:"data"`
+	// Code is the Binance error code behind an exchange failure
+	Code int         `json:"code,omitempty"`
+	Data interface{} `json:"data"`
 }
 
 //EmptyObj object is used when data doesnt want to be null on json
@@ -35,3 +37,11 @@ func BuildErrorResponse(message string, err string, data interface{}) Response {
 	}
 	return res
 }
+
+//MaskSecret hides everything but the last four characters of a secret
+func MaskSecret(secret string) string {
+	if len(secret) <= 4 {
+		return strings.Repeat("*", len(secret))
+	}
+	return strings.Repeat("*", 8) + secret[len(secret)-4:]
+}
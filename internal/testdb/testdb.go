@@ -0,0 +1,92 @@
+//Package testdb opens the database tests run against
+package testdb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/myomyintko/strategy_robot/config"
+	"gorm.io/gorm"
+)
+
+//Open is an empty database closed when t ends. It is an in-memory SQLite database unless
+//TEST_DB_DRIVER is mysql or postgres, then TEST_DB_HOST, TEST_DB_PORT, TEST_DB_USER,
+//TEST_DB_PASSWORD and TEST_DB_NAME name a server database whose every table is dropped
+//before and after every test, never point them at a database holding real data. Packages
+//share that database, run them one at a time with go test -p 1 ./...
+func Open(t *testing.T) *gorm.DB {
+	t.Helper()
+	conf := config.DatabaseConfig{Driver: config.DriverSQLite, Path: ":memory:"}
+	if driver := os.Getenv("TEST_DB_DRIVER"); driver != "" && driver != config.DriverSQLite {
+		port, _ := strconv.Atoi(os.Getenv("TEST_DB_PORT"))
+		conf = config.DatabaseConfig{
+			Driver:   driver,
+			Host:     os.Getenv("TEST_DB_HOST"),
+			Port:     port,
+			User:     os.Getenv("TEST_DB_USER"),
+			Password: os.Getenv("TEST_DB_PASSWORD"),
+			Name:     os.Getenv("TEST_DB_NAME"),
+			SSLMode:  "disable",
+		}
+	}
+	db, err := config.SetupDatabaseConnection(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = config.CloseDatabaseConnection(db) })
+	if conf.Driver != config.DriverSQLite {
+		dropTables(t, db, conf.Driver)
+		t.Cleanup(func() { dropTables(t, db, conf.Driver) })
+	}
+	return db
+}
+
+//dropTables empties a server database, a failed migration may have left any table behind
+func dropTables(t *testing.T, db *gorm.DB, driver string) {
+	t.Helper()
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// MySQL keeps FOREIGN_KEY_CHECKS per connection, every statement goes to the same one
+	ctx := context.Background()
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	query, drop := "SHOW TABLES", "DROP TABLE IF EXISTS `%s`"
+	if driver == config.DriverPostgres {
+		query, drop = "SELECT tablename FROM pg_tables WHERE schemaname = current_schema()", `DROP TABLE IF EXISTS "%s" CASCADE`
+	} else {
+		// MySQL has no CASCADE, the order tables are dropped in must not matter
+		if _, err := conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+			t.Fatal(err)
+		}
+		defer conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 1")
+	}
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, table)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf(drop, table)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
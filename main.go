@@ -1,9 +1,28 @@
 package main
 
 import (
+	"log"
+	"os"
+
+	"github.com/myomyintko/strategy_robot/config"
 	"github.com/myomyintko/strategy_robot/route"
 )
 
 func main() {
-	route.InitRoute()
+	conf, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if len(args) == 0 {
+		route.InitRoute(conf)
+		return
+	}
+	switch args[0] {
+	case "rotate-master-key":
+		route.RotateMasterKey(conf)
+	case "migrate":
+		route.Migrate(conf, args[1:])
+	default:
+		log.Fatalf("unknown command %q", args[0])
+	}
 }
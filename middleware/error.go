@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myomyintko/strategy_robot/helper"
+)
+
+//RenderErrors turns the last error recorded with helper.Fail, or a panic, into a
+//helper.Response with the status of its kind. Internal details are logged, not sent
+func RenderErrors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if r := recover(); r != nil {
+				if r == http.ErrAbortHandler {
+					panic(r)
+				}
+				_ = c.Error(fmt.Errorf("panic: %v", r))
+				c.Abort()
+				render(c)
+			}
+		}()
+		c.Next()
+		render(c)
+	}
+}
+
+func render(c *gin.Context) {
+	last := c.Errors.Last()
+	if last == nil || c.Writer.Written() {
+		return
+	}
+	err := helper.AsError(last.Err)
+	detail := last.Err.Error()
+	if last.Err == error(err) && err.Err != nil {
+		// the message is already in Response.Message, list only the cause
+		detail = err.Err.Error()
+	}
+	if err.Kind == helper.KindInternal {
+		log.Printf("%s %s: %v", c.Request.Method, c.Request.URL.Path, last.Err)
+		detail = "Something went wrong, try again later"
+	}
+	if err.Kind == helper.KindRateLimited && err.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(err.RetryAfter.Seconds()))))
+	}
+	response := helper.BuildErrorResponse(err.Message, detail, helper.EmptyObj{})
+	response.Code = err.Code
+	c.JSON(err.Status(), response)
+}
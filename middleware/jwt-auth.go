@@ -1,38 +1,51 @@
 package middleware
 
 import (
-	"github.com/dgrijalva/jwt-go"
+	"errors"
+	"strings"
+
 	"github.com/gin-gonic/gin"
 	"github.com/myomyintko/strategy_robot/helper"
 	"github.com/myomyintko/strategy_robot/service"
-	"log"
-	"net/http"
-	"strings"
 )
 
-//AuthorizeJWT validates the token user given, return 401 if not valid
-func AuthorizeJWT(jwtService service.JWTService) gin.HandlerFunc {
+//AuthorizeJWT validates the bearer token, either a session JWT or a personal access token,
+//and stores the caller as a helper.Principal. Every failure aborts with 401 so handlers
+//never see an unauthenticated request
+func AuthorizeJWT(jwtService service.JWTService, sessionService service.SessionService, tokenService service.PersonalTokenService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
-
 		if authHeader == "" {
-			response := helper.BuildErrorResponse("Failed to process request", "No token found", nil)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, response)
+			helper.Fail(c, helper.AuthError("Failed to process request", errors.New("No token found")))
+			return
+		}
+		if !strings.HasPrefix(authHeader, "Bearer ") {
+			helper.Fail(c, helper.AuthError("Failed to process request", errors.New("Authorization header must use the Bearer scheme")))
+			return
+		}
+		bearer := strings.TrimPrefix(authHeader, "Bearer ")
+
+		if strings.HasPrefix(bearer, service.PersonalTokenPrefix) {
+			principal, err := tokenService.Authenticate(bearer)
+			if err != nil {
+				helper.Fail(c, helper.AuthError("Token is not valid", err))
+				return
+			}
+			helper.SetPrincipal(c, principal)
+			c.Next()
 			return
 		}
 
-		splitToken := strings.Split(authHeader, "Bearer ")
-		authHeader = splitToken[1]
-		token, err := jwtService.ValidateToken(authHeader)
+		principal, err := jwtService.ParsePrincipal(bearer)
 		if err != nil {
+			helper.Fail(c, helper.AuthError("Token is not valid", err))
 			return
 		}
-		if !token.Valid {
-			response := helper.BuildErrorResponse("Token is not valid", "Token error", nil)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, response)
+		if !sessionService.IsActive(principal.SessionID) {
+			helper.Fail(c, helper.AuthError("Token is not valid", errors.New("Session has been revoked")))
 			return
 		}
-		claims := token.Claims.(jwt.MapClaims)
-		log.Println("Claim[user_id]: ", claims["user_id"])
+		helper.SetPrincipal(c, principal)
+		c.Next()
 	}
 }
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myomyintko/strategy_robot/helper"
+	"github.com/myomyintko/strategy_robot/model"
+)
+
+//RequirePermission returns 403 unless the principal's roles grant permission and its token scopes allow it,
+//use it after AuthorizeJWT
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := helper.CurrentPrincipal(c)
+		if !model.RolesAllow(principal.Roles, permission) || !principal.HasScope(permission) {
+			helper.Fail(c, helper.ForbiddenError("You dont have permission", errors.New(permission+" required")))
+			return
+		}
+		c.Next()
+	}
+}
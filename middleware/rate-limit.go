@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myomyintko/strategy_robot/helper"
+	"github.com/myomyintko/strategy_robot/service"
+)
+
+//RateLimit throttles the route group name to rate. Buckets are kept per personal access
+//token, per user for session logins and per client IP for anonymous calls, so it must run
+//after AuthorizeJWT on protected groups
+func RateLimit(store service.RateLimitStore, name string, rate service.Rate) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, retryAfter := store.Take(name+":"+callerKey(c), rate)
+		if !allowed {
+			helper.Fail(c, helper.RateLimitedError("Too many requests", retryAfter, errors.New("Rate limit exceeded")))
+			return
+		}
+		c.Next()
+	}
+}
+
+func callerKey(c *gin.Context) string {
+	principal := helper.CurrentPrincipal(c)
+	switch {
+	case principal.TokenID != 0:
+		return "token:" + strconv.FormatUint(principal.TokenID, 10)
+	case principal.UserID != 0:
+		return "user:" + principal.ID()
+	default:
+		return "ip:" + c.ClientIP()
+	}
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myomyintko/strategy_robot/helper"
+	"github.com/myomyintko/strategy_robot/service"
+)
+
+// TOTPHeader carries the fresh code for actions guarded by RequireTOTP
+const TOTPHeader = "X-TOTP-Code"
+
+//RequireTOTP demands a fresh two-factor code for sensitive actions, use it after AuthorizeJWT
+func RequireTOTP(totpService service.TOTPService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		code := c.GetHeader(TOTPHeader)
+		if code == "" {
+			helper.Fail(c, helper.AuthError("Two-factor code required", errors.New("Missing "+TOTPHeader+" header")))
+			return
+		}
+		if err := totpService.Verify(helper.CurrentPrincipal(c).UserID, code); err != nil {
+			helper.Fail(c, err)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/myomyintko/strategy_robot/helper"
+	"github.com/myomyintko/strategy_robot/service"
+)
+
+//RequireVerifiedEmail refuses callers who have not verified their email address, use it after AuthorizeJWT
+func RequireVerifiedEmail(authService service.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := authService.RequireVerified(helper.CurrentPrincipal(c).UserID); err != nil {
+			helper.Fail(c, err)
+			return
+		}
+		c.Next()
+	}
+}
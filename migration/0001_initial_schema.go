@@ -0,0 +1,156 @@
+package migration
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// initialSchema is the schema as AutoMigrate left it, so databases created
+// before versioned migrations adopt it without changes
+var initialSchema = Migration{
+	Version: 1,
+	Name:    "initial_schema",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(
+			&initialUser{}, &initialRobot{}, &initialBinanceAPI{}, &initialOrder{},
+			&initialSession{}, &initialRefreshToken{}, &initialRecoveryCode{},
+			&initialPersonalAccessToken{}, &initialUserToken{},
+		)
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(
+			&initialUserToken{}, &initialPersonalAccessToken{}, &initialRecoveryCode{},
+			&initialRefreshToken{}, &initialSession{}, &initialOrder{},
+			&initialBinanceAPI{}, &initialRobot{}, &initialUser{},
+		)
+	},
+}
+
+type initialUser struct {
+	ID              uint64 `gorm:"primary_key:auto_increment"`
+	Name            string `gorm:"type:varchar(255)"`
+	Email           string `gorm:"uniqueIndex;type:varchar(255)"`
+	Password        string `gorm:"not null"`
+	Role            string `gorm:"type:varchar(32);default:trader"`
+	DisabledAt      *time.Time
+	EmailVerifiedAt *time.Time
+	TOTPSecret      string `gorm:"type:varchar(255)"`
+	TOTPDataKey     string `gorm:"type:varchar(255)"`
+	TOTPEnabled     bool
+	TOTPLastStep    int64
+	Robots          []*initialRobot      `gorm:"foreignKey:UserID"`
+	Keys            []*initialBinanceAPI `gorm:"foreignKey:UserID"`
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+func (initialUser) TableName() string { return "users" }
+
+type initialRobot struct {
+	ID        uint64          `gorm:"primary_key:auto_increment"`
+	Symbol    string          `gorm:"type:varchar(255)"`
+	UserID    uint64          `gorm:"not null"`
+	User      initialUser     `gorm:"foreignKey:UserID;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	Orders    *[]initialOrder `gorm:"foreignKey:RobotID"`
+	CreatedAt time.Time
+}
+
+func (initialRobot) TableName() string { return "robots" }
+
+type initialBinanceAPI struct {
+	ID           uint64      `gorm:"primary_key:auto_increment"`
+	APIKey       string      `gorm:"unique,type:varchar(255)"`
+	SecretKey    string      `gorm:"type:varchar(255)"`
+	DataKey      string      `gorm:"type:varchar(255)"`
+	SecretMask   string      `gorm:"type:varchar(32)"`
+	StreamKey    string      `gorm:"unique,type:varchar(255)"`
+	UserID       uint64      `gorm:"not null"`
+	User         initialUser `gorm:"foreignKey:UserID;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	BoundAt      time.Time
+	StreamedAt   time.Time
+	CanTrade     bool
+	CanWithdraw  bool
+	IPRestricted bool
+	HealthError  string `gorm:"type:varchar(255)"`
+	CheckedAt    time.Time
+}
+
+func (initialBinanceAPI) TableName() string { return "binance_apis" }
+
+type initialOrder struct {
+	ID            uint64 `gorm:"primary_key:autoincrement"`
+	OrderId       int64
+	ClientOrderId string
+	UserID        uint64       `gorm:"not null;index"`
+	RobotID       uint64       `gorm:"not null"`
+	Robot         initialRobot `gorm:"foreignKey:RobotID;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	OrderedAt     time.Time
+}
+
+func (initialOrder) TableName() string { return "orders" }
+
+type initialSession struct {
+	ID         uint64      `gorm:"primary_key:auto_increment"`
+	UserID     uint64      `gorm:"not null;index"`
+	User       initialUser `gorm:"foreignKey:UserID;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	UserAgent  string      `gorm:"type:varchar(255)"`
+	IP         string      `gorm:"type:varchar(64)"`
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+func (initialSession) TableName() string { return "sessions" }
+
+type initialRefreshToken struct {
+	ID        uint64         `gorm:"primary_key:auto_increment"`
+	SessionID uint64         `gorm:"not null;index"`
+	Session   initialSession `gorm:"foreignKey:SessionID;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	TokenHash string         `gorm:"uniqueIndex;type:varchar(64)"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func (initialRefreshToken) TableName() string { return "refresh_tokens" }
+
+type initialRecoveryCode struct {
+	ID        uint64      `gorm:"primary_key:auto_increment"`
+	UserID    uint64      `gorm:"not null;index"`
+	User      initialUser `gorm:"foreignKey:UserID;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	CodeHash  string      `gorm:"type:varchar(64)"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func (initialRecoveryCode) TableName() string { return "recovery_codes" }
+
+type initialPersonalAccessToken struct {
+	ID         uint64      `gorm:"primary_key:auto_increment"`
+	UserID     uint64      `gorm:"not null;index"`
+	User       initialUser `gorm:"foreignKey:UserID;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	Name       string      `gorm:"type:varchar(255)"`
+	Prefix     string      `gorm:"type:varchar(16)"`
+	TokenHash  string      `gorm:"uniqueIndex;type:varchar(64)"`
+	Scopes     string      `gorm:"type:varchar(255)"`
+	ExpiresAt  time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+func (initialPersonalAccessToken) TableName() string { return "personal_access_tokens" }
+
+type initialUserToken struct {
+	ID        uint64      `gorm:"primary_key:auto_increment"`
+	UserID    uint64      `gorm:"not null;index"`
+	User      initialUser `gorm:"foreignKey:UserID;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	Purpose   string      `gorm:"type:varchar(32)"`
+	TokenHash string      `gorm:"uniqueIndex;type:varchar(64)"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func (initialUserToken) TableName() string { return "user_tokens" }
@@ -0,0 +1,67 @@
+package migration
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// orderIdempotency stores orders before submission under a deterministic
+// client order ID, so an ambiguous submission can be looked up on Binance
+var orderIdempotency = Migration{
+	Version: 2,
+	Name:    "order_idempotency",
+	Up: func(tx *gorm.DB) error {
+		if err := addColumns(tx, &idempotentOrder{}, idempotentOrderColumns...); err != nil {
+			return err
+		}
+		// the initial schema left client_order_id as longtext, which MySQL cannot index.
+		// SQLite indexes text as is and would rebuild the whole table to alter it
+		if tx.Dialector.Name() != "sqlite" {
+			if err := tx.Migrator().AlterColumn(&idempotentOrder{}, "ClientOrderId"); err != nil {
+				return err
+			}
+		}
+		if err := createIndex(tx, &idempotentOrder{}, "ClientOrderId"); err != nil {
+			return err
+		}
+		return createIndex(tx, &idempotentOrder{}, "Status")
+	},
+	Down: func(tx *gorm.DB) error {
+		if err := dropIndex(tx, &idempotentOrder{}, "Status"); err != nil {
+			return err
+		}
+		if err := dropIndex(tx, &idempotentOrder{}, "ClientOrderId"); err != nil {
+			return err
+		}
+		if tx.Dialector.Name() != "sqlite" {
+			if err := tx.Migrator().AlterColumn(&initialOrder{}, "ClientOrderId"); err != nil {
+				return err
+			}
+		}
+		return dropColumns(tx, &idempotentOrder{}, idempotentOrderColumns...)
+	},
+}
+
+var idempotentOrderColumns = []string{
+	"IdempotencyKey", "Symbol", "Side", "Price", "Quantity", "Status", "Error", "UpdatedAt",
+}
+
+type idempotentOrder struct {
+	ID             uint64 `gorm:"primary_key:autoincrement"`
+	OrderId        int64
+	ClientOrderId  string `gorm:"uniqueIndex;type:varchar(36)"`
+	IdempotencyKey string `gorm:"type:varchar(255)"`
+	Symbol         string `gorm:"type:varchar(32)"`
+	Side           string `gorm:"type:varchar(8)"`
+	Price          string `gorm:"type:varchar(32)"`
+	Quantity       string `gorm:"type:varchar(32)"`
+	Status         string `gorm:"type:varchar(32);index"`
+	Error          string `gorm:"type:varchar(255)"`
+	UserID         uint64 `gorm:"not null;index"`
+	RobotID        uint64 `gorm:"not null"`
+	OrderedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func (idempotentOrder) TableName() string { return "orders" }
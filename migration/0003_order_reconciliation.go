@@ -0,0 +1,47 @@
+package migration
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// orderReconciliation marks where an order came from and records what the
+// reconciler repaired
+var orderReconciliation = Migration{
+	Version: 3,
+	Name:    "order_reconciliation",
+	Up: func(tx *gorm.DB) error {
+		if err := addColumns(tx, &sourcedOrder{}, "Source"); err != nil {
+			return err
+		}
+		return createTables(tx, &reconciledDiscrepancy{})
+	},
+	Down: func(tx *gorm.DB) error {
+		if err := tx.Migrator().DropTable(&reconciledDiscrepancy{}); err != nil {
+			return err
+		}
+		return dropColumns(tx, &sourcedOrder{}, "Source")
+	},
+}
+
+type sourcedOrder struct {
+	ID     uint64 `gorm:"primary_key:autoincrement"`
+	Source string `gorm:"type:varchar(16);default:api"`
+}
+
+func (sourcedOrder) TableName() string { return "orders" }
+
+type reconciledDiscrepancy struct {
+	ID             uint64    `gorm:"primary_key:auto_increment"`
+	UserID         uint64    `gorm:"not null;index"`
+	OrderID        uint64    `gorm:"not null"`
+	Symbol         string    `gorm:"type:varchar(32)"`
+	ClientOrderId  string    `gorm:"type:varchar(36)"`
+	Kind           string    `gorm:"type:varchar(32)"`
+	LocalStatus    string    `gorm:"type:varchar(32)"`
+	ExchangeStatus string    `gorm:"type:varchar(32)"`
+	DetectedAt     time.Time `gorm:"index"`
+}
+
+func (reconciledDiscrepancy) TableName() string { return "order_discrepancies" }
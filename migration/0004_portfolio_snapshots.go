@@ -0,0 +1,43 @@
+package migration
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// portfolioSnapshots stores the periodic balance snapshots of bound keys
+var portfolioSnapshots = Migration{
+	Version: 4,
+	Name:    "portfolio_snapshots",
+	Up: func(tx *gorm.DB) error {
+		return createTables(tx, &snapshot{}, &snapshotBalance{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&snapshotBalance{}, &snapshot{})
+	},
+}
+
+type snapshot struct {
+	ID        uint64 `gorm:"primary_key:auto_increment"`
+	UserID    uint64 `gorm:"not null;index:idx_portfolio_snapshots_user_taken"`
+	APIID     uint64 `gorm:"not null"`
+	ValueUSDT float64
+	ValueBTC  float64
+	TakenAt   time.Time         `gorm:"index:idx_portfolio_snapshots_user_taken"`
+	Balances  []snapshotBalance `gorm:"foreignKey:SnapshotID;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+}
+
+func (snapshot) TableName() string { return "portfolio_snapshots" }
+
+type snapshotBalance struct {
+	ID         uint64 `gorm:"primary_key:auto_increment"`
+	SnapshotID uint64 `gorm:"not null;index"`
+	Asset      string `gorm:"type:varchar(16)"`
+	Free       float64
+	Locked     float64
+	ValueUSDT  float64
+	ValueBTC   float64
+}
+
+func (snapshotBalance) TableName() string { return "portfolio_balances" }
@@ -0,0 +1,49 @@
+package migration
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// rebalanceRobots lets a robot hold a target allocation instead of trading a single symbol
+var rebalanceRobots = Migration{
+	Version: 5,
+	Name:    "rebalance_robots",
+	Up: func(tx *gorm.DB) error {
+		if err := addColumns(tx, &rebalanceRobot{}, rebalanceColumns...); err != nil {
+			return err
+		}
+		return createTables(tx, &robotTarget{})
+	},
+	Down: func(tx *gorm.DB) error {
+		if err := tx.Migrator().DropTable(&robotTarget{}); err != nil {
+			return err
+		}
+		return dropColumns(tx, &rebalanceRobot{}, rebalanceColumns...)
+	},
+}
+
+var rebalanceColumns = []string{"Kind", "QuoteAsset", "Threshold", "IntervalMinutes", "MaxTurnover", "RebalancedAt"}
+
+type rebalanceRobot struct {
+	ID              uint64 `gorm:"primary_key:auto_increment"`
+	Kind            string `gorm:"type:varchar(16);default:symbol"`
+	QuoteAsset      string `gorm:"type:varchar(16)"`
+	Threshold       float64
+	IntervalMinutes int
+	MaxTurnover     float64
+	RebalancedAt    *time.Time
+}
+
+func (rebalanceRobot) TableName() string { return "robots" }
+
+type robotTarget struct {
+	ID      uint64         `gorm:"primary_key:auto_increment"`
+	RobotID uint64         `gorm:"not null;index"`
+	Robot   rebalanceRobot `gorm:"foreignKey:RobotID;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	Asset   string         `gorm:"type:varchar(16)"`
+	Weight  float64
+}
+
+func (robotTarget) TableName() string { return "robot_targets" }
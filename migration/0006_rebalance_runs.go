@@ -0,0 +1,31 @@
+package migration
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// rebalanceRuns records every run of a rebalance robot, the run ID keys the orders it places
+var rebalanceRuns = Migration{
+	Version: 6,
+	Name:    "rebalance_runs",
+	Up: func(tx *gorm.DB) error {
+		return createTables(tx, &rebalanceRun{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&rebalanceRun{})
+	},
+}
+
+type rebalanceRun struct {
+	ID         uint64         `gorm:"primary_key:auto_increment"`
+	RobotID    uint64         `gorm:"not null;index"`
+	Robot      rebalanceRobot `gorm:"foreignKey:RobotID;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+	Placed     int
+	Failed     int
+	StartedAt  time.Time
+	FinishedAt *time.Time
+}
+
+func (rebalanceRun) TableName() string { return "rebalance_runs" }
@@ -0,0 +1,254 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrNotMigrated is returned by Check when the schema is behind the code
+var ErrNotMigrated = errors.New("database schema is not migrated")
+
+// Migration is one versioned schema change. Up and Down must only use their
+// own frozen structs, never the ones in model, so editing a model later cannot
+// change what an old migration does.
+//
+// Up and Down run in a transaction, which makes them atomic on PostgreSQL and
+// SQLite only. MySQL commits every DDL statement on its own, so a migration
+// failing halfway there keeps the steps it already ran while it is not recorded
+// as applied. Every step is therefore written through the helpers below, which
+// skip work already done, and running the same command again finishes it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration records an applied migration in the schema table
+type SchemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false" json:"version"`
+	Name      string    `gorm:"type:varchar(255)" json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Status is a migration and when it was applied, nil when it is pending
+type Status struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// migrations must stay ordered by Version, append new ones at the end
+var migrations = []Migration{
+	initialSchema,
+	orderIdempotency,
+	orderReconciliation,
+	portfolioSnapshots,
+	rebalanceRobots,
+	rebalanceRuns,
+}
+
+// Up applies every pending migration in order and returns the applied ones
+func Up(db *gorm.DB) ([]Migration, error) {
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, m := range sorted() {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err := transaction(db, func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// Down reverts the latest applied migration, false when there is none
+func Down(db *gorm.DB) (Migration, bool, error) {
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return Migration{}, false, err
+	}
+	all := sorted()
+	for i := len(all) - 1; i >= 0; i-- {
+		m := all[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		err := transaction(db, func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return m, false, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		return m, true, nil
+	}
+	return Migration{}, false, nil
+}
+
+// Statuses lists every known migration with when it was applied
+func Statuses(db *gorm.DB) ([]Status, error) {
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []Status
+	for _, m := range sorted() {
+		status := Status{Version: m.Version, Name: m.Name}
+		if row, ok := applied[m.Version]; ok {
+			at := row.AppliedAt
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Check refuses a schema missing any migration or carrying one this build does not know
+func Check(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return ErrNotMigrated
+	}
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return err
+	}
+	known := make(map[int]bool)
+	for _, m := range migrations {
+		known[m.Version] = true
+		if _, ok := applied[m.Version]; !ok {
+			return fmt.Errorf("%w: %d %s is pending", ErrNotMigrated, m.Version, m.Name)
+		}
+	}
+	for version := range applied {
+		if !known[version] {
+			return fmt.Errorf("database schema has migration %d which this build does not know", version)
+		}
+	}
+	return nil
+}
+
+func appliedVersions(db *gorm.DB) (map[int]SchemaMigration, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+	var rows []SchemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]SchemaMigration)
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+func sorted() []Migration {
+	all := append([]Migration(nil), migrations...)
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	return all
+}
+
+// transaction runs fn in a transaction. SQLite runs it with foreign keys off on one
+// connection: it drops a column by rebuilding the table, and dropping the old copy
+// would otherwise cascade into every row referencing it
+func transaction(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	if db.Dialector.Name() != "sqlite" {
+		return db.Transaction(fn)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// the pragma is ignored inside a transaction, it has to be set around it
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	pinned := db.Session(&gorm.Session{Context: ctx})
+	pinned.Statement.ConnPool = conn
+	return pinned.Transaction(fn)
+}
+
+// addColumns adds the fields of model that the table does not have yet
+func addColumns(tx *gorm.DB, model interface{}, fields ...string) error {
+	for _, field := range fields {
+		if tx.Migrator().HasColumn(model, field) {
+			continue
+		}
+		if err := tx.Migrator().AddColumn(model, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropColumns drops the fields of model that the table still has
+func dropColumns(tx *gorm.DB, model interface{}, fields ...string) error {
+	for _, field := range fields {
+		if !tx.Migrator().HasColumn(model, field) {
+			continue
+		}
+		if err := tx.Migrator().DropColumn(model, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createTables creates the tables of models that do not exist yet
+func createTables(tx *gorm.DB, models ...interface{}) error {
+	for _, model := range models {
+		if tx.Migrator().HasTable(model) {
+			continue
+		}
+		if err := tx.Migrator().CreateTable(model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createIndex creates the index of model on field unless it exists
+func createIndex(tx *gorm.DB, model interface{}, field string) error {
+	if tx.Migrator().HasIndex(model, field) {
+		return nil
+	}
+	return tx.Migrator().CreateIndex(model, field)
+}
+
+// dropIndex drops the index of model on field if it exists
+func dropIndex(tx *gorm.DB, model interface{}, field string) error {
+	if !tx.Migrator().HasIndex(model, field) {
+		return nil
+	}
+	return tx.Migrator().DropIndex(model, field)
+}
@@ -0,0 +1,106 @@
+package migration
+
+import (
+	"testing"
+
+	"github.com/myomyintko/strategy_robot/internal/testdb"
+	"gorm.io/gorm"
+)
+
+//newTestDB is an empty database closed when t ends, see testdb.Open for the drivers it runs on
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	return testdb.Open(t)
+}
+
+func TestUpAndDownRoundTrip(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := Up(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := Check(db); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, reverted, err := Down(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reverted {
+			break
+		}
+	}
+	if db.Migrator().HasTable("users") || db.Migrator().HasTable("robot_targets") {
+		t.Fatal("tables left behind after every migration was reverted")
+	}
+	if _, err := Up(db); err != nil {
+		t.Fatalf("up after a full down: %v", err)
+	}
+}
+
+//TestUpFinishesAHalfAppliedMigration is what MySQL leaves when a migration fails after
+//some of its DDL statements were committed
+func TestUpFinishesAHalfAppliedMigration(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := Up(db); err != nil {
+		t.Fatal(err)
+	}
+	// revert down to and including rebalanceRobots, the migration left half-applied below
+	for {
+		reverted, _, err := Down(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reverted.Version == rebalanceRobots.Version {
+			break
+		}
+	}
+	if err := db.Migrator().AddColumn(&rebalanceRobot{}, "Kind"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrator().CreateTable(&robotTarget{}); err != nil {
+		t.Fatal(err)
+	}
+	applied, err := Up(db)
+	if err != nil {
+		t.Fatalf("up over a half-applied migration: %v", err)
+	}
+	if len(applied) == 0 || applied[0].Version != rebalanceRobots.Version {
+		t.Fatalf("applied %+v, want %d first", applied, rebalanceRobots.Version)
+	}
+	if !db.Migrator().HasColumn(&rebalanceRobot{}, "MaxTurnover") {
+		t.Fatal("the missing columns were not added")
+	}
+}
+
+//TestDownKeepsRowsReferencingARebuiltTable guards SQLite, it drops a column by rebuilding the
+//table and dropping the old copy must not cascade into the tables referencing it
+func TestDownKeepsRowsReferencingARebuiltTable(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := Up(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range []string{
+		"INSERT INTO users (id, name, email, password) VALUES (1, 'alice', 'alice@example.com', 'hash')",
+		"INSERT INTO robots (id, symbol, user_id) VALUES (1, 'BTCUSDT', 1)",
+		"INSERT INTO orders (id, symbol, user_id, robot_id) VALUES (1, 'BTCUSDT', 1, 1)",
+	} {
+		if err := db.Exec(statement).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for {
+		reverted, _, err := Down(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reverted.Version == rebalanceRobots.Version {
+			break
+		}
+	}
+	var orders int64
+	db.Table("orders").Count(&orders)
+	if orders != 1 {
+		t.Errorf("%d orders left after reverting %s, want 1", orders, rebalanceRobots.Name)
+	}
+}
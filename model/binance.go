@@ -5,21 +5,84 @@ import (
 )
 
 type BinanceAPI struct {
-	ID        uint64 `gorm:"primary_key:auto_increment" json:"id"`
-	APIKey    string `gorm:"unique,type:varchar(255)" json:"api"`
-	SecretKey string `gorm:"unique,type:varchar(255)" json:"secret"`
-	StreamKey string `gorm:"unique,type:varchar(255)" json:"stream"`
-	UserID    uint64 `gorm:"not null" json:"-"`
-	User      User   `gorm:"foreignKey:UserID;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"user"`
-	BoundAt   time.Time
+	ID         uint64 `gorm:"primary_key:auto_increment" json:"id"`
+	APIKey     string `gorm:"unique,type:varchar(255)" json:"api"`
+	SecretKey  string `gorm:"type:varchar(255)" json:"-"`
+	DataKey    string `gorm:"type:varchar(255)" json:"-"`
+	SecretMask string `gorm:"type:varchar(32)" json:"secret"`
+	StreamKey  string `gorm:"unique,type:varchar(255)" json:"stream"`
+	UserID     uint64 `gorm:"not null" json:"-"`
+	User       User   `gorm:"foreignKey:UserID;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"user"`
+	BoundAt    time.Time
 	StreamedAt time.Time
+	// key health as last reported by Binance
+	CanTrade     bool      `json:"can_trade"`
+	CanWithdraw  bool      `json:"can_withdraw"`
+	IPRestricted bool      `json:"ip_restricted"`
+	HealthError  string    `gorm:"type:varchar(255)" json:"health_error"`
+	CheckedAt    time.Time `json:"checked_at"`
 }
 
+//Order statuses of our own, every other status is the one Binance reports
+const (
+	// OrderPending is stored before the order is submitted
+	OrderPending = "PENDING"
+	// OrderUnknown is a submission that got no definite answer, reconciliation settles it
+	OrderUnknown = "UNKNOWN"
+	// OrderRejected is a submission Binance refused, nothing was placed
+	OrderRejected = "REJECTED"
+	// OrderMissing is an order Binance no longer knows, reconciliation reports it once
+	OrderMissing = "MISSING"
+)
+
+//FinalOrderStatuses never change again, reconciliation skips orders in them
+var FinalOrderStatuses = []string{"FILLED", "CANCELED", "EXPIRED", OrderRejected, OrderMissing}
+
+//Order sources, external orders were placed outside this service and found by reconciliation
+const (
+	OrderSourceAPI      = "api"
+	OrderSourceExternal = "external"
+)
+
 type Order struct {
-	ID               uint64  `gorm:"primary_key:autoincrement" json:"id"`
-	OrderId int64 `json:"order_id"`
-	ClientOrderId string  `json:"client_order_id"`
-	RobotID           uint64  `gorm:"not null" json:"-"`
-	Robot             Robot    `gorm:"foreignKey:RobotID;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"robot"`
-	OrderedAt time.Time
+	ID      uint64 `gorm:"primary_key:autoincrement" json:"id"`
+	OrderId int64  `json:"order_id"`
+	// ClientOrderId is derived from UserID and IdempotencyKey and sent as newClientOrderId
+	ClientOrderId  string `gorm:"uniqueIndex;type:varchar(36)" json:"client_order_id"`
+	IdempotencyKey string `gorm:"type:varchar(255)" json:"idempotency_key"`
+	Symbol         string `gorm:"type:varchar(32)" json:"symbol"`
+	Side           string `gorm:"type:varchar(8)" json:"side"`
+	Price          string `gorm:"type:varchar(32)" json:"price"`
+	Quantity       string `gorm:"type:varchar(32)" json:"quantity"`
+	Status         string `gorm:"type:varchar(32);index" json:"status"`
+	Error          string `gorm:"type:varchar(255)" json:"error,omitempty"`
+	Source         string `gorm:"type:varchar(16);default:api" json:"source"`
+	UserID         uint64 `gorm:"not null;index" json:"-"`
+	RobotID        uint64 `gorm:"not null" json:"-"`
+	Robot          Robot  `gorm:"foreignKey:RobotID;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"robot"`
+	OrderedAt      time.Time
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+//Discrepancy kinds found by reconciliation
+const (
+	// DiscrepancyImported is a Binance order we had no record of
+	DiscrepancyImported = "imported"
+	// DiscrepancyStatus is an order whose status we had wrong
+	DiscrepancyStatus = "status_repaired"
+	// DiscrepancyMissing is an order of ours Binance does not know
+	DiscrepancyMissing = "missing_on_exchange"
+)
+
+//OrderDiscrepancy records one difference reconciliation found and repaired
+type OrderDiscrepancy struct {
+	ID             uint64    `gorm:"primary_key:auto_increment" json:"id"`
+	UserID         uint64    `gorm:"not null;index" json:"-"`
+	OrderID        uint64    `gorm:"not null" json:"order_id"`
+	Symbol         string    `gorm:"type:varchar(32)" json:"symbol"`
+	ClientOrderId  string    `gorm:"type:varchar(36)" json:"client_order_id"`
+	Kind           string    `gorm:"type:varchar(32)" json:"kind"`
+	LocalStatus    string    `gorm:"type:varchar(32)" json:"local_status"`
+	ExchangeStatus string    `gorm:"type:varchar(32)" json:"exchange_status"`
+	DetectedAt     time.Time `gorm:"index" json:"detected_at"`
 }
@@ -0,0 +1,55 @@
+package model
+
+import "time"
+
+//PriceLevel is the quantity resting at one price of an order book
+type PriceLevel struct {
+	Price    float64 `json:"price"`
+	Quantity float64 `json:"quantity"`
+}
+
+//OrderBookDepth is the top of a local order book as of UpdateID
+type OrderBookDepth struct {
+	Symbol    string       `json:"symbol"`
+	UpdateID  int64        `json:"update_id"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	BestBid   float64      `json:"best_bid"`
+	BestAsk   float64      `json:"best_ask"`
+	Spread    float64      `json:"spread"`
+	MidPrice  float64      `json:"mid_price"`
+	Bids      []PriceLevel `json:"bids"`
+	Asks      []PriceLevel `json:"asks"`
+}
+
+//FillEstimate is what a market order of Quantity would get by walking the book. Filled is
+//less than Quantity when the book is too thin, Slippage is how far VWAP is from the best price
+type FillEstimate struct {
+	Symbol     string  `json:"symbol"`
+	Side       string  `json:"side"`
+	Quantity   float64 `json:"quantity"`
+	Filled     float64 `json:"filled"`
+	VWAP       float64 `json:"vwap"`
+	Cost       float64 `json:"cost"`
+	WorstPrice float64 `json:"worst_price"`
+	Slippage   float64 `json:"slippage"`
+}
+
+//Ticker is the last price, best bid and ask and 24h statistics of a symbol. Stale is set when
+//a stream feeding it went quiet, its figures may then be outdated
+type Ticker struct {
+	Symbol             string    `json:"symbol"`
+	LastPrice          float64   `json:"last_price"`
+	BidPrice           float64   `json:"bid_price"`
+	BidQuantity        float64   `json:"bid_quantity"`
+	AskPrice           float64   `json:"ask_price"`
+	AskQuantity        float64   `json:"ask_quantity"`
+	OpenPrice          float64   `json:"open_price"`
+	HighPrice          float64   `json:"high_price"`
+	LowPrice           float64   `json:"low_price"`
+	BaseVolume         float64   `json:"base_volume"`
+	QuoteVolume        float64   `json:"quote_volume"`
+	PriceChangePercent float64   `json:"price_change_percent"`
+	StatsAt            time.Time `json:"stats_at"`
+	BookAt             time.Time `json:"book_at"`
+	Stale              bool      `json:"stale"`
+}
@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// PersonalAccessToken lets scripts authenticate without a password, only its hash is stored
+type PersonalAccessToken struct {
+	ID         uint64     `gorm:"primary_key:auto_increment" json:"id"`
+	UserID     uint64     `gorm:"not null;index" json:"-"`
+	User       User       `gorm:"foreignKey:UserID;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"-"`
+	Name       string     `gorm:"type:varchar(255)" json:"name"`
+	Prefix     string     `gorm:"type:varchar(16)" json:"prefix"`
+	TokenHash  string     `gorm:"uniqueIndex;type:varchar(64)" json:"-"`
+	Scopes     string     `gorm:"type:varchar(255)" json:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Token      string     `gorm:"-" json:"token,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
@@ -0,0 +1,78 @@
+package model
+
+import "time"
+
+//Quote assets a portfolio can be valued in
+const (
+	QuoteUSDT = "USDT"
+	QuoteBTC  = "BTC"
+)
+
+//PortfolioSnapshot is the balance of one bound key at TakenAt, valued in every quote asset
+type PortfolioSnapshot struct {
+	ID        uint64             `gorm:"primary_key:auto_increment" json:"id"`
+	UserID    uint64             `gorm:"not null;index:idx_portfolio_snapshots_user_taken" json:"-"`
+	APIID     uint64             `gorm:"not null" json:"-"`
+	ValueUSDT float64            `json:"value_usdt"`
+	ValueBTC  float64            `json:"value_btc"`
+	TakenAt   time.Time          `gorm:"index:idx_portfolio_snapshots_user_taken" json:"taken_at"`
+	Balances  []PortfolioBalance `gorm:"foreignKey:SnapshotID;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"balances,omitempty"`
+}
+
+//PortfolioBalance is one asset of a snapshot, assets without a price on Binance are valued at zero
+type PortfolioBalance struct {
+	ID         uint64  `gorm:"primary_key:auto_increment" json:"-"`
+	SnapshotID uint64  `gorm:"not null;index" json:"-"`
+	Asset      string  `gorm:"type:varchar(16)" json:"asset"`
+	Free       float64 `json:"free"`
+	Locked     float64 `json:"locked"`
+	ValueUSDT  float64 `json:"value_usdt"`
+	ValueBTC   float64 `json:"value_btc"`
+}
+
+//Value is the snapshot total in quote, QuoteUSDT or QuoteBTC
+func (s PortfolioSnapshot) Value(quote string) float64 {
+	if quote == QuoteBTC {
+		return s.ValueBTC
+	}
+	return s.ValueUSDT
+}
+
+//Value is the balance in quote, QuoteUSDT or QuoteBTC
+func (b PortfolioBalance) Value(quote string) float64 {
+	if quote == QuoteBTC {
+		return b.ValueBTC
+	}
+	return b.ValueUSDT
+}
+
+//Portfolio is the live allocation of a bound key valued in QuoteAsset
+type Portfolio struct {
+	QuoteAsset string       `json:"quote_asset"`
+	Value      float64      `json:"value"`
+	Assets     []Allocation `json:"assets"`
+	ValuedAt   time.Time    `json:"valued_at"`
+}
+
+//Allocation is one asset of a Portfolio, Share is its part of the total between 0 and 1
+type Allocation struct {
+	Asset  string  `json:"asset"`
+	Free   float64 `json:"free"`
+	Locked float64 `json:"locked"`
+	Value  float64 `json:"value"`
+	Share  float64 `json:"share"`
+}
+
+//PortfolioHistory is the equity history of a range. When the range holds more than Limit
+//snapshots only the newest Limit are returned and Truncated is set
+type PortfolioHistory struct {
+	Points    []PortfolioPoint `json:"points"`
+	Limit     int              `json:"limit"`
+	Truncated bool             `json:"truncated"`
+}
+
+//PortfolioPoint is one point of the equity history
+type PortfolioPoint struct {
+	TakenAt time.Time `json:"taken_at"`
+	Value   float64   `json:"value"`
+}
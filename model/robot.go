@@ -2,11 +2,77 @@ package model
 
 import "time"
 
+//Robot kinds, a symbol robot trades Symbol and a rebalance robot holds Targets
+const (
+	RobotKindSymbol    = "symbol"
+	RobotKindRebalance = "rebalance"
+)
+
 type Robot struct {
-	ID        uint64   `gorm:"primary_key:auto_increment" json:"id"`
-	Symbol    string   `gorm:"type:varchar(255)" json:"symbol"`
-	UserID    uint64   `gorm:"not null" json:"-"`
-	User      User     `gorm:"foreignKey:UserID;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"user"`
-	Orders    *[]Order `json:"orders,omitempty"`
-	CreatedAt time.Time
+	ID     uint64 `gorm:"primary_key:auto_increment" json:"id"`
+	Kind   string `gorm:"type:varchar(16);default:symbol" json:"kind"`
+	Symbol string `gorm:"type:varchar(255)" json:"symbol"`
+	// QuoteAsset is the asset a rebalance robot routes through when two targets share no market
+	QuoteAsset string        `gorm:"type:varchar(16)" json:"quote_asset,omitempty"`
+	Targets    []RobotTarget `gorm:"foreignKey:RobotID;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"targets,omitempty"`
+	// Threshold rebalances once an asset drifts this many percentage points from its target, zero disables it
+	Threshold float64 `json:"threshold,omitempty"`
+	// IntervalMinutes rebalances on this schedule whatever the drift, zero disables it
+	IntervalMinutes int `json:"interval_minutes,omitempty"`
+	// MaxTurnover caps the value traded per run in percent of the managed value, zero is no cap
+	MaxTurnover  float64    `json:"max_turnover,omitempty"`
+	RebalancedAt *time.Time `json:"rebalanced_at,omitempty"`
+	UserID       uint64     `gorm:"not null" json:"-"`
+	User         User       `gorm:"foreignKey:UserID;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"user"`
+	Orders       *[]Order   `json:"orders,omitempty"`
+	CreatedAt    time.Time
+}
+
+//RobotTarget is the share of Asset a rebalance robot holds, the weights of a robot add up to 100
+type RobotTarget struct {
+	ID      uint64  `gorm:"primary_key:auto_increment" json:"-"`
+	RobotID uint64  `gorm:"not null;index" json:"-"`
+	Asset   string  `gorm:"type:varchar(16)" json:"asset"`
+	Weight  float64 `json:"weight"`
+}
+
+//RebalancePlan is what a rebalance robot would trade to return to its targets
+type RebalancePlan struct {
+	// Value is the worth of the target assets in USDT
+	Value float64 `json:"value"`
+	// Drift is the largest distance of an asset from its target in percentage points
+	Drift  float64          `json:"drift"`
+	Assets []AssetDrift     `json:"assets"`
+	Trades []RebalanceTrade `json:"trades"`
+}
+
+//AssetDrift compares the current share of an asset with its target, both in percent
+type AssetDrift struct {
+	Asset   string  `json:"asset"`
+	Target  float64 `json:"target"`
+	Current float64 `json:"current"`
+	Value   float64 `json:"value"`
+}
+
+//RebalanceTrade is one order of a plan, Skipped tells why a trade the filters refuse is not sent
+type RebalanceTrade struct {
+	Symbol   string  `json:"symbol"`
+	Side     string  `json:"side"`
+	Quantity string  `json:"quantity"`
+	Price    string  `json:"price"`
+	Value    float64 `json:"value"`
+	Skipped  string  `json:"skipped,omitempty"`
+}
+
+//RebalanceRun is one execution of the plan of a rebalance robot, its ID is part of the
+//idempotency key of every order it places so a run resumed after a restart places each trade once
+type RebalanceRun struct {
+	ID      uint64 `gorm:"primary_key:auto_increment" json:"id"`
+	RobotID uint64 `gorm:"not null;index" json:"-"`
+	Robot   Robot  `gorm:"foreignKey:RobotID;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"-"`
+	// Placed and Failed count the orders sent, a run is unfinished while FinishedAt is nil
+	Placed     int        `json:"placed"`
+	Failed     int        `json:"failed"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
 }
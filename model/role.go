@@ -0,0 +1,52 @@
+package model
+
+const (
+	// RoleAdmin manages users and may read resources across users
+	RoleAdmin = "admin"
+	// RoleTrader only sees and trades with their own resources
+	RoleTrader = "trader"
+	// RoleViewer sees their robots, orders and account but cannot change anything
+	RoleViewer = "viewer"
+)
+
+// Permissions double as token scopes, a request needs both the permission from
+// its roles and a scope allowing it
+const (
+	PermRobotsRead  = "robots:read"
+	PermRobotsWrite = "robots:write"
+	PermOrdersRead  = "orders:read"
+	PermOrdersWrite = "orders:write"
+	PermKeysRead    = "keys:read"
+	PermKeysWrite   = "keys:write"
+	PermAccountRead = "account:read"
+	// PermProfileWrite covers the caller's own profile, two-factor setup and sessions
+	PermProfileWrite = "profile:write"
+	PermUsersAdmin   = "users:admin"
+)
+
+// ReadPermissions are the permissions that never change state
+var ReadPermissions = []string{PermRobotsRead, PermOrdersRead, PermKeysRead, PermAccountRead}
+
+var rolePermissions = map[string][]string{
+	RoleAdmin:  {PermRobotsRead, PermRobotsWrite, PermOrdersRead, PermOrdersWrite, PermKeysRead, PermKeysWrite, PermAccountRead, PermProfileWrite, PermUsersAdmin},
+	RoleTrader: {PermRobotsRead, PermRobotsWrite, PermOrdersRead, PermOrdersWrite, PermKeysRead, PermKeysWrite, PermAccountRead, PermProfileWrite},
+	RoleViewer: {PermRobotsRead, PermOrdersRead, PermKeysRead, PermAccountRead, PermProfileWrite},
+}
+
+// IsRole reports whether role is one of the known roles
+func IsRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RolesAllow reports whether any of roles grants permission
+func RolesAllow(roles []string, permission string) bool {
+	for _, role := range roles {
+		for _, p := range rolePermissions[role] {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// Session is one login on one device, every refresh token it ever issued belongs to it
+type Session struct {
+	ID         uint64     `gorm:"primary_key:auto_increment" json:"id"`
+	UserID     uint64     `gorm:"not null;index" json:"-"`
+	User       User       `gorm:"foreignKey:UserID;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"-"`
+	UserAgent  string     `gorm:"type:varchar(255)" json:"user_agent"`
+	IP         string     `gorm:"type:varchar(64)" json:"ip"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Current    bool       `gorm:"-" json:"current"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// RefreshToken is a single use token, presenting one twice revokes its whole session
+type RefreshToken struct {
+	ID        uint64     `gorm:"primary_key:auto_increment" json:"id"`
+	SessionID uint64     `gorm:"not null;index" json:"-"`
+	Session   Session    `gorm:"foreignKey:SessionID;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"-"`
+	TokenHash string     `gorm:"uniqueIndex;type:varchar(64)" json:"-"`
+	UsedAt    *time.Time `json:"-"`
+	CreatedAt time.Time  `json:"-"`
+}
@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// RecoveryCode is a single use substitute for a TOTP code, only its hash is stored
+type RecoveryCode struct {
+	ID        uint64     `gorm:"primary_key:auto_increment" json:"id"`
+	UserID    uint64     `gorm:"not null;index" json:"-"`
+	User      User       `gorm:"foreignKey:UserID;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"-"`
+	CodeHash  string     `gorm:"type:varchar(64)" json:"-"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
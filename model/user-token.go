@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+const (
+	// TokenVerifyEmail confirms the address given at registration
+	TokenVerifyEmail = "verify_email"
+	// TokenResetPassword lets a user who forgot their password set a new one
+	TokenResetPassword = "reset_password"
+)
+
+// UserToken is a single use, expiring token mailed to a user, only its hash is stored
+type UserToken struct {
+	ID        uint64     `gorm:"primary_key:auto_increment" json:"id"`
+	UserID    uint64     `gorm:"not null;index" json:"-"`
+	User      User       `gorm:"foreignKey:UserID;constraint:onUpdate:CASCADE,onDelete:CASCADE" json:"-"`
+	Purpose   string     `gorm:"type:varchar(32)" json:"purpose"`
+	TokenHash string     `gorm:"uniqueIndex;type:varchar(64)" json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -3,13 +3,22 @@ package model
 import "time"
 
 type User struct {
-	ID       uint64   `gorm:"primary_key:auto_increment" json:"id"`
-	Name     string   `gorm:"type:varchar(255)" json:"name"`
-	Email    string   `gorm:"uniqueIndex;type:varchar(255)" json:"email"`
-	Password string   `gorm:"->;<-;not null" json:"-"`
-	Token    string   `gorm:"-" json:"token,omitempty"`
-	Robots   []*Robot `json:"robots,omitempty"`
-	Keys   []*BinanceAPI `json:"keys,omitempty"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID              uint64     `gorm:"primary_key:auto_increment" json:"id"`
+	Name            string     `gorm:"type:varchar(255)" json:"name"`
+	Email           string     `gorm:"uniqueIndex;type:varchar(255)" json:"email"`
+	Password        string     `gorm:"->;<-;not null" json:"-"`
+	Role            string     `gorm:"type:varchar(32);default:trader" json:"role"`
+	DisabledAt      *time.Time `json:"disabled_at,omitempty"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// TOTPSecret is sealed with TOTPDataKey by the secret service
+	TOTPSecret   string        `gorm:"type:varchar(255)" json:"-"`
+	TOTPDataKey  string        `gorm:"type:varchar(255)" json:"-"`
+	TOTPEnabled  bool          `json:"totp_enabled"`
+	TOTPLastStep int64         `json:"-"`
+	Token        string        `gorm:"-" json:"token,omitempty"`
+	RefreshToken string        `gorm:"-" json:"refresh_token,omitempty"`
+	Robots       []*Robot      `json:"robots,omitempty"`
+	Keys         []*BinanceAPI `json:"keys,omitempty"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
 }
@@ -1,7 +1,6 @@
 package repository
 
 import (
-	"fmt"
 	"github.com/myomyintko/strategy_robot/model"
 	"gorm.io/gorm"
 )
@@ -9,10 +8,13 @@ import (
 type APIRepository interface {
 	InsertAPI(b model.BinanceAPI) model.BinanceAPI
 	UpdateAPI(b model.BinanceAPI) model.BinanceAPI
-	BindStream(b model.BinanceAPI) model.BinanceAPI
+	BindStream(b model.BinanceAPI) (model.BinanceAPI, error)
+	UpdateHealth(b model.BinanceAPI)
+	ResealAll(reseal func(key *model.BinanceAPI) error) (int, error)
 	DeleteAPI(b model.BinanceAPI)
 	AllAPI() []model.BinanceAPI
-	FindAPIByID(id uint64) model.BinanceAPI
+	AllAPIByUserID(userID uint64) []model.BinanceAPI
+	FindAPIByID(userID, id uint64) model.BinanceAPI
 	FindAPIByUserID(userID uint64) model.BinanceAPI
 }
 
@@ -32,36 +34,78 @@ func (db *apiConnection) InsertAPI(key model.BinanceAPI) model.BinanceAPI {
 	return key
 }
 
+//UpdateAPI rebinds a key of key.UserID, it returns an empty key when the user has no such key
 func (db *apiConnection) UpdateAPI(key model.BinanceAPI) model.BinanceAPI {
-	db.connection.Save(&key)
-	db.connection.Preload("User").Find(&key)
-	return key
+	db.connection.Model(&key).Where("user_id = ?", key.UserID).
+		Select("api_key", "secret_key", "data_key", "secret_mask", "can_trade", "can_withdraw", "ip_restricted", "health_error", "checked_at").
+		Updates(&key)
+	return db.FindAPIByID(key.UserID, key.ID)
 }
 
-func (db *apiConnection) BindStream(key model.BinanceAPI) model.BinanceAPI {
-	if err := db.connection.Model(&key).Where("user_id = ?", &key.UserID).Updates(&key).Error; err != nil{
-		fmt.Println(err)
+//BindStream stores the stream of the key of key.UserID and returns that key
+func (db *apiConnection) BindStream(key model.BinanceAPI) (model.BinanceAPI, error) {
+	if err := db.connection.Model(&key).Where("user_id = ?", key.UserID).Updates(&key).Error; err != nil {
+		return model.BinanceAPI{}, err
 	}
-	db.connection.Preload("User").Find(&key)
-	return key
+	// key carries no ID, the key is read back by its owner alone
+	if err := db.connection.Where("user_id = ?", key.UserID).Preload("User").Take(&key).Error; err != nil {
+		return model.BinanceAPI{}, err
+	}
+	return key, nil
+}
+
+func (db *apiConnection) UpdateHealth(key model.BinanceAPI) {
+	db.connection.Model(&key).
+		Select("can_trade", "can_withdraw", "ip_restricted", "health_error", "checked_at").
+		Updates(&key)
+}
+
+//ResealAll rewrites the secret of every key with reseal in one transaction, no key changes when reseal fails for any
+func (db *apiConnection) ResealAll(reseal func(key *model.BinanceAPI) error) (int, error) {
+	var keys []model.BinanceAPI
+	err := db.connection.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Find(&keys).Error; err != nil {
+			return err
+		}
+		for i := range keys {
+			if err := reseal(&keys[i]); err != nil {
+				return err
+			}
+			err := tx.Model(&keys[i]).Select("secret_key", "data_key", "secret_mask").Updates(&keys[i]).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
 }
 
 func (db *apiConnection) DeleteAPI(key model.BinanceAPI) {
-	db.connection.Delete(&key)
+	db.connection.Where("user_id = ?", key.UserID).Delete(&key)
 }
 
-func (db *apiConnection) FindAPIByID(id uint64) model.BinanceAPI {
+func (db *apiConnection) FindAPIByID(userID, id uint64) model.BinanceAPI {
 	var key model.BinanceAPI
-	db.connection.Preload("User").Find(&key, id)
+	db.connection.Preload("User").Where("user_id = ?", userID).Find(&key, id)
 	return key
 }
 
 func (db *apiConnection) FindAPIByUserID(userID uint64) model.BinanceAPI {
 	var key model.BinanceAPI
-	db.connection.Find(&key, "user_id =?",userID)
+	db.connection.Find(&key, "user_id =?", userID)
 	return key
 }
 
+func (db *apiConnection) AllAPIByUserID(userID uint64) []model.BinanceAPI {
+	var keys []model.BinanceAPI
+	db.connection.Preload("User").Where("user_id = ?", userID).Find(&keys)
+	return keys
+}
+
 func (db *apiConnection) AllAPI() []model.BinanceAPI {
 	var keys []model.BinanceAPI
 	db.connection.Preload("User").Find(&keys)
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/myomyintko/strategy_robot/model"
+)
+
+func TestResealAllRollsBackWhenAnyKeyFails(t *testing.T) {
+	db := newTestDB(t)
+	repo := NewAPIRepository(db)
+	user := insertTestUser(t, db, "a@example.com")
+	for _, k := range []string{"key-1", "key-2"} {
+		repo.InsertAPI(model.BinanceAPI{APIKey: k, SecretKey: "old-" + k, DataKey: "old", UserID: user.ID})
+	}
+
+	_, err := repo.ResealAll(func(key *model.BinanceAPI) error {
+		if key.APIKey == "key-2" {
+			return errors.New("cannot open")
+		}
+		key.SecretKey, key.DataKey = "new", "new"
+		return nil
+	})
+	if err == nil {
+		t.Fatal("ResealAll succeeded although a key failed")
+	}
+	for _, key := range repo.AllAPI() {
+		if key.DataKey != "old" || key.SecretKey != "old-"+key.APIKey {
+			t.Errorf("key %s was rewritten to %q under %q", key.APIKey, key.SecretKey, key.DataKey)
+		}
+	}
+
+	n, err := repo.ResealAll(func(key *model.BinanceAPI) error {
+		key.SecretKey, key.DataKey = "new", "new"
+		return nil
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("ResealAll = %d, %v", n, err)
+	}
+	for _, key := range repo.AllAPI() {
+		if key.DataKey != "new" {
+			t.Errorf("key %s was not resealed", key.APIKey)
+		}
+	}
+}
+
+func TestKeysOfAnotherUserCannotBeReadUpdatedOrDeleted(t *testing.T) {
+	db := newTestDB(t)
+	repo := NewAPIRepository(db)
+	alice := insertTestUser(t, db, "alice@example.com")
+	bob := insertTestUser(t, db, "bob@example.com")
+	key := repo.InsertAPI(model.BinanceAPI{APIKey: "bob-key", SecretKey: "sealed", DataKey: "data", UserID: bob.ID})
+
+	if got := repo.FindAPIByID(alice.ID, key.ID); got.ID != 0 {
+		t.Errorf("alice read key %d of bob", got.ID)
+	}
+	if got := repo.AllAPIByUserID(alice.ID); len(got) != 0 {
+		t.Errorf("alice listed %d keys of bob", len(got))
+	}
+	if got := repo.UpdateAPI(model.BinanceAPI{ID: key.ID, UserID: alice.ID, APIKey: "alice-key", SecretKey: "other"}); got.ID != 0 {
+		t.Errorf("alice updated key %d of bob", got.ID)
+	}
+	repo.DeleteAPI(model.BinanceAPI{ID: key.ID, UserID: alice.ID})
+
+	got := repo.FindAPIByID(bob.ID, key.ID)
+	if got.ID == 0 {
+		t.Fatal("alice deleted the key of bob")
+	}
+	if got.APIKey != "bob-key" || got.SecretKey != "sealed" {
+		t.Errorf("key of bob changed to %s", got.APIKey)
+	}
+}
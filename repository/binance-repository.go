@@ -1,26 +1,110 @@
 package repository
 
 import (
+	"errors"
+	"time"
+
 	"github.com/myomyintko/strategy_robot/model"
 	"gorm.io/gorm"
 )
 
 type BinanceRepository interface {
-	InsertOrder(b model.Order) model.Order
+	InsertOrder(b model.Order) (model.Order, error)
+	UpdateOrder(b model.Order) model.Order
+	FindOrderByClientID(clientOrderID string) model.Order
+	FindOrderByExchangeID(userID uint64, orderID int64) model.Order
+	FindUnsettledOrders(userID uint64, symbol string) []model.Order
+	FindOrderedSymbols(since time.Time) []model.Order
+	FindUnsettledOrdersOfRobot(robotID uint64) []model.Order
+	MarkOrdersUnknown(ids []uint64) int
+	InsertDiscrepancy(d model.OrderDiscrepancy) model.OrderDiscrepancy
+	FindDiscrepancies(userID uint64, limit int) []model.OrderDiscrepancy
 }
 
 type binanceConnection struct {
 	connection *gorm.DB
 }
 
+//ErrDuplicateClientOrderID is an order stored under a client order ID another order already has
+var ErrDuplicateClientOrderID = errors.New("client order ID is already taken")
+
 func NewBinanceRepository(dbConn *gorm.DB) BinanceRepository {
 	return &binanceConnection{
 		connection: dbConn,
 	}
 }
 
-func (db *binanceConnection) InsertOrder(order model.Order) model.Order {
-	db.connection.Save(&order)
+//InsertOrder stores order, it returns ErrDuplicateClientOrderID when another order holds its client order ID
+func (db *binanceConnection) InsertOrder(order model.Order) (model.Order, error) {
+	if err := db.connection.Create(&order).Error; err != nil {
+		// every driver reports a unique violation in its own error type, the row that
+		// holds the ID tells it apart from any other failure on every database
+		if order.ClientOrderId != "" && db.FindOrderByClientID(order.ClientOrderId).ID != 0 {
+			return model.Order{}, ErrDuplicateClientOrderID
+		}
+		return model.Order{}, err
+	}
+	db.connection.Preload("Robot").Find(&order)
+	return order, nil
+}
+
+func (db *binanceConnection) UpdateOrder(order model.Order) model.Order {
+	db.connection.Omit("Robot").Save(&order)
 	db.connection.Preload("Robot").Find(&order)
 	return order
 }
+
+func (db *binanceConnection) FindOrderByClientID(clientOrderID string) model.Order {
+	var order model.Order
+	db.connection.Preload("Robot").Where("client_order_id = ?", clientOrderID).Limit(1).Find(&order)
+	return order
+}
+
+func (db *binanceConnection) FindOrderByExchangeID(userID uint64, orderID int64) model.Order {
+	var order model.Order
+	db.connection.Preload("Robot").Where("user_id = ? AND order_id = ?", userID, orderID).Limit(1).Find(&order)
+	return order
+}
+
+//FindUnsettledOrders lists the orders of userID on symbol whose status may still change
+func (db *binanceConnection) FindUnsettledOrders(userID uint64, symbol string) []model.Order {
+	var orders []model.Order
+	db.connection.Where("user_id = ? AND symbol = ? AND status NOT IN ?", userID, symbol, model.FinalOrderStatuses).Find(&orders)
+	return orders
+}
+
+//FindUnsettledOrdersOfRobot lists the orders of robotID whose status may still change
+func (db *binanceConnection) FindUnsettledOrdersOfRobot(robotID uint64) []model.Order {
+	var orders []model.Order
+	db.connection.Where("robot_id = ? AND status NOT IN ?", robotID, model.FinalOrderStatuses).Find(&orders)
+	return orders
+}
+
+//FindOrderedSymbols lists each user, robot and symbol with an unsettled order or an order
+//placed since since, only those three fields are set
+func (db *binanceConnection) FindOrderedSymbols(since time.Time) []model.Order {
+	var orders []model.Order
+	db.connection.Model(&model.Order{}).Distinct("user_id", "robot_id", "symbol").
+		Where("status NOT IN ? OR ordered_at >= ?", model.FinalOrderStatuses, since).
+		Order("robot_id").Find(&orders)
+	return orders
+}
+
+//MarkOrdersUnknown moves the orders of ids still pending to unknown and returns how many moved
+func (db *binanceConnection) MarkOrdersUnknown(ids []uint64) int {
+	return int(db.connection.Model(&model.Order{}).
+		Where("id IN ? AND status = ?", ids, model.OrderPending).
+		Update("status", model.OrderUnknown).RowsAffected)
+}
+
+func (db *binanceConnection) InsertDiscrepancy(discrepancy model.OrderDiscrepancy) model.OrderDiscrepancy {
+	db.connection.Create(&discrepancy)
+	return discrepancy
+}
+
+//FindDiscrepancies lists the latest discrepancies of userID, newest first
+func (db *binanceConnection) FindDiscrepancies(userID uint64, limit int) []model.OrderDiscrepancy {
+	var discrepancies []model.OrderDiscrepancy
+	db.connection.Where("user_id = ?", userID).Order("detected_at desc").Limit(limit).Find(&discrepancies)
+	return discrepancies
+}
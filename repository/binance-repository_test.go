@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/myomyintko/strategy_robot/model"
+)
+
+func TestOrdersOfAnotherUserCannotBeRead(t *testing.T) {
+	db := newTestDB(t)
+	repo := NewBinanceRepository(db)
+	alice := insertTestUser(t, db, "alice@example.com")
+	bob := insertTestUser(t, db, "bob@example.com")
+	robot := NewRobotRepository(db).InsertRobot(model.Robot{Kind: model.RobotKindSymbol, Symbol: "BTCUSDT", UserID: bob.ID})
+	order, _ := repo.InsertOrder(model.Order{OrderId: 42, ClientOrderId: "bob-order", Symbol: "BTCUSDT", Status: "NEW", UserID: bob.ID, RobotID: robot.ID})
+	repo.InsertDiscrepancy(model.OrderDiscrepancy{UserID: bob.ID, OrderID: order.ID, Kind: model.DiscrepancyStatus, DetectedAt: time.Now()})
+
+	if got := repo.FindOrderByExchangeID(alice.ID, 42); got.ID != 0 {
+		t.Errorf("alice read order %d of bob", got.ID)
+	}
+	if got := repo.FindUnsettledOrders(alice.ID, "BTCUSDT"); len(got) != 0 {
+		t.Errorf("alice listed %d orders of bob", len(got))
+	}
+	if got := repo.FindDiscrepancies(alice.ID, 10); len(got) != 0 {
+		t.Errorf("alice listed %d discrepancies of bob", len(got))
+	}
+	if got := repo.FindOrderByExchangeID(bob.ID, 42); got.ID != order.ID {
+		t.Errorf("bob cannot read their own order")
+	}
+}
+
+func TestOnlyATakenClientOrderIDIsADuplicate(t *testing.T) {
+	db := newTestDB(t)
+	repo := NewBinanceRepository(db)
+	user := insertTestUser(t, db, "alice@example.com")
+	robot := NewRobotRepository(db).InsertRobot(model.Robot{Kind: model.RobotKindSymbol, Symbol: "BTCUSDT", UserID: user.ID})
+	first, err := repo.InsertOrder(model.Order{ClientOrderId: "sr-1", Symbol: "BTCUSDT", Status: model.OrderPending, UserID: user.ID, RobotID: robot.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repo.InsertOrder(model.Order{ClientOrderId: "sr-1", Symbol: "BTCUSDT", Status: model.OrderPending, UserID: user.ID, RobotID: robot.ID})
+	if !errors.Is(err, ErrDuplicateClientOrderID) {
+		t.Errorf("second order under a taken client order ID = %v, want ErrDuplicateClientOrderID", err)
+	}
+	// a failure of any other kind is not mistaken for a duplicate
+	_, err = repo.InsertOrder(model.Order{ID: first.ID, ClientOrderId: "sr-2", Symbol: "BTCUSDT", Status: model.OrderPending, UserID: user.ID, RobotID: robot.ID})
+	if err == nil || errors.Is(err, ErrDuplicateClientOrderID) {
+		t.Errorf("order under a taken primary key = %v, want the database error", err)
+	}
+}
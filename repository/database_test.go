@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/myomyintko/strategy_robot/model"
+)
+
+//TestValuesRoundTrip covers the column types the dialects store differently,
+//run it with TEST_DB_DRIVER set to check mysql or postgres
+func TestValuesRoundTrip(t *testing.T) {
+	db := newTestDB(t)
+	user := insertTestUser(t, db, "alice@example.com")
+	disabledAt := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	db.Model(&user).Updates(map[string]interface{}{"disabled_at": disabledAt, "totp_enabled": true})
+
+	robots := NewRobotRepository(db)
+	robot := robots.InsertRobot(model.Robot{
+		Kind:            model.RobotKindRebalance,
+		QuoteAsset:      "USDT",
+		Threshold:       2.5,
+		IntervalMinutes: 60,
+		MaxTurnover:     12.75,
+		UserID:          user.ID,
+		Targets:         []model.RobotTarget{{Asset: "BTC", Weight: 60}, {Asset: "ETH", Weight: 40}},
+	})
+
+	got := NewUserRepository(db).FindByID(user.ID)
+	if got.DisabledAt == nil || !got.DisabledAt.Equal(disabledAt) || !got.TOTPEnabled {
+		t.Errorf("user read back as disabled %v, totp %v", got.DisabledAt, got.TOTPEnabled)
+	}
+	stored := robots.FindRobotByID(user.ID, robot.ID)
+	if stored.Threshold != 2.5 || stored.IntervalMinutes != 60 || stored.MaxTurnover != 12.75 || len(stored.Targets) != 2 {
+		t.Errorf("robot read back as %+v", stored)
+	}
+}
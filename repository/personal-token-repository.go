@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/myomyintko/strategy_robot/model"
+	"gorm.io/gorm"
+)
+
+//PersonalTokenRepository is contract what personalTokenRepository can do to db
+type PersonalTokenRepository interface {
+	InsertToken(t model.PersonalAccessToken) model.PersonalAccessToken
+	FindTokensByUserID(userID uint64) []model.PersonalAccessToken
+	FindTokenByHash(hash string) model.PersonalAccessToken
+	RevokeToken(userID, tokenID uint64) bool
+	TouchToken(tokenID uint64, at time.Time)
+}
+
+type personalTokenConnection struct {
+	connection *gorm.DB
+}
+
+//NewPersonalTokenRepository is creates a new instance of PersonalTokenRepository
+func NewPersonalTokenRepository(db *gorm.DB) PersonalTokenRepository {
+	return &personalTokenConnection{
+		connection: db,
+	}
+}
+
+func (db *personalTokenConnection) InsertToken(token model.PersonalAccessToken) model.PersonalAccessToken {
+	db.connection.Save(&token)
+	return token
+}
+
+func (db *personalTokenConnection) FindTokensByUserID(userID uint64) []model.PersonalAccessToken {
+	var tokens []model.PersonalAccessToken
+	db.connection.Where("user_id = ? AND revoked_at IS NULL", userID).Order("id").Find(&tokens)
+	return tokens
+}
+
+func (db *personalTokenConnection) FindTokenByHash(hash string) model.PersonalAccessToken {
+	var token model.PersonalAccessToken
+	db.connection.Preload("User").Where("token_hash = ?", hash).Find(&token)
+	return token
+}
+
+func (db *personalTokenConnection) RevokeToken(userID, tokenID uint64) bool {
+	res := db.connection.Model(&model.PersonalAccessToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", tokenID, userID).
+		Update("revoked_at", time.Now())
+	return res.RowsAffected > 0
+}
+
+func (db *personalTokenConnection) TouchToken(tokenID uint64, at time.Time) {
+	db.connection.Model(&model.PersonalAccessToken{}).Where("id = ?", tokenID).Update("last_used_at", at)
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/myomyintko/strategy_robot/model"
+	"gorm.io/gorm"
+)
+
+type PortfolioRepository interface {
+	InsertSnapshot(s model.PortfolioSnapshot) model.PortfolioSnapshot
+	FindSnapshots(userID uint64, from, to time.Time, limit int) []model.PortfolioSnapshot
+}
+
+type portfolioConnection struct {
+	connection *gorm.DB
+}
+
+func NewPortfolioRepository(dbConn *gorm.DB) PortfolioRepository {
+	return &portfolioConnection{
+		connection: dbConn,
+	}
+}
+
+//InsertSnapshot stores the snapshot together with its balances
+func (db *portfolioConnection) InsertSnapshot(snapshot model.PortfolioSnapshot) model.PortfolioSnapshot {
+	db.connection.Create(&snapshot)
+	return snapshot
+}
+
+//FindSnapshots lists the newest limit snapshots of userID taken between from and to, oldest
+//first and without balances
+func (db *portfolioConnection) FindSnapshots(userID uint64, from, to time.Time, limit int) []model.PortfolioSnapshot {
+	var snapshots []model.PortfolioSnapshot
+	db.connection.Where("user_id = ? AND taken_at BETWEEN ? AND ?", userID, from, to).
+		Order("taken_at DESC").Order("id DESC").Limit(limit).Find(&snapshots)
+	for i, j := 0, len(snapshots)-1; i < j; i, j = i+1, j-1 {
+		snapshots[i], snapshots[j] = snapshots[j], snapshots[i]
+	}
+	return snapshots
+}
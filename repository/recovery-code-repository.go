@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/myomyintko/strategy_robot/model"
+	"gorm.io/gorm"
+)
+
+//RecoveryCodeRepository is contract what recoveryCodeRepository can do to db
+type RecoveryCodeRepository interface {
+	ReplaceRecoveryCodes(userID uint64, codes []model.RecoveryCode)
+	UseRecoveryCode(userID uint64, hash string) bool
+	DeleteRecoveryCodes(userID uint64)
+}
+
+type recoveryCodeConnection struct {
+	connection *gorm.DB
+}
+
+//NewRecoveryCodeRepository is creates a new instance of RecoveryCodeRepository
+func NewRecoveryCodeRepository(db *gorm.DB) RecoveryCodeRepository {
+	return &recoveryCodeConnection{
+		connection: db,
+	}
+}
+
+func (db *recoveryCodeConnection) ReplaceRecoveryCodes(userID uint64, codes []model.RecoveryCode) {
+	db.connection.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+func (db *recoveryCodeConnection) UseRecoveryCode(userID uint64, hash string) bool {
+	res := db.connection.Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", time.Now())
+	return res.RowsAffected > 0
+}
+
+func (db *recoveryCodeConnection) DeleteRecoveryCodes(userID uint64) {
+	db.connection.Where("user_id = ?", userID).Delete(&model.RecoveryCode{})
+}
@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/myomyintko/strategy_robot/internal/testdb"
+	"github.com/myomyintko/strategy_robot/migration"
+	"github.com/myomyintko/strategy_robot/model"
+	"gorm.io/gorm"
+)
+
+//newTestDB is a migrated database closed when t ends, see testdb.Open for the drivers it runs on
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := testdb.Open(t)
+	if _, err := migration.Up(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func insertTestUser(t *testing.T, db *gorm.DB, email string) model.User {
+	t.Helper()
+	user := model.User{Name: email, Email: email, Password: "hash", Role: model.RoleTrader}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
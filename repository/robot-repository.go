@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"time"
+
 	"github.com/myomyintko/strategy_robot/model"
 	"gorm.io/gorm"
 )
@@ -10,8 +12,10 @@ type RobotRepository interface {
 	UpdateRobot(b model.Robot) model.Robot
 	DeleteRobot(b model.Robot)
 	AllRobot() []model.Robot
-	FindRobotByID(robotID uint64) model.Robot
+	FindRobotByID(userID, robotID uint64) model.Robot
 	FindRobotByUserID(robotID uint64) model.Robot
+	StartRebalanceRun(robotID uint64, at time.Time) model.RebalanceRun
+	FinishRebalanceRun(run model.RebalanceRun, at time.Time, rebalanced bool) error
 }
 
 type robotConnection struct {
@@ -26,34 +30,82 @@ func NewRobotRepository(dbConn *gorm.DB) RobotRepository {
 
 func (db *robotConnection) InsertRobot(robot model.Robot) model.Robot {
 	db.connection.Save(&robot)
-	db.connection.Preload("User").Find(&robot)
+	db.connection.Preload("User").Preload("Targets").Find(&robot)
 	return robot
 }
 
+//UpdateRobot replaces the settings and targets of a robot of robot.UserID in one transaction,
+//it returns an empty robot when the user has no such robot or the update fails
 func (db *robotConnection) UpdateRobot(robot model.Robot) model.Robot {
-	db.connection.Save(&robot)
-	db.connection.Preload("User").Find(&robot)
-	return robot
+	if db.FindRobotByID(robot.UserID, robot.ID).ID == 0 {
+		return model.Robot{}
+	}
+	err := db.connection.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&robot).Where("user_id = ?", robot.UserID).
+			Select("kind", "symbol", "quote_asset", "threshold", "interval_minutes", "max_turnover").
+			Updates(&robot).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("robot_id = ?", robot.ID).Delete(&model.RobotTarget{}).Error; err != nil {
+			return err
+		}
+		for i := range robot.Targets {
+			robot.Targets[i].RobotID = robot.ID
+		}
+		if len(robot.Targets) > 0 {
+			return tx.Create(&robot.Targets).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return model.Robot{}
+	}
+	return db.FindRobotByID(robot.UserID, robot.ID)
 }
 
 func (db *robotConnection) DeleteRobot(robot model.Robot) {
-	db.connection.Delete(&robot)
+	db.connection.Where("user_id = ?", robot.UserID).Delete(&robot)
 }
 
-func (db *robotConnection) FindRobotByID(robotID uint64) model.Robot {
+func (db *robotConnection) FindRobotByID(userID, robotID uint64) model.Robot {
 	var robot model.Robot
-	db.connection.Preload("User").Find(&robot, robotID)
+	db.connection.Preload("User").Preload("Targets").Where("user_id = ?", userID).Find(&robot, robotID)
 	return robot
 }
 
 func (db *robotConnection) FindRobotByUserID(robotID uint64) model.Robot {
 	var robot model.Robot
-	db.connection.Preload("User").Where("user_id = ?",robotID).Find(&robot)
+	db.connection.Preload("User").Preload("Targets").Where("user_id = ?", robotID).Find(&robot)
 	return robot
 }
 
 func (db *robotConnection) AllRobot() []model.Robot {
 	var robots []model.Robot
-	db.connection.Preload("User").Find(&robots)
+	db.connection.Preload("User").Preload("Targets").Find(&robots)
 	return robots
 }
+
+//StartRebalanceRun returns the unfinished run of robotID, a run cut short by a shutdown is
+//resumed under its ID, or starts a new one at at
+func (db *robotConnection) StartRebalanceRun(robotID uint64, at time.Time) model.RebalanceRun {
+	var run model.RebalanceRun
+	db.connection.Where("robot_id = ? AND finished_at IS NULL", robotID).Order("id").Limit(1).Find(&run)
+	if run.ID == 0 {
+		run = model.RebalanceRun{RobotID: robotID, StartedAt: at}
+		db.connection.Create(&run)
+	}
+	return run
+}
+
+//FinishRebalanceRun stores the counts of run and finishes it at at. When rebalanced is set the
+//robot is marked rebalanced at at in the same transaction
+func (db *robotConnection) FinishRebalanceRun(run model.RebalanceRun, at time.Time, rebalanced bool) error {
+	return db.connection.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&run).Updates(map[string]interface{}{"placed": run.Placed, "failed": run.Failed, "finished_at": at}).Error
+		if err != nil || !rebalanced {
+			return err
+		}
+		return tx.Model(&model.Robot{}).Where("id = ?", run.RobotID).Update("rebalanced_at", at).Error
+	})
+}
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/myomyintko/strategy_robot/model"
+	"gorm.io/gorm"
+)
+
+func TestRobotsOfAnotherUserCannotBeReadUpdatedOrDeleted(t *testing.T) {
+	db := newTestDB(t)
+	repo := NewRobotRepository(db)
+	alice := insertTestUser(t, db, "alice@example.com")
+	bob := insertTestUser(t, db, "bob@example.com")
+	robot := repo.InsertRobot(model.Robot{Kind: model.RobotKindSymbol, Symbol: "BTCUSDT", UserID: bob.ID})
+
+	if got := repo.FindRobotByID(alice.ID, robot.ID); got.ID != 0 {
+		t.Errorf("alice read robot %d of bob", got.ID)
+	}
+	if got := repo.FindRobotByUserID(alice.ID); got.ID != 0 {
+		t.Errorf("alice listed robot %d of bob", got.ID)
+	}
+	if got := repo.UpdateRobot(model.Robot{ID: robot.ID, UserID: alice.ID, Kind: model.RobotKindSymbol, Symbol: "ETHUSDT"}); got.ID != 0 {
+		t.Errorf("alice updated robot %d of bob", got.ID)
+	}
+	repo.DeleteRobot(model.Robot{ID: robot.ID, UserID: alice.ID})
+
+	got := repo.FindRobotByID(bob.ID, robot.ID)
+	if got.ID == 0 {
+		t.Fatal("alice deleted the robot of bob")
+	}
+	if got.Symbol != "BTCUSDT" || got.UserID != bob.ID {
+		t.Errorf("robot of bob changed to %s of user %d", got.Symbol, got.UserID)
+	}
+}
+
+func TestFailedRobotUpdateKeepsTheOldTargets(t *testing.T) {
+	db := newTestDB(t)
+	repo := NewRobotRepository(db)
+	user := insertTestUser(t, db, "alice@example.com")
+	robot := repo.InsertRobot(model.Robot{Kind: model.RobotKindRebalance, Threshold: 5, UserID: user.ID, Targets: []model.RobotTarget{{Asset: "BTC", Weight: 100}}})
+	err := db.Callback().Create().Before("gorm:create").Register("test:fail_targets", func(tx *gorm.DB) {
+		if tx.Statement.Table == "robot_targets" {
+			_ = tx.AddError(errors.New("disk full"))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := repo.UpdateRobot(model.Robot{ID: robot.ID, UserID: user.ID, Kind: model.RobotKindRebalance, Threshold: 10, Targets: []model.RobotTarget{{Asset: "ETH", Weight: 100}}})
+	if got.ID != 0 {
+		t.Errorf("failed update returned robot %d", got.ID)
+	}
+	stored := repo.FindRobotByID(user.ID, robot.ID)
+	if stored.Threshold != 5 || len(stored.Targets) != 1 || stored.Targets[0].Asset != "BTC" {
+		t.Errorf("failed update left threshold %v and targets %+v", stored.Threshold, stored.Targets)
+	}
+}
+
+func TestRebalanceRunIsResumedUntilFinished(t *testing.T) {
+	db := newTestDB(t)
+	repo := NewRobotRepository(db)
+	user := insertTestUser(t, db, "alice@example.com")
+	robot := repo.InsertRobot(model.Robot{Kind: model.RobotKindRebalance, UserID: user.ID})
+	first := repo.StartRebalanceRun(robot.ID, time.Now())
+	if resumed := repo.StartRebalanceRun(robot.ID, time.Now()); resumed.ID != first.ID {
+		t.Errorf("unfinished run %d was not resumed, got run %d", first.ID, resumed.ID)
+	}
+
+	first.Failed = 2
+	if err := repo.FinishRebalanceRun(first, time.Now(), false); err != nil {
+		t.Fatal(err)
+	}
+	if got := repo.FindRobotByID(user.ID, robot.ID); got.RebalancedAt != nil {
+		t.Errorf("run whose orders all failed marked the robot rebalanced at %v", got.RebalancedAt)
+	}
+	second := repo.StartRebalanceRun(robot.ID, time.Now())
+	if second.ID == first.ID {
+		t.Fatal("finished run was resumed")
+	}
+	if err := repo.FinishRebalanceRun(second, time.Now(), true); err != nil {
+		t.Fatal(err)
+	}
+	if got := repo.FindRobotByID(user.ID, robot.ID); got.RebalancedAt == nil {
+		t.Error("finished run did not mark the robot rebalanced")
+	}
+}
+
+func TestFailedRunFinishIsReportedAndRolledBack(t *testing.T) {
+	db := newTestDB(t)
+	repo := NewRobotRepository(db)
+	user := insertTestUser(t, db, "alice@example.com")
+	robot := repo.InsertRobot(model.Robot{Kind: model.RobotKindRebalance, UserID: user.ID})
+	run := repo.StartRebalanceRun(robot.ID, time.Now())
+	err := db.Callback().Update().Before("gorm:update").Register("test:fail_robots", func(tx *gorm.DB) {
+		if tx.Statement.Table == "robots" {
+			_ = tx.AddError(errors.New("disk full"))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.FinishRebalanceRun(run, time.Now(), true); err == nil {
+		t.Fatal("failed finish returned no error")
+	}
+	if resumed := repo.StartRebalanceRun(robot.ID, time.Now()); resumed.ID != run.ID {
+		t.Errorf("run %d was finished although marking its robot failed", run.ID)
+	}
+}
+
+func TestDeletingARobotCascadesToItsOrdersAndTargets(t *testing.T) {
+	db := newTestDB(t)
+	repo := NewRobotRepository(db)
+	user := insertTestUser(t, db, "alice@example.com")
+	robot := repo.InsertRobot(model.Robot{Kind: model.RobotKindRebalance, UserID: user.ID, Targets: []model.RobotTarget{{Asset: "BTC", Weight: 100}}})
+	if _, err := NewBinanceRepository(db).InsertOrder(model.Order{ClientOrderId: "sr-1", Symbol: "BTCUSDT", Status: "NEW", UserID: user.ID, RobotID: robot.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	repo.DeleteRobot(robot)
+	var orders, targets int64
+	db.Model(&model.Order{}).Where("robot_id = ?", robot.ID).Count(&orders)
+	db.Model(&model.RobotTarget{}).Where("robot_id = ?", robot.ID).Count(&targets)
+	if orders != 0 || targets != 0 {
+		t.Errorf("deleted robot left %d orders and %d targets", orders, targets)
+	}
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/myomyintko/strategy_robot/model"
+	"gorm.io/gorm"
+)
+
+//SessionRepository is contract what sessionRepository can do to db
+type SessionRepository interface {
+	InsertSession(s model.Session) model.Session
+	UpdateSession(s model.Session) model.Session
+	FindSessionByID(sessionID uint64) model.Session
+	ActiveSessionsByUserID(userID uint64) []model.Session
+	RevokeSession(userID, sessionID uint64) bool
+	RevokeSessionsByUserID(userID uint64)
+	InsertRefreshToken(t model.RefreshToken) model.RefreshToken
+	FindRefreshTokenByHash(hash string) model.RefreshToken
+	MarkRefreshTokenUsed(t model.RefreshToken) bool
+}
+
+type sessionConnection struct {
+	connection *gorm.DB
+}
+
+//NewSessionRepository is creates a new instance of SessionRepository
+func NewSessionRepository(db *gorm.DB) SessionRepository {
+	return &sessionConnection{
+		connection: db,
+	}
+}
+
+func (db *sessionConnection) InsertSession(session model.Session) model.Session {
+	db.connection.Save(&session)
+	return session
+}
+
+func (db *sessionConnection) UpdateSession(session model.Session) model.Session {
+	db.connection.Save(&session)
+	return session
+}
+
+func (db *sessionConnection) FindSessionByID(sessionID uint64) model.Session {
+	var session model.Session
+	db.connection.Find(&session, sessionID)
+	return session
+}
+
+func (db *sessionConnection) ActiveSessionsByUserID(userID uint64) []model.Session {
+	var sessions []model.Session
+	db.connection.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at desc").Find(&sessions)
+	return sessions
+}
+
+func (db *sessionConnection) RevokeSession(userID, sessionID uint64) bool {
+	res := db.connection.Model(&model.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Update("revoked_at", time.Now())
+	return res.RowsAffected > 0
+}
+
+func (db *sessionConnection) RevokeSessionsByUserID(userID uint64) {
+	db.connection.Model(&model.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now())
+}
+
+func (db *sessionConnection) InsertRefreshToken(token model.RefreshToken) model.RefreshToken {
+	db.connection.Save(&token)
+	return token
+}
+
+func (db *sessionConnection) FindRefreshTokenByHash(hash string) model.RefreshToken {
+	var token model.RefreshToken
+	db.connection.Where("token_hash = ?", hash).Find(&token)
+	return token
+}
+
+//MarkRefreshTokenUsed reports false when another request already used the token
+func (db *sessionConnection) MarkRefreshTokenUsed(token model.RefreshToken) bool {
+	res := db.connection.Model(&model.RefreshToken{}).
+		Where("id = ? AND used_at IS NULL", token.ID).
+		Update("used_at", time.Now())
+	return res.RowsAffected > 0
+}
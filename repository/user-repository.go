@@ -1,7 +1,8 @@
 package repository
 
 import (
-	"log"
+	"fmt"
+	"time"
 
 	"github.com/myomyintko/strategy_robot/model"
 	"golang.org/x/crypto/bcrypt"
@@ -15,7 +16,15 @@ type UserRepository interface {
 	VerifyCredential(email string) interface{}
 	IsDuplicateEmail(email string) (tx *gorm.DB)
 	FindByEmail(email string) model.User
+	FindByID(userID uint64) model.User
 	ProfileUser(userID string) model.User
+	AllUser() []model.User
+	UpdateRole(userID uint64, role string)
+	UpdateDisabled(userID uint64, disabledAt *time.Time)
+	UpdatePassword(userID uint64, password string)
+	MarkEmailVerified(userID uint64)
+	UpdateTOTP(user model.User)
+	ClaimTOTPStep(userID uint64, step int64) bool
 }
 
 type userConnection struct {
@@ -35,16 +44,21 @@ func (db *userConnection) InsertUser(user model.User) model.User {
 	return user
 }
 
+//UpdateUser changes the name and email of the user, and the password when one is given. A new
+//email is unverified again, every other column, such as the role, two-factor state or disabled
+//time, is left as it is
 func (db *userConnection) UpdateUser(user model.User) model.User {
+	columns := []interface{}{"email"}
+	if user.Email != db.FindByID(user.ID).Email {
+		user.EmailVerifiedAt = nil
+		columns = append(columns, "email_verified_at")
+	}
 	if user.Password != "" {
 		user.Password = hashAndSalt([]byte(user.Password))
-	} else {
-		var tempUser model.User
-		db.connection.Find(&tempUser, user.ID)
-		user.Password = tempUser.Password
+		columns = append(columns, "password")
 	}
-	db.connection.Save(&user)
-	return user
+	db.connection.Model(&user).Select("name", columns...).Updates(&user)
+	return db.FindByID(user.ID)
 }
 
 func (db *userConnection) VerifyCredential(email string) interface{} {
@@ -67,17 +81,61 @@ func (db *userConnection) FindByEmail(email string) model.User {
 	return user
 }
 
+func (db *userConnection) FindByID(userID uint64) model.User {
+	var user model.User
+	db.connection.Find(&user, userID)
+	return user
+}
+
 func (db *userConnection) ProfileUser(userID string) model.User {
 	var user model.User
 	db.connection.Preload("Robots.User").Preload("Keys.User").Find(&user, userID)
 	return user
 }
 
+func (db *userConnection) AllUser() []model.User {
+	var users []model.User
+	db.connection.Order("id").Find(&users)
+	return users
+}
+
+func (db *userConnection) UpdateRole(userID uint64, role string) {
+	db.connection.Model(&model.User{}).Where("id = ?", userID).Update("role", role)
+}
+
+func (db *userConnection) UpdateDisabled(userID uint64, disabledAt *time.Time) {
+	db.connection.Model(&model.User{}).Where("id = ?", userID).Update("disabled_at", disabledAt)
+}
+
+func (db *userConnection) UpdatePassword(userID uint64, password string) {
+	db.connection.Model(&model.User{}).Where("id = ?", userID).Update("password", hashAndSalt([]byte(password)))
+}
+
+func (db *userConnection) MarkEmailVerified(userID uint64) {
+	db.connection.Model(&model.User{}).Where("id = ?", userID).Update("email_verified_at", time.Now())
+}
+
+func (db *userConnection) UpdateTOTP(user model.User) {
+	db.connection.Model(&user).
+		Select("totp_secret", "totp_data_key", "totp_enabled", "totp_last_step").
+		Updates(&user)
+}
+
+//ClaimTOTPStep records step as used, it reports false when it or a later step was used already
+func (db *userConnection) ClaimTOTPStep(userID uint64, step int64) bool {
+	res := db.connection.Model(&model.User{}).
+		Where("id = ? AND totp_last_step < ?", userID, step).
+		Update("totp_last_step", step)
+	return res.RowsAffected > 0
+}
+
+//PasswordCost is the bcrypt cost of new password hashes, older hashes are upgraded on login
+const PasswordCost = 12
+
 func hashAndSalt(pwd []byte) string {
-	hash, err := bcrypt.GenerateFromPassword(pwd, bcrypt.MinCost)
+	hash, err := bcrypt.GenerateFromPassword(pwd, PasswordCost)
 	if err != nil {
-		log.Println(err)
-		log.Fatal("Failed to hash a password")
+		panic(fmt.Sprintf("failed to hash a password: %v", err))
 	}
 	return string(hash)
 }
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/myomyintko/strategy_robot/model"
+)
+
+func TestUpdateUserKeepsEverythingButTheProfile(t *testing.T) {
+	db := newTestDB(t)
+	repo := NewUserRepository(db)
+	now := time.Now()
+	user := insertTestUser(t, db, "alice@example.com")
+	db.Model(&user).Updates(map[string]interface{}{
+		"role":              model.RoleAdmin,
+		"totp_secret":       "sealed",
+		"totp_data_key":     "data",
+		"totp_enabled":      true,
+		"totp_last_step":    7,
+		"disabled_at":       now,
+		"email_verified_at": now,
+	})
+
+	repo.UpdateUser(model.User{ID: user.ID, Name: "Alice", Email: "alice@example.com"})
+	got := repo.FindByID(user.ID)
+	if got.Name != "Alice" {
+		t.Errorf("name = %q, want Alice", got.Name)
+	}
+	if !got.TOTPEnabled || got.TOTPSecret != "sealed" || got.TOTPDataKey != "data" || got.TOTPLastStep != 7 {
+		t.Errorf("two-factor state was changed to %+v", got)
+	}
+	if got.Role != model.RoleAdmin || got.DisabledAt == nil || got.EmailVerifiedAt == nil {
+		t.Errorf("role %q, disabled %v, verified %v after a profile update", got.Role, got.DisabledAt, got.EmailVerifiedAt)
+	}
+	if got.Password != "hash" || got.CreatedAt.IsZero() {
+		t.Errorf("password or creation time was changed by an update without a password")
+	}
+
+	repo.UpdateUser(model.User{ID: user.ID, Name: "Alice", Email: "alice@example.com", Password: "secret"})
+	if got := repo.FindByID(user.ID); got.Password == "hash" || !got.TOTPEnabled {
+		t.Errorf("password change lost the two-factor state or kept the old hash")
+	}
+}
+
+func TestUpdateUserUnverifiesOnlyAChangedEmail(t *testing.T) {
+	db := newTestDB(t)
+	repo := NewUserRepository(db)
+	user := insertTestUser(t, db, "alice@example.com")
+	repo.MarkEmailVerified(user.ID)
+
+	if got := repo.UpdateUser(model.User{ID: user.ID, Name: "Alice", Email: "alice@example.com"}); got.EmailVerifiedAt == nil {
+		t.Error("keeping the email unverified it")
+	}
+	if got := repo.UpdateUser(model.User{ID: user.ID, Name: "Alice", Email: "alice@example.org"}); got.EmailVerifiedAt != nil || got.Email != "alice@example.org" {
+		t.Errorf("changing the email left %s verified at %v", got.Email, got.EmailVerifiedAt)
+	}
+}
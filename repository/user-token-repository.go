@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/myomyintko/strategy_robot/model"
+	"gorm.io/gorm"
+)
+
+//UserTokenRepository is contract what userTokenRepository can do to db
+type UserTokenRepository interface {
+	ReplaceUserToken(t model.UserToken) model.UserToken
+	ConsumeUserToken(purpose, hash string) model.UserToken
+}
+
+type userTokenConnection struct {
+	connection *gorm.DB
+}
+
+//NewUserTokenRepository is creates a new instance of UserTokenRepository
+func NewUserTokenRepository(db *gorm.DB) UserTokenRepository {
+	return &userTokenConnection{
+		connection: db,
+	}
+}
+
+//ReplaceUserToken stores token and drops the unused tokens it supersedes
+func (db *userTokenConnection) ReplaceUserToken(token model.UserToken) model.UserToken {
+	db.connection.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ? AND purpose = ? AND used_at IS NULL", token.UserID, token.Purpose).
+			Delete(&model.UserToken{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Save(&token).Error
+	})
+	return token
+}
+
+//ConsumeUserToken marks the token used and returns it, the zero value means it was unknown, used or expired
+func (db *userTokenConnection) ConsumeUserToken(purpose, hash string) model.UserToken {
+	var token model.UserToken
+	now := time.Now()
+	res := db.connection.Model(&model.UserToken{}).
+		Where("purpose = ? AND token_hash = ? AND used_at IS NULL AND expires_at > ?", purpose, hash, now).
+		Update("used_at", now)
+	if res.RowsAffected == 0 {
+		return token
+	}
+	db.connection.Where("token_hash = ?", hash).Find(&token)
+	return token
+}
@@ -0,0 +1,254 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/myomyintko/strategy_robot/config"
+	"github.com/myomyintko/strategy_robot/controller"
+	"github.com/myomyintko/strategy_robot/migration"
+	"github.com/myomyintko/strategy_robot/repository"
+	"github.com/myomyintko/strategy_robot/service"
+	"gorm.io/gorm"
+)
+
+// App is the application container, every dependency is built once by NewApp
+// and released by Shutdown
+type App struct {
+	conf config.Config
+	db   *gorm.DB
+
+	jwtService           service.JWTService
+	sessionService       service.SessionService
+	authService          service.AuthService
+	totpService          service.TOTPService
+	personalTokenService service.PersonalTokenService
+	apiService           service.APIService
+	binanceService       service.BinanceService
+	orderReconciler      service.OrderReconciler
+	portfolioService     service.PortfolioService
+	rebalancer           service.Rebalancer
+	orderBookService     service.OrderBookService
+	marketDataService    service.MarketDataService
+	rateLimitStore       service.RateLimitStore
+
+	userController          controller.UserController
+	authController          controller.AuthController
+	totpController          controller.TOTPController
+	sessionController       controller.SessionController
+	personalTokenController controller.PersonalTokenController
+	robotController         controller.RobotController
+	apiController           controller.APIController
+	binanceController       controller.BinanceController
+	adminController         controller.AdminController
+	portfolioController     controller.PortfolioController
+	marketController        controller.MarketController
+
+	// ctx is cancelled when shutdown starts, background workers and long lived
+	// handlers such as websocket streams watch it
+	ctx    context.Context
+	cancel context.CancelFunc
+	// drain carries the exchange calls workers already started, it is only
+	// cancelled when they outlive the shutdown deadline
+	drain   context.Context
+	abort   context.CancelFunc
+	workers sync.WaitGroup
+}
+
+//NewApp connects to the database, refuses an unmigrated schema and builds every
+//repository, service and controller from conf
+func NewApp(conf config.Config) (*App, error) {
+	masterKey, err := config.DecodeMasterKey(conf.Exchange.MasterKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load master key: %w", err)
+	}
+	db, err := config.SetupDatabaseConnection(conf.Database)
+	if err != nil {
+		return nil, err
+	}
+	if err := migration.Check(db); err != nil {
+		_ = config.CloseDatabaseConnection(db)
+		return nil, fmt.Errorf("%w, run the migrate up command", err)
+	}
+
+	app := &App{conf: conf, db: db}
+	app.ctx, app.cancel = context.WithCancel(context.Background())
+	app.drain, app.abort = context.WithCancel(context.Background())
+
+	userRepository := repository.NewUserRepository(db)
+	userTokenRepository := repository.NewUserTokenRepository(db)
+	sessionRepository := repository.NewSessionRepository(db)
+	recoveryCodeRepository := repository.NewRecoveryCodeRepository(db)
+	personalTokenRepository := repository.NewPersonalTokenRepository(db)
+	robotRepository := repository.NewRobotRepository(db)
+	apiRepository := repository.NewAPIRepository(db)
+	binanceRepository := repository.NewBinanceRepository(db)
+	portfolioRepository := repository.NewPortfolioRepository(db)
+
+	mailService := newMailService(conf.Mail)
+	secretService := service.NewSecretService(masterKey)
+	app.jwtService = service.NewJWTService(conf.JWT.Secret, conf.JWT.Issuer)
+	app.rateLimitStore = service.NewMemoryRateLimitStore()
+	app.sessionService = service.NewSessionService(sessionRepository)
+	loginGuardService := service.NewLoginGuardService(mailService, userRepository)
+	app.authService = service.NewAuthService(userRepository, userTokenRepository, app.sessionService, mailService, conf.Server.AppURL)
+	userService := service.NewUserService(userRepository, app.sessionService, app.authService)
+	app.totpService = service.NewTOTPService(userRepository, recoveryCodeRepository, secretService)
+	app.personalTokenService = service.NewPersonalTokenService(personalTokenRepository, userRepository)
+	robotService := service.NewRobotService(robotRepository)
+	binanceBudget := service.NewBinanceBudget(service.BudgetLimits{
+		Weight:        conf.Exchange.WeightLimit,
+		CancelReserve: conf.Exchange.CancelReserve,
+		Orders10s:     conf.Exchange.OrdersPer10s,
+		OrdersDay:     conf.Exchange.OrdersPerDay,
+		MaxWait:       conf.Exchange.MaxWait,
+	})
+	binanceCaller := service.NewBinanceCaller(service.RetryPolicy{
+		Attempts:  conf.Exchange.RetryAttempts,
+		BaseDelay: conf.Exchange.RetryBaseDelay,
+		MaxDelay:  conf.Exchange.RetryMaxDelay,
+	})
+	app.apiService = service.NewAPIService(apiRepository, secretService, binanceBudget, binanceCaller)
+	clientRegistry := service.NewClientRegistry(app.apiService)
+	app.binanceService = service.NewBinanceService(binanceRepository, service.RiskLimits{
+		MaxQuantity: conf.Risk.MaxOrderQuantity,
+		MaxNotional: conf.Risk.MaxOrderNotional,
+	}, binanceCaller)
+	app.orderReconciler = service.NewOrderReconciler(binanceRepository, robotRepository, clientRegistry, binanceCaller, conf.Exchange.ReconcileLookback)
+	app.orderBookService = service.NewOrderBookService(app.ctx, app.apiService.PublicClient(), binanceCaller, conf.Exchange.OrderBookIdle, conf.Exchange.MaxOrderBooks)
+	app.marketDataService = service.NewMarketDataService(conf.Exchange.TickerStaleAfter)
+	app.rebalancer = service.NewRebalancer(robotRepository, binanceRepository, app.binanceService, clientRegistry, binanceCaller, app.marketDataService)
+	app.portfolioService = service.NewPortfolioService(portfolioRepository, app.apiService, clientRegistry, binanceCaller, app.marketDataService)
+	adminService := service.NewAdminService(userRepository, app.sessionService)
+
+	app.userController = controller.NewUserController(userService)
+	app.authController = controller.NewAuthController(app.authService, app.jwtService, app.sessionService, app.totpService, loginGuardService)
+	app.totpController = controller.NewTOTPController(app.totpService)
+	app.sessionController = controller.NewSessionController(app.sessionService)
+	app.personalTokenController = controller.NewPersonalTokenController(app.personalTokenService)
+	app.robotController = controller.NewRobotController(robotService, app.rebalancer, clientRegistry)
+	app.apiController = controller.NewAPIController(app.apiService, clientRegistry)
+	app.binanceController = controller.NewBinanceController(app.ctx, app.binanceService, app.apiService, robotService, clientRegistry, binanceCaller, app.orderBookService)
+	app.adminController = controller.NewAdminController(adminService, app.authService, app.sessionService, app.jwtService)
+	app.portfolioController = controller.NewPortfolioController(app.portfolioService, clientRegistry)
+	app.marketController = controller.NewMarketController(app.orderBookService, app.marketDataService)
+	return app, nil
+}
+
+//Run serves HTTP until SIGINT or SIGTERM, then shuts down within server.shutdown_timeout
+func (app *App) Run() error {
+	server := &http.Server{
+		Addr:    app.conf.Server.Addr,
+		Handler: app.router(),
+	}
+	app.goWorker(func(ctx context.Context) {
+		app.apiService.WatchHealth(ctx, app.conf.Exchange.HealthInterval)
+	})
+	app.goWorker(func(ctx context.Context) {
+		app.orderReconciler.Watch(ctx, app.conf.Exchange.ReconcileInterval)
+	})
+	app.goWorker(func(ctx context.Context) {
+		app.portfolioService.Watch(ctx, app.conf.Exchange.SnapshotInterval)
+	})
+	app.goWorker(func(ctx context.Context) {
+		app.rebalancer.Watch(ctx, app.drain, app.conf.Exchange.RebalanceInterval)
+	})
+	app.goWorker(func(ctx context.Context) {
+		app.orderBookService.Watch(ctx, app.conf.Exchange.OrderBookIdle)
+	})
+	app.goWorker(func(ctx context.Context) {
+		app.marketDataService.Run(ctx)
+	})
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %s", server.Addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-serveErr:
+		app.cancel()
+		app.workers.Wait()
+		app.abort()
+		_ = config.CloseDatabaseConnection(app.db)
+		return err
+	case sig := <-signals:
+		log.Printf("Received %s, shutting down", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), app.conf.Server.ShutdownTimeout)
+	defer cancel()
+	err := app.Shutdown(ctx, server)
+	if serveErr := <-serveErr; !errors.Is(serveErr, http.ErrServerClosed) && err == nil {
+		err = serveErr
+	}
+	return err
+}
+
+//Shutdown stops accepting requests, cancels streams and stops background workers from
+//starting new work. In-flight requests and orders get until ctx expires to finish, then
+//the order journal is flushed and the database closed
+func (app *App) Shutdown(ctx context.Context, server *http.Server) error {
+	// websocket streams are cancelled first, Shutdown does not wait for hijacked
+	// connections and would otherwise wait for these handlers until the deadline
+	app.cancel()
+	err := server.Shutdown(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		app.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// cut off the calls still running, their orders end up unknown and are
+		// looked up by the reconciler after the restart
+		app.abort()
+		select {
+		case <-done:
+		case <-time.After(abortGrace):
+		}
+		if err == nil {
+			err = fmt.Errorf("background workers did not stop: %w", ctx.Err())
+		}
+	}
+	app.abort()
+	if n := app.binanceService.FlushJournal(); n > 0 {
+		log.Printf("Marked %d orders still being submitted as unknown", n)
+	}
+
+	if closeErr := config.CloseDatabaseConnection(app.db); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Shut down cleanly")
+	return nil
+}
+
+//abortGrace is how long workers get to return once their calls were cancelled
+const abortGrace = 2 * time.Second
+
+//goWorker runs fn until the app shuts down, Shutdown waits for it to return
+func (app *App) goWorker(fn func(ctx context.Context)) {
+	app.workers.Add(1)
+	go func() {
+		defer app.workers.Done()
+		fn(app.ctx)
+	}()
+}
@@ -0,0 +1,372 @@
+package route
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/myomyintko/strategy_robot/config"
+	"github.com/myomyintko/strategy_robot/controller"
+	"github.com/myomyintko/strategy_robot/helper"
+	"github.com/myomyintko/strategy_robot/migration"
+	"github.com/myomyintko/strategy_robot/model"
+	"github.com/myomyintko/strategy_robot/repository"
+	"github.com/myomyintko/strategy_robot/service"
+)
+
+//newTestApp is an app on a migrated SQLite file, it is shut down when t ends. flags
+//override the test settings.
+func newTestApp(t *testing.T, flags ...string) *App {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	conf, _, err := config.Load(append([]string{
+		"-database.driver=" + config.DriverSQLite,
+		"-database.path=" + filepath.Join(t.TempDir(), "test.db"),
+		"-jwt.secret=0123456789abcdef",
+		"-exchange.master_key=" + base64.StdEncoding.EncodeToString(make([]byte, 32)),
+		"-mail.driver=" + config.MailDriverLog,
+	}, flags...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := config.SetupDatabaseConnection(conf.Database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migration.Up(db); err != nil {
+		t.Fatal(err)
+	}
+	_ = config.CloseDatabaseConnection(db)
+
+	app, err := NewApp(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		app.cancel()
+		app.abort()
+		_ = config.CloseDatabaseConnection(app.db)
+	})
+	return app
+}
+
+//login creates a verified user of role and returns a bearer token of a fresh session of theirs
+func login(t *testing.T, app *App, email, role string) (model.User, string) {
+	t.Helper()
+	now := time.Now()
+	user := model.User{Name: email, Email: email, Password: "hash", Role: role, EmailVerifiedAt: &now}
+	if err := app.db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	session, _ := app.sessionService.Start(user.ID, "test", "127.0.0.1")
+	token, err := app.jwtService.GenerateToken(helper.Principal{
+		UserID:    user.ID,
+		SessionID: session.ID,
+		Roles:     []string{user.Role},
+		Scopes:    []string{helper.ScopeAll},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, token
+}
+
+func request(router http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestUsersCannotReachEachOthersRobotsAndKeys(t *testing.T) {
+	app := newTestApp(t)
+	router := app.router()
+	alice, aliceToken := login(t, app, "alice@example.com", model.RoleTrader)
+	bob, bobToken := login(t, app, "bob@example.com", model.RoleTrader)
+	robots := repository.NewRobotRepository(app.db)
+	keys := repository.NewAPIRepository(app.db)
+	robots.InsertRobot(model.Robot{Kind: model.RobotKindSymbol, Symbol: "ETHUSDT", UserID: alice.ID})
+	bobRobot := robots.InsertRobot(model.Robot{Kind: model.RobotKindSymbol, Symbol: "BTCUSDT", UserID: bob.ID})
+	keys.InsertAPI(model.BinanceAPI{APIKey: "alice-key", SecretKey: "sealed", DataKey: "data", UserID: alice.ID})
+	bobKey := keys.InsertAPI(model.BinanceAPI{APIKey: "bob-key", SecretKey: "sealed", DataKey: "data", UserID: bob.ID})
+
+	denied := []struct {
+		method, path, body string
+	}{
+		{http.MethodPut, fmt.Sprintf("/api/v1/robots/%d", bobRobot.ID), `{"symbol":"DOGEUSDT"}`},
+		{http.MethodDelete, fmt.Sprintf("/api/v1/robots/%d", bobRobot.ID), ""},
+		{http.MethodGet, fmt.Sprintf("/api/v1/robots/%d/rebalance", bobRobot.ID), ""},
+		{http.MethodDelete, fmt.Sprintf("/api/v1/binance/unbind/%d", bobKey.ID), ""},
+	}
+	for _, d := range denied {
+		rec := request(router, d.method, d.path, aliceToken, d.body)
+		if rec.Code != http.StatusForbidden && rec.Code != http.StatusNotFound {
+			t.Errorf("%s %s by alice = %d %s", d.method, d.path, rec.Code, rec.Body)
+		}
+	}
+	if got := robots.FindRobotByID(bob.ID, bobRobot.ID); got.Symbol != "BTCUSDT" {
+		t.Errorf("robot of bob is %+v after alice tried to change it", got)
+	}
+	if got := keys.FindAPIByID(bob.ID, bobKey.ID); got.ID == 0 {
+		t.Error("alice unbound the key of bob")
+	}
+
+	// concurrent reads must each see only their own rows
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		for _, c := range []struct {
+			token, want, notWant string
+		}{
+			{aliceToken, "ETHUSDT", "BTCUSDT"},
+			{bobToken, "BTCUSDT", "ETHUSDT"},
+			{aliceToken, "alice-key", "bob-key"},
+			{bobToken, "bob-key", "alice-key"},
+		} {
+			wg.Add(1)
+			go func(token, want, notWant string) {
+				defer wg.Done()
+				path := "/api/v1/robots/"
+				if strings.HasSuffix(want, "-key") {
+					path = "/api/v1/binance/get-bind"
+				}
+				body := request(router, http.MethodGet, path, token, "").Body.String()
+				if !strings.Contains(body, want) || strings.Contains(body, notWant) {
+					t.Errorf("GET %s = %s, want %s and not %s", path, body, want, notWant)
+				}
+			}(c.token, c.want, c.notWant)
+		}
+	}
+	wg.Wait()
+
+	// starting a stream answers with the key it was bound to, which must be the caller's
+	exchange := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"listenKey":"listen-key"}`))
+	}))
+	defer exchange.Close()
+	client := binance.NewClient("bob-key", "secret")
+	client.BaseURL = exchange.URL
+	caller := service.NewBinanceCaller(service.RetryPolicy{Attempts: 1})
+	app.binanceController = controller.NewBinanceController(app.ctx, app.binanceService, app.apiService, nil, fixedRegistry{client}, caller, app.orderBookService)
+	body := request(app.router(), http.MethodPost, "/api/v1/binance/stream", bobToken, "").Body.String()
+	if !strings.Contains(body, "bob-key") || strings.Contains(body, "alice") {
+		t.Errorf("POST /binance/stream by bob = %s", body)
+	}
+}
+
+//fixedRegistry hands out one client to every user
+type fixedRegistry struct {
+	client *binance.Client
+}
+
+func (r fixedRegistry) ForUser(uint64) (*binance.Client, error) {
+	return r.client, nil
+}
+
+func (r fixedRegistry) Invalidate(uint64) {}
+
+func TestViewersCannotStartOrKeepUserStreams(t *testing.T) {
+	app := newTestApp(t)
+	router := app.router()
+	_, token := login(t, app, "viewer@example.com", model.RoleViewer)
+	for _, method := range []string{http.MethodPost, http.MethodPut} {
+		if rec := request(router, method, "/api/v1/binance/stream", token, `{"stream":"listen-key"}`); rec.Code != http.StatusForbidden {
+			t.Errorf("%s /binance/stream by a viewer = %d, want 403", method, rec.Code)
+		}
+	}
+}
+
+func TestUnverifiedUsersCannotBindKeysOrTrade(t *testing.T) {
+	app := newTestApp(t)
+	router := app.router()
+	user, token := login(t, app, "alice@example.com", model.RoleTrader)
+	app.db.Model(&user).Update("email_verified_at", nil)
+
+	gated := []struct {
+		method, path, body string
+	}{
+		{http.MethodPost, "/api/v1/binance/bind", `{"api":"key","secret":"secret"}`},
+		{http.MethodPost, "/api/v1/binance/orders", `{"price":"1","quantity":"1"}`},
+		{http.MethodPost, "/api/v1/robots/", `{"symbol":"BTCUSDT"}`},
+	}
+	for _, g := range gated {
+		rec := request(router, g.method, g.path, token, g.body)
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "Email not verified") {
+			t.Errorf("%s %s unverified = %d %s", g.method, g.path, rec.Code, rec.Body)
+		}
+	}
+
+	repository.NewUserRepository(app.db).MarkEmailVerified(user.ID)
+	for _, g := range gated {
+		if rec := request(router, g.method, g.path, token, g.body); strings.Contains(rec.Body.String(), "Email not verified") {
+			t.Errorf("%s %s verified = %d %s", g.method, g.path, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestLoginIsRefusedOnceTheFirstVerificationLinkExpired(t *testing.T) {
+	app := newTestApp(t)
+	router := app.router()
+	users := repository.NewUserRepository(app.db)
+	user := users.InsertUser(model.User{Name: "Alice", Email: "alice@example.com", Password: "secret password", Role: model.RoleTrader})
+	body := `{"email":"alice@example.com","password":"secret password"}`
+
+	if rec := request(router, http.MethodPost, "/api/v1/auth/login", "", body); rec.Code != http.StatusOK {
+		t.Fatalf("login right after registering = %d %s", rec.Code, rec.Body)
+	}
+
+	app.db.Model(&user).Update("created_at", time.Now().Add(-48*time.Hour))
+	rec := request(router, http.MethodPost, "/api/v1/auth/login", "", body)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("login two days after registering unverified = %d %s", rec.Code, rec.Body)
+	}
+	var links int64
+	app.db.Model(&model.UserToken{}).Where("user_id = ? AND purpose = ?", user.ID, model.TokenVerifyEmail).Count(&links)
+	if links != 1 {
+		t.Errorf("refused login left %d verification links, want a fresh one", links)
+	}
+}
+
+func TestForwardedAddressesAreIgnoredWithoutTrustedProxies(t *testing.T) {
+	app := newTestApp(t)
+	router := app.router()
+	for i := 0; i < 10; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(fmt.Sprintf(`{"email":"guess%d@example.com","password":"wrong password"}`, i)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code == http.StatusTooManyRequests {
+			return
+		}
+	}
+	t.Fatal("failed logins from one address with a new X-Forwarded-For each time were never throttled")
+}
+
+func TestRateLimitsComeFromConfigAndApplyPerUser(t *testing.T) {
+	app := newTestApp(t, "-rate_limit.users=2")
+	router := app.router()
+	_, aliceToken := login(t, app, "alice@example.com", model.RoleTrader)
+	_, bobToken := login(t, app, "bob@example.com", model.RoleTrader)
+	for i := 0; i < 2; i++ {
+		if rec := request(router, http.MethodGet, "/api/v1/users/profile", aliceToken, ""); rec.Code != http.StatusOK {
+			t.Fatalf("request %d of alice = %d %s", i+1, rec.Code, rec.Body)
+		}
+	}
+	if rec := request(router, http.MethodGet, "/api/v1/users/profile", aliceToken, ""); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("third request of alice = %d, want 429", rec.Code)
+	}
+	if rec := request(router, http.MethodGet, "/api/v1/users/profile", bobToken, ""); rec.Code != http.StatusOK {
+		t.Errorf("first request of bob = %d, want 200", rec.Code)
+	}
+}
+
+func TestInvalidOrderIsAnsweredOnce(t *testing.T) {
+	app := newTestApp(t)
+	router := app.router()
+	_, token := login(t, app, "alice@example.com", model.RoleTrader)
+	rec := request(router, http.MethodPost, "/api/v1/binance/orders?type=buy", token, `{"price":"1"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("order without quantity = %d %s", rec.Code, rec.Body)
+	}
+	// a handler that carries on after the first response appends a second one
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("order without quantity answered %s: %v", rec.Body, err)
+	}
+}
+
+func TestExchangeCallsEndWithTheRequest(t *testing.T) {
+	app := newTestApp(t)
+	user, token := login(t, app, "alice@example.com", model.RoleTrader)
+	robots := repository.NewRobotRepository(app.db)
+	robot := robots.InsertRobot(model.Robot{Kind: model.RobotKindSymbol, Symbol: "BTCUSDT", UserID: user.ID})
+	release := make(chan struct{})
+	var calls int32
+	exchange := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer exchange.Close()
+	defer close(release)
+	client := binance.NewClient("key", "secret")
+	client.BaseURL = exchange.URL
+	caller := service.NewBinanceCaller(service.RetryPolicy{Attempts: 3, BaseDelay: time.Second, MaxDelay: time.Second})
+	app.binanceController = controller.NewBinanceController(app.ctx, app.binanceService, app.apiService, service.NewRobotService(robots), fixedRegistry{client}, caller, app.orderBookService)
+	router := app.router()
+
+	paths := []string{
+		"/api/v1/binance/account",
+		fmt.Sprintf("/api/v1/binance/openOrders?robot=%d", robot.ID),
+		fmt.Sprintf("/api/v1/binance/orders/1?robot=%d", robot.ID),
+	}
+	for _, path := range paths {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		req := httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx)
+		req.Header.Set("Authorization", "Bearer "+token)
+		start := time.Now()
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		cancel()
+		if took := time.Since(start); took > 2*time.Second {
+			t.Errorf("GET %s ran %s after its request ended", path, took)
+		}
+	}
+	if got := atomic.LoadInt32(&calls); int(got) != len(paths) {
+		t.Errorf("Binance was called %d times, want once per request", got)
+	}
+}
+
+func TestTickersListTheRequestedSymbolsFlaggingStaleOnes(t *testing.T) {
+	app := newTestApp(t)
+	_, token := login(t, app, "viewer@example.com", model.RoleViewer)
+	market := &fixedMarket{tickers: []model.Ticker{
+		{Symbol: "BTCUSDT", LastPrice: 100, Stale: true},
+		{Symbol: "ETHUSDT", LastPrice: 10},
+	}}
+	app.marketController = controller.NewMarketController(app.orderBookService, market)
+	router := app.router()
+
+	rec := request(router, http.MethodGet, "/api/v1/market/tickers?symbols=btcusdt,%20ETHUSDT,", token, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /market/tickers = %d %s", rec.Code, rec.Body.String())
+	}
+	if got := strings.Join(market.asked, ","); got != "btcusdt,ETHUSDT" {
+		t.Errorf("asked for symbols %q, want btcusdt,ETHUSDT", got)
+	}
+	var res struct {
+		Data []model.Ticker `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Data) != 2 || !res.Data[0].Stale || res.Data[1].Stale {
+		t.Errorf("tickers = %+v, want BTCUSDT stale and ETHUSDT fresh", res.Data)
+	}
+}
+
+//fixedMarket serves fixed tickers and records the symbols it was asked for
+type fixedMarket struct {
+	service.MarketDataService
+	tickers []model.Ticker
+	asked   []string
+}
+
+func (m *fixedMarket) Tickers(symbols ...string) []model.Ticker {
+	m.asked = symbols
+	return m.tickers
+}
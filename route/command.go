@@ -0,0 +1,84 @@
+package route
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/myomyintko/strategy_robot/config"
+	"github.com/myomyintko/strategy_robot/migration"
+	"github.com/myomyintko/strategy_robot/service"
+)
+
+//RotateMasterKey re-encrypts every bound exchange secret from exchange.master_key to NEW_MASTER_KEY
+func RotateMasterKey(conf config.Config) {
+	nextKey, err := config.LoadMasterKey("NEW_MASTER_KEY")
+	if err != nil {
+		log.Fatalf("Failed to load NEW_MASTER_KEY: %v", err)
+	}
+	app, err := NewApp(conf)
+	if err != nil {
+		log.Fatalf("Failed to start: %v", err)
+	}
+	defer config.CloseDatabaseConnection(app.db)
+	next := service.NewSecretService(nextKey)
+	rotated, err := app.apiService.RotateMasterKey(next)
+	if err != nil {
+		log.Fatalf("Failed to rotate, every key is still under MASTER_KEY: %v", err)
+	}
+	log.Printf("Rotated %d keys, set MASTER_KEY to the new key before restarting", rotated)
+}
+
+//Migrate runs "migrate up", "migrate down" or "migrate status" against the configured database
+func Migrate(conf config.Config, args []string) {
+	if len(args) != 1 {
+		log.Fatalf("usage: migrate up|down|status")
+	}
+	db, err := config.SetupDatabaseConnection(conf.Database)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer config.CloseDatabaseConnection(db)
+	switch args[0] {
+	case "up":
+		applied, err := migration.Up(db)
+		for _, m := range applied {
+			log.Printf("Applied %d %s", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("Failed to migrate: %v", err)
+		}
+		if len(applied) == 0 {
+			log.Printf("Schema is up to date")
+		}
+	case "down":
+		m, ok, err := migration.Down(db)
+		if err != nil {
+			log.Fatalf("Failed to revert: %v", err)
+		}
+		if !ok {
+			log.Printf("No migration to revert")
+			return
+		}
+		log.Printf("Reverted %d %s", m.Version, m.Name)
+	case "status":
+		statuses, err := migration.Statuses(db)
+		if err != nil {
+			log.Fatalf("Failed to read schema: %v", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		w.Flush()
+	default:
+		log.Fatalf("unknown migrate command %q, use up, down or status", args[0])
+	}
+}
@@ -1,97 +1,143 @@
 package route
 
 import (
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/myomyintko/strategy_robot/config"
-	"github.com/myomyintko/strategy_robot/controller"
 	"github.com/myomyintko/strategy_robot/middleware"
-	"github.com/myomyintko/strategy_robot/repository"
+	"github.com/myomyintko/strategy_robot/model"
 	"github.com/myomyintko/strategy_robot/service"
-	"gorm.io/gorm"
 )
 
-var (
-	db *gorm.DB = config.SetupDatabaseConnection()
-	// user and auth
-	userRepository repository.UserRepository = repository.NewUserRepository(db)
-	userService    service.UserService       = service.NewUserService(userRepository)
-	userController controller.UserController = controller.NewUserController(userService, jwtService)
-	authService    service.AuthService       = service.NewAuthService(userRepository)
-	authController controller.AuthController = controller.NewAuthController(authService, jwtService)
-
-	// robot
-	robotRepository repository.RobotRepository = repository.NewRobotRepository(db)
-	robotService    service.RobotService       = service.NewRobotService(robotRepository)
-	robotController controller.RobotController = controller.NewRobotController(robotService, jwtService)
-
-	// bind api
-	apiRepository repository.APIRepository = repository.NewAPIRepository(db)
-	apiService    service.APIService       = service.NewAPIService(apiRepository)
-	apiController controller.APIController = controller.NewAPIController(apiService, jwtService)
-	//binance
-	binanceRepository repository.BinanceRepository = repository.NewBinanceRepository(db)
-	binanceService    service.BinanceService       = service.NewBinanceService(binanceRepository)
-	binanceController controller.BinanceController = controller.NewBinanceController(binanceService, jwtService)
-	// jwt
-	jwtService service.JWTService = service.NewJWTService()
-)
+//InitRoute builds the app from conf and serves it until the process is signalled to stop
+func InitRoute(conf config.Config) {
+	app, err := NewApp(conf)
+	if err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+	if err := app.Run(); err != nil {
+		log.Fatalf("Server stopped: %v", err)
+	}
+}
 
-func InitRoute() {
-	defer config.CloseDatabaseConnection(db)
-	r := gin.Default()
-	r.Use(Cors())
+func (app *App) router() *gin.Engine {
+	r := gin.New()
+	// ClientIP keys login throttling and rate limits, so forwarded addresses are
+	// only believed from the configured proxies. Validate already checked them.
+	if err := r.SetTrustedProxies(app.conf.Server.Proxies()); err != nil {
+		log.Printf("Ignoring server.trusted_proxies: %v", err)
+	}
+	// RenderErrors also recovers panics, so it replaces gin.Recovery
+	r.Use(gin.Logger(), middleware.RenderErrors(), Cors())
 	//r.GET("ws",controller.TestKline)
 
 	apiV1Routes := r.Group("/api/v1")
+	authorize := middleware.AuthorizeJWT(app.jwtService, app.sessionService, app.personalTokenService)
+	can := middleware.RequirePermission
+	totp := middleware.RequireTOTP(app.totpService)
+	verified := middleware.RequireVerifiedEmail(app.authService)
+	limits := app.conf.RateLimit
+	limit := func(name string, perMinute int) gin.HandlerFunc {
+		return middleware.RateLimit(app.rateLimitStore, name, service.PerMinute(perMinute))
+	}
 
-	authRoutes := apiV1Routes.Group("auth")
+	authRoutes := apiV1Routes.Group("auth", limit("auth", limits.Auth))
 	{
-		authRoutes.POST("/login", authController.Login)
-		authRoutes.POST("/register", authController.Register)
+		authRoutes.POST("/login", app.authController.Login)
+		authRoutes.POST("/register", app.authController.Register)
+		authRoutes.POST("/refresh", app.authController.Refresh)
+		authRoutes.POST("/logout", authorize, app.authController.Logout)
+		authRoutes.POST("/verify-email", app.authController.VerifyEmail)
+		authRoutes.POST("/forgot-password", app.authController.ForgotPassword)
+		authRoutes.POST("/reset-password", app.authController.ResetPassword)
 	}
 
-	userRoutes := apiV1Routes.Group("users", middleware.AuthorizeJWT(jwtService))
+	userRoutes := apiV1Routes.Group("users", authorize, limit("users", limits.Users))
 	{
-		userRoutes.GET("/profile", userController.Profile)
-		userRoutes.PUT("/profile", userController.Update)
+		userRoutes.GET("/profile", app.userController.Profile)
+		userRoutes.PUT("/profile", can(model.PermProfileWrite), app.userController.Update)
+		userRoutes.POST("/verify-email", can(model.PermProfileWrite), app.authController.ResendVerification)
+		userRoutes.POST("/totp", can(model.PermProfileWrite), app.totpController.Enroll)
+		userRoutes.POST("/totp/activate", can(model.PermProfileWrite), app.totpController.Activate)
+		userRoutes.DELETE("/totp", can(model.PermProfileWrite), app.totpController.Disable)
+		userRoutes.GET("/tokens", app.personalTokenController.All)
+		userRoutes.POST("/tokens", can(model.PermProfileWrite), app.personalTokenController.Insert)
+		userRoutes.DELETE("/tokens/:id", can(model.PermProfileWrite), app.personalTokenController.Delete)
+		userRoutes.GET("/sessions", app.sessionController.All)
+		userRoutes.DELETE("/sessions", can(model.PermProfileWrite), app.sessionController.RevokeAll)
+		userRoutes.DELETE("/sessions/:id", can(model.PermProfileWrite), app.sessionController.Revoke)
 	}
 
-	robotRoutes := apiV1Routes.Group("robots", middleware.AuthorizeJWT(jwtService))
+	robotRoutes := apiV1Routes.Group("robots", authorize, limit("robots", limits.Robots))
 	{
-		robotRoutes.GET("/", robotController.FindByUserID)
-		robotRoutes.POST("/", robotController.Insert)
-		robotRoutes.PUT("/:id", robotController.Update)
-		robotRoutes.DELETE("/:id", robotController.Delete)
+		robotRoutes.GET("/", can(model.PermRobotsRead), app.robotController.FindByUserID)
+		robotRoutes.POST("/", can(model.PermRobotsWrite), verified, totp, app.robotController.Insert)
+		robotRoutes.PUT("/:id", can(model.PermRobotsWrite), verified, app.robotController.Update)
+		robotRoutes.DELETE("/:id", can(model.PermRobotsWrite), app.robotController.Delete)
+		robotRoutes.GET("/:id/rebalance", can(model.PermRobotsRead), app.robotController.Rebalance)
 	}
 
-	binanceRoutes := apiV1Routes.Group("binance", middleware.AuthorizeJWT(jwtService))
+	binanceRoutes := apiV1Routes.Group("binance", authorize, limit("binance", limits.Binance))
 	{
-		binanceRoutes.GET("/get-bind", apiController.All)
-		binanceRoutes.POST("/bind", apiController.Insert)
-		binanceRoutes.PUT("/update-bind/:id", apiController.Update)
-		binanceRoutes.DELETE("/unbind/:id", apiController.Delete)
+		binanceRoutes.GET("/get-bind", can(model.PermKeysRead), app.apiController.FindByUserID)
+		binanceRoutes.POST("/bind", can(model.PermKeysWrite), verified, totp, app.apiController.Insert)
+		binanceRoutes.PUT("/update-bind/:id", can(model.PermKeysWrite), verified, totp, app.apiController.Update)
+		binanceRoutes.DELETE("/unbind/:id", can(model.PermKeysWrite), app.apiController.Delete)
 		// get symbol
-		binanceRoutes.GET("/", binanceController.GetSymbolInfo)
+		binanceRoutes.GET("/", can(model.PermAccountRead), app.binanceController.GetSymbolInfo)
 		//get coin
-		binanceRoutes.GET("/getCoin", binanceController.GetCrypto)
+		binanceRoutes.GET("/getCoin", can(model.PermAccountRead), app.binanceController.GetCrypto)
 		// order
-		binanceRoutes.POST("/orders", binanceController.CreateOrder)
-		binanceRoutes.GET("/orders/:id", binanceController.GetOrder)
-		binanceRoutes.GET("/orders", binanceController.ListOrders)
-		binanceRoutes.DELETE("/orders/:id", binanceController.CancelOrder)
-		binanceRoutes.GET("/openOrders", binanceController.ListOpenOrders)
-		binanceRoutes.GET("/wsOrders", binanceController.WsListOrdes)
+		binanceRoutes.POST("/orders", can(model.PermOrdersWrite), verified, app.binanceController.CreateOrder)
+		binanceRoutes.GET("/orders/:id", can(model.PermOrdersRead), app.binanceController.GetOrder)
+		binanceRoutes.GET("/orders", can(model.PermOrdersRead), app.binanceController.ListOrders)
+		binanceRoutes.GET("/orders/discrepancies", can(model.PermOrdersRead), app.binanceController.ListDiscrepancies)
+		binanceRoutes.DELETE("/orders/:id", can(model.PermOrdersWrite), app.binanceController.CancelOrder)
+		binanceRoutes.GET("/openOrders", can(model.PermOrdersRead), app.binanceController.ListOpenOrders)
+		binanceRoutes.GET("/wsOrders", can(model.PermOrdersRead), app.binanceController.WsListOrdes)
 		// kline
-		binanceRoutes.GET("/wsKline", binanceController.WsListKline)
+		binanceRoutes.GET("/wsKline", can(model.PermAccountRead), app.binanceController.WsListKline)
 		// account
-		binanceRoutes.GET("/account", binanceController.GetAccount)
-		// stream
-		binanceRoutes.POST("/stream", binanceController.StartUserStream)
-		binanceRoutes.PUT("/stream", binanceController.KeepAliveUserStream)
+		binanceRoutes.GET("/account", can(model.PermAccountRead), app.binanceController.GetAccount)
+		// stream, starting one stores its listen key on the bound key
+		binanceRoutes.POST("/stream", can(model.PermKeysWrite), app.binanceController.StartUserStream)
+		binanceRoutes.PUT("/stream", can(model.PermKeysWrite), app.binanceController.KeepAliveUserStream)
+	}
+
+	portfolioRoutes := apiV1Routes.Group("portfolio", authorize, can(model.PermAccountRead), limit("portfolio", limits.Portfolio))
+	{
+		portfolioRoutes.GET("", app.portfolioController.Portfolio)
+		portfolioRoutes.GET("/history", app.portfolioController.History)
+	}
+
+	marketRoutes := apiV1Routes.Group("market", authorize, can(model.PermAccountRead), limit("market", limits.Market))
+	{
+		marketRoutes.GET("/depth", app.marketController.Depth)
+		marketRoutes.GET("/tickers", app.marketController.Tickers)
+	}
+
+	adminRoutes := apiV1Routes.Group("admin", authorize, can(model.PermUsersAdmin), limit("admin", limits.Admin))
+	{
+		adminRoutes.GET("/users", app.adminController.Users)
+		adminRoutes.PUT("/users/:id/role", app.adminController.SetRole)
+		adminRoutes.POST("/users/:id/disable", app.adminController.Disable)
+		adminRoutes.POST("/users/:id/enable", app.adminController.Enable)
+		adminRoutes.POST("/users/:id/reset-password", app.adminController.ResetPassword)
+		adminRoutes.POST("/users/:id/impersonate", app.adminController.Impersonate)
+		adminRoutes.GET("/keys", app.apiController.All)
+		adminRoutes.GET("/robots", app.robotController.All)
+	}
+	return r
+}
+
+//newMailService relays through SMTP unless the log driver was chosen for local development
+func newMailService(conf config.MailConfig) service.MailService {
+	if conf.Driver == config.MailDriverLog {
+		return service.NewMemoryMailService()
 	}
-	panic(r.Run(":5000"))
+	return service.NewSMTPMailService(conf.Host, conf.Port, conf.User, conf.Password, conf.From)
 }
 
 func Cors() gin.HandlerFunc {
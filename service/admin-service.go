@@ -0,0 +1,83 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/myomyintko/strategy_robot/helper"
+	"github.com/myomyintko/strategy_robot/model"
+	"github.com/myomyintko/strategy_robot/repository"
+)
+
+// ErrUserNotFound is returned when an admin action targets a missing user
+var ErrUserNotFound = helper.NotFoundError("User not found", nil)
+
+//AdminService is a contract of what an admin can do to other users
+type AdminService interface {
+	AllUser() []model.User
+	SetRole(userID uint64, role string) error
+	SetDisabled(userID uint64, disabled bool) error
+	ResetPassword(userID uint64) (string, error)
+}
+
+type adminService struct {
+	userRepository repository.UserRepository
+	sessionService SessionService
+}
+
+//NewAdminService creates a new instance of AdminService
+func NewAdminService(userRepo repository.UserRepository, sessionServ SessionService) AdminService {
+	return &adminService{
+		userRepository: userRepo,
+		sessionService: sessionServ,
+	}
+}
+
+func (service *adminService) AllUser() []model.User {
+	return service.userRepository.AllUser()
+}
+
+//SetRole changes the role and ends every session so the next token carries it
+func (service *adminService) SetRole(userID uint64, role string) error {
+	if !model.IsRole(role) {
+		return helper.ValidationError("Unknown role", errors.New(role))
+	}
+	if service.userRepository.FindByID(userID).ID == 0 {
+		return ErrUserNotFound
+	}
+	service.userRepository.UpdateRole(userID, role)
+	service.sessionService.RevokeAll(userID)
+	return nil
+}
+
+func (service *adminService) SetDisabled(userID uint64, disabled bool) error {
+	if service.userRepository.FindByID(userID).ID == 0 {
+		return ErrUserNotFound
+	}
+	var disabledAt *time.Time
+	if disabled {
+		now := time.Now()
+		disabledAt = &now
+		service.sessionService.RevokeAll(userID)
+	}
+	service.userRepository.UpdateDisabled(userID, disabledAt)
+	return nil
+}
+
+//ResetPassword sets a random temporary password, ends every session and returns the password
+func (service *adminService) ResetPassword(userID uint64) (string, error) {
+	if service.userRepository.FindByID(userID).ID == 0 {
+		return "", ErrUserNotFound
+	}
+	raw := make([]byte, 12)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return "", err
+	}
+	password := base64.RawURLEncoding.EncodeToString(raw)
+	service.userRepository.UpdatePassword(userID, password)
+	service.sessionService.RevokeAll(userID)
+	return password, nil
+}
@@ -1,29 +1,63 @@
 package service
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"time"
 
 	"github.com/mashingan/smapping"
 	"github.com/myomyintko/strategy_robot/dto"
+	"github.com/myomyintko/strategy_robot/helper"
 	"github.com/myomyintko/strategy_robot/model"
 	"github.com/myomyintko/strategy_robot/repository"
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	verifyEmailTTL   = 24 * time.Hour
+	resetPasswordTTL = time.Hour
+)
+
+// ErrInvalidUserToken is returned for unknown, used or expired mailed tokens
+var ErrInvalidUserToken = helper.ValidationError("Invalid or expired token", nil)
+
+// ErrEmailNotVerified is returned for actions that need a verified email address
+var ErrEmailNotVerified = helper.ForbiddenError("Email not verified", nil)
+
 type AuthService interface {
 	VerifyCredential(email string, password string) interface{}
-	CreateUser(user dto.RegisterDTO) model.User
+	CreateUser(user dto.RegisterDTO) (model.User, error)
 	FindByEmail(email string) model.User
+	FindByID(userID uint64) model.User
 	IsDuplicateEmail(email string) bool
+	SendVerification(user model.User) error
+	VerifyEmail(token string) error
+	RequireVerified(userID uint64) error
+	CheckLogin(user model.User) error
+	ForgotPassword(email string) error
+	ResetPassword(token string, password string) error
 }
 
 type authService struct {
-	userRepository repository.UserRepository
+	userRepository      repository.UserRepository
+	userTokenRepository repository.UserTokenRepository
+	sessionService      SessionService
+	mailService         MailService
+	appURL              string
 }
 
-func NewAuthService(userRep repository.UserRepository) AuthService {
+//NewAuthService creates a new instance of AuthService, appURL is the base of links in mails
+func NewAuthService(userRep repository.UserRepository, tokenRep repository.UserTokenRepository, sessionServ SessionService, mailServ MailService, appURL string) AuthService {
 	return &authService{
-		userRepository: userRep,
+		userRepository:      userRep,
+		userTokenRepository: tokenRep,
+		sessionService:      sessionServ,
+		mailService:         mailServ,
+		appURL:              appURL,
 	}
 }
 
@@ -32,6 +66,7 @@ func (service *authService) VerifyCredential(email string, password string) inte
 	if v, ok := res.(model.User); ok {
 		comparedPassword := comparePassword(v.Password, []byte(password))
 		if v.Email == email && comparedPassword {
+			service.rehash(v, password)
 			return res
 		}
 		return false
@@ -39,25 +74,118 @@ func (service *authService) VerifyCredential(email string, password string) inte
 	return false
 }
 
-func (service *authService) CreateUser(user dto.RegisterDTO) model.User {
+func (service *authService) CreateUser(user dto.RegisterDTO) (model.User, error) {
 	userToCreate := model.User{}
 	err := smapping.FillStruct(&userToCreate, smapping.MapFields(&user))
 	if err != nil {
-		log.Fatalf("Failed map %v", err)
+		return model.User{}, fmt.Errorf("failed map: %w", err)
 	}
+	userToCreate.Role = model.RoleTrader
 	res := service.userRepository.InsertUser(userToCreate)
-	return res
+	return res, nil
 }
 
 func (service *authService) FindByEmail(email string) model.User {
 	return service.userRepository.FindByEmail(email)
 }
 
+func (service *authService) FindByID(userID uint64) model.User {
+	return service.userRepository.FindByID(userID)
+}
+
 func (service *authService) IsDuplicateEmail(email string) bool {
 	res := service.userRepository.IsDuplicateEmail(email)
 	return !(res.Error == nil)
 }
 
+//SendVerification mails a link confirming the address of user
+func (service *authService) SendVerification(user model.User) error {
+	token := service.issueToken(user.ID, model.TokenVerifyEmail, verifyEmailTTL)
+	body := fmt.Sprintf("Hi %s,\n\nconfirm your email address by opening %s/verify-email?token=%s\n\nThe link expires in 24 hours.",
+		user.Name, service.appURL, token)
+	return service.mailService.Send(user.Email, "Confirm your email address", body)
+}
+
+func (service *authService) VerifyEmail(token string) error {
+	userToken := service.userTokenRepository.ConsumeUserToken(model.TokenVerifyEmail, hashToken(token))
+	if userToken.ID == 0 {
+		return ErrInvalidUserToken
+	}
+	service.userRepository.MarkEmailVerified(userToken.UserID)
+	return nil
+}
+
+//RequireVerified refuses users whose email address is not verified
+func (service *authService) RequireVerified(userID uint64) error {
+	if service.userRepository.FindByID(userID).EmailVerifiedAt == nil {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+//CheckLogin lets an unverified user log in while the link mailed on registration is valid,
+//later logins are refused and mailed a fresh link
+func (service *authService) CheckLogin(user model.User) error {
+	if user.EmailVerifiedAt != nil || time.Since(user.CreatedAt) < verifyEmailTTL {
+		return nil
+	}
+	if err := service.SendVerification(user); err != nil {
+		log.Printf("Failed to send verification mail to user %d: %v", user.ID, err)
+	}
+	return helper.ForbiddenError(ErrEmailNotVerified.Message, errors.New("A new verification link has been mailed"))
+}
+
+//ForgotPassword mails a reset link, unknown addresses are ignored so callers cannot probe for accounts
+func (service *authService) ForgotPassword(email string) error {
+	user := service.userRepository.FindByEmail(email)
+	if user.ID == 0 || user.DisabledAt != nil {
+		return nil
+	}
+	token := service.issueToken(user.ID, model.TokenResetPassword, resetPasswordTTL)
+	body := fmt.Sprintf("Hi %s,\n\nset a new password by opening %s/reset-password?token=%s\n\nThe link expires in one hour. If you did not ask for it you can ignore this mail.",
+		user.Name, service.appURL, token)
+	return service.mailService.Send(user.Email, "Reset your password", body)
+}
+
+//ResetPassword sets password and ends every session of the user
+func (service *authService) ResetPassword(token string, password string) error {
+	userToken := service.userTokenRepository.ConsumeUserToken(model.TokenResetPassword, hashToken(token))
+	if userToken.ID == 0 {
+		return ErrInvalidUserToken
+	}
+	service.userRepository.UpdatePassword(userToken.UserID, password)
+	service.sessionService.RevokeAll(userToken.UserID)
+	return nil
+}
+
+func (service *authService) issueToken(userID uint64, purpose string, ttl time.Duration) string {
+	token := randomToken()
+	service.userTokenRepository.ReplaceUserToken(model.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	return token
+}
+
+//rehash upgrades hashes made with a lower cost than repository.PasswordCost
+func (service *authService) rehash(user model.User, password string) {
+	cost, err := bcrypt.Cost([]byte(user.Password))
+	if err == nil && cost < repository.PasswordCost {
+		service.userRepository.UpdatePassword(user.ID, password)
+	}
+}
+
+//randomToken panics when the system random source fails, the error middleware renders it as a 500
+func randomToken() string {
+	raw := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		panic(fmt.Sprintf("failed to generate token: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
 func comparePassword(hashedPwd string, plainPassword []byte) bool {
 	byteHash := []byte(hashedPwd)
 	err := bcrypt.CompareHashAndPassword(byteHash, plainPassword)
@@ -1,63 +1,110 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
+	"time"
 
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
 	"github.com/mashingan/smapping"
 	"github.com/myomyintko/strategy_robot/dto"
+	"github.com/myomyintko/strategy_robot/helper"
 	"github.com/myomyintko/strategy_robot/model"
 	"github.com/myomyintko/strategy_robot/repository"
 )
 
+//ErrNoBoundKey is returned when the user has not bound a key yet
+var ErrNoBoundKey = helper.NotFoundError("No bound API key", nil)
+
+//ErrKeyRejected is wrapped by every error caused by the key itself rather than by us
+var ErrKeyRejected = helper.UnprocessableError("API key rejected", nil)
+
 type APIService interface {
-	Insert(b dto.APICreateDTO) model.BinanceAPI
-	Update(b dto.APIUpdateDTO) model.BinanceAPI
-	BindStream(b dto.BindStreamDTO) model.BinanceAPI
+	Insert(b dto.APICreateDTO) (model.BinanceAPI, error)
+	Update(b dto.APIUpdateDTO) (model.BinanceAPI, error)
+	BindStream(b dto.BindStreamDTO) (model.BinanceAPI, error)
 	Delete(b model.BinanceAPI)
 	All() []model.BinanceAPI
-	FindByID(apiID uint64) model.BinanceAPI
+	AllByUserID(userID uint64) []model.BinanceAPI
+	FindByID(userID, apiID uint64) model.BinanceAPI
 	FindByUserID(userID uint64) model.BinanceAPI
-	IsAllowedToEdit(userID , apiID uint64) bool
+	IsAllowedToEdit(userID, apiID uint64) bool
+	OpenClient(key model.BinanceAPI) (*binance.Client, error)
+	PublicClient() *binance.Client
+	RotateMasterKey(next SecretService) (int, error)
+	CheckHealth()
+	WatchHealth(ctx context.Context, interval time.Duration)
 }
 
 type apiService struct {
 	apiRepository repository.APIRepository
+	secretService SecretService
+	budget        BinanceBudget
+	caller        BinanceCaller
+	// httpClient is shared so every key reuses the same connection pool
+	httpClient *http.Client
 }
 
-func NewAPIService(apiRepo repository.APIRepository) APIService {
+func NewAPIService(apiRepo repository.APIRepository, secretServ SecretService, budget BinanceBudget, caller BinanceCaller) APIService {
 	return &apiService{
 		apiRepository: apiRepo,
+		secretService: secretServ,
+		budget:        budget,
+		caller:        caller,
+		httpClient:    &http.Client{Transport: budget.Transport(http.DefaultTransport)},
 	}
 }
 
-func (service *apiService) Insert(b dto.APICreateDTO) model.BinanceAPI {
+func (service *apiService) Insert(b dto.APICreateDTO) (model.BinanceAPI, error) {
 	api := model.BinanceAPI{}
 	err := smapping.FillStruct(&api, smapping.MapFields(&b))
 	if err != nil {
-		log.Fatalf("Failed map %v: ", err)
+		return model.BinanceAPI{}, fmt.Errorf("failed map: %w", err)
+	}
+	if err := service.inspect(&api, api.SecretKey); err != nil {
+		return model.BinanceAPI{}, err
+	}
+	if err := service.seal(&api, service.secretService); err != nil {
+		return model.BinanceAPI{}, err
 	}
 	res := service.apiRepository.InsertAPI(api)
-	return res
+	return res, nil
 }
 
-func (service *apiService) Update(b dto.APIUpdateDTO) model.BinanceAPI {
+func (service *apiService) Update(b dto.APIUpdateDTO) (model.BinanceAPI, error) {
 	key := model.BinanceAPI{}
 	err := smapping.FillStruct(&key, smapping.MapFields(&b))
 	if err != nil {
-		log.Fatalf("Failed map %v: ", err)
+		return model.BinanceAPI{}, fmt.Errorf("failed map: %w", err)
+	}
+	if err := service.inspect(&key, key.SecretKey); err != nil {
+		return model.BinanceAPI{}, err
+	}
+	if err := service.seal(&key, service.secretService); err != nil {
+		return model.BinanceAPI{}, err
 	}
 	res := service.apiRepository.UpdateAPI(key)
-	return res
+	if res.ID == 0 {
+		return model.BinanceAPI{}, helper.NotFoundError("Key not found", errors.New("Invalid user or no key"))
+	}
+	return res, nil
 }
 
-func (service *apiService) BindStream(b dto.BindStreamDTO) model.BinanceAPI{
+func (service *apiService) BindStream(b dto.BindStreamDTO) (model.BinanceAPI, error) {
 	key := model.BinanceAPI{}
 	err := smapping.FillStruct(&key, smapping.MapFields(&b))
 	if err != nil {
-		log.Fatalf("Failed map %v: ", err)
+		return model.BinanceAPI{}, fmt.Errorf("failed map: %w", err)
 	}
-	res := service.apiRepository.BindStream(key)
-	return res
+	res, err := service.apiRepository.BindStream(key)
+	if err != nil {
+		return model.BinanceAPI{}, helper.InternalError("Failed to store the stream", err)
+	}
+	return res, nil
 }
 
 func (service *apiService) Delete(b model.BinanceAPI) {
@@ -68,16 +115,166 @@ func (service *apiService) All() []model.BinanceAPI {
 	return service.apiRepository.AllAPI()
 }
 
-func (service *apiService) FindByID(apiID uint64) model.BinanceAPI {
-	return service.apiRepository.FindAPIByID(apiID)
+func (service *apiService) AllByUserID(userID uint64) []model.BinanceAPI {
+	return service.apiRepository.AllAPIByUserID(userID)
+}
+
+func (service *apiService) FindByID(userID, apiID uint64) model.BinanceAPI {
+	return service.apiRepository.FindAPIByID(userID, apiID)
 }
 
 func (service *apiService) FindByUserID(userID uint64) model.BinanceAPI {
 	return service.apiRepository.FindAPIByUserID(userID)
 }
 
-func (service *apiService) IsAllowedToEdit(userID , apiID uint64) bool {
-	b := service.apiRepository.FindAPIByID(apiID)
-	id := b.UserID
-	return userID == id
+func (service *apiService) IsAllowedToEdit(userID, apiID uint64) bool {
+	b := service.apiRepository.FindAPIByID(userID, apiID)
+	return b.ID != 0
+}
+
+//OpenClient is the only place a bound secret is decrypted, callers should go through a ClientRegistry
+func (service *apiService) OpenClient(key model.BinanceAPI) (*binance.Client, error) {
+	if key.ID == 0 {
+		return nil, ErrNoBoundKey
+	}
+	if key.DataKey == "" {
+		return nil, helper.InternalError("Bound secret is not encrypted", errors.New("run rotate-master-key"))
+	}
+	if err := usable(key); err != nil {
+		return nil, err
+	}
+	secret, err := service.secretService.Open(key.SecretKey, key.DataKey)
+	if err != nil {
+		return nil, err
+	}
+	return service.newClient(key.APIKey, secret), nil
+}
+
+//usable refuses keys able to withdraw and keys that failed their last health check
+func usable(key model.BinanceAPI) error {
+	if key.CanWithdraw {
+		return fmt.Errorf("%w: withdrawal permission must be disabled", ErrKeyRejected)
+	}
+	if key.HealthError != "" {
+		return fmt.Errorf("%w: failed its last health check, update the key: %s", ErrKeyRejected, key.HealthError)
+	}
+	return nil
+}
+
+//PublicClient has no key, it serves market data under the shared budget
+func (service *apiService) PublicClient() *binance.Client {
+	return service.newClient("", "")
+}
+
+//newClient routes every call of the client through the shared budget
+func (service *apiService) newClient(apiKey, secret string) *binance.Client {
+	client := binance.NewClient(apiKey, secret)
+	client.HTTPClient = service.httpClient
+	return client
+}
+
+//RotateMasterKey re-encrypts every bound secret under next, sealing legacy plaintext rows on the way.
+//It runs in one transaction so every row stays under the old key when any row fails
+func (service *apiService) RotateMasterKey(next SecretService) (int, error) {
+	return service.apiRepository.ResealAll(func(key *model.BinanceAPI) error {
+		if key.DataKey != "" {
+			plain, err := service.secretService.Open(key.SecretKey, key.DataKey)
+			if err != nil {
+				return fmt.Errorf("key %d: %w", key.ID, err)
+			}
+			key.SecretKey = plain
+		}
+		return service.seal(key, next)
+	})
+}
+
+//CheckHealth re-validates every bound key against Binance and records the outcome. A check
+//that failed without Binance judging the key, such as a timeout or throttling, leaves the
+//stored health as it was
+func (service *apiService) CheckHealth() {
+	for _, key := range service.apiRepository.AllAPI() {
+		secret, err := service.secretService.Open(key.SecretKey, key.DataKey)
+		if err == nil {
+			err = service.inspect(&key, secret)
+			if err != nil && !errors.Is(err, ErrKeyRejected) {
+				log.Printf("Key %d could not be checked, keeping its last health: %v", key.ID, err)
+				continue
+			}
+		}
+		if err != nil {
+			key.HealthError = err.Error()
+			key.CheckedAt = time.Now()
+			log.Printf("Key %d failed health check: %v", key.ID, err)
+		}
+		service.apiRepository.UpdateHealth(key)
+	}
+}
+
+//WatchHealth runs CheckHealth every interval until ctx is done
+func (service *apiService) WatchHealth(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			service.CheckHealth()
+		}
+	}
+}
+
+//inspect asks Binance what the key may do and refuses keys able to withdraw
+func (service *apiService) inspect(key *model.BinanceAPI, secret string) error {
+	client := service.newClient(key.APIKey, secret)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var account *binance.Account
+	err := service.caller.Call(ctx, client, true, func(client *binance.Client) (err error) {
+		account, err = client.NewGetAccountService().Do(ctx)
+		return err
+	})
+	if err != nil {
+		return keyError(err)
+	}
+	var permission *binance.APIKeyPermission
+	err = service.caller.Call(ctx, client, true, func(client *binance.Client) (err error) {
+		permission, err = client.NewGetAPIKeyPermission().Do(ctx)
+		return err
+	})
+	if err != nil {
+		return keyError(err)
+	}
+	key.CanTrade = account.CanTrade && permission.EnableSpotAndMarginTrading
+	key.CanWithdraw = permission.EnableWithdrawals
+	key.IPRestricted = permission.IPRestrict
+	key.HealthError = ""
+	key.CheckedAt = time.Now()
+	if key.CanWithdraw {
+		return fmt.Errorf("%w: withdrawal permission must be disabled", ErrKeyRejected)
+	}
+	return nil
+}
+
+//keyError is the error of a failed key check. Binance refusing the key is ErrKeyRejected,
+//a failure telling nothing about the key such as a timeout or throttling stays an exchange error
+func keyError(err error) error {
+	var apiErr *common.APIError
+	throttled := errors.As(err, &apiErr) && apiErr.Code == codeTooManyRequests
+	if isTransient(err) || throttled || errors.Is(err, ErrBinanceBudget) {
+		return ExchangeError("Failed to check API key", err)
+	}
+	return fmt.Errorf("%w: %v", ErrKeyRejected, err)
+}
+
+func (service *apiService) seal(key *model.BinanceAPI, secretServ SecretService) error {
+	sealed, dataKey, err := secretServ.Seal(key.SecretKey)
+	if err != nil {
+		return err
+	}
+	key.SecretMask = helper.MaskSecret(key.SecretKey)
+	key.SecretKey = sealed
+	key.DataKey = dataKey
+	return nil
 }
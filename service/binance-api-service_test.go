@@ -0,0 +1,68 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/myomyintko/strategy_robot/model"
+	"github.com/myomyintko/strategy_robot/repository"
+)
+
+//redirect sends every request to target whatever host it was addressed to
+type redirect struct {
+	target *url.URL
+}
+
+func (r redirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = r.target.Scheme
+	req.URL.Host = r.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestHealthIsOnlyRecordedWhenBinanceJudgedTheKey(t *testing.T) {
+	db := newTestDB(t)
+	user := model.User{Name: "alice", Email: "alice@example.com", Password: "hash", Role: model.RoleTrader}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	secrets := NewSecretService(make([]byte, 32))
+	sealed, dataKey, err := secrets.Seal("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := repository.NewAPIRepository(db)
+	key := keys.InsertAPI(model.BinanceAPI{APIKey: "key", SecretKey: sealed, DataKey: dataKey, CanTrade: true, UserID: user.ID})
+
+	status, body := http.StatusServiceUnavailable, ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+	service := NewAPIService(keys, secrets, NewBinanceBudget(BudgetLimits{Weight: 1200, Orders10s: 50, OrdersDay: 1000}), NewBinanceCaller(RetryPolicy{Attempts: 1})).(*apiService)
+	service.httpClient = &http.Client{Transport: redirect{target}}
+
+	for _, inconclusive := range []struct {
+		status int
+		body   string
+	}{
+		{http.StatusServiceUnavailable, ""},
+		{http.StatusTooManyRequests, `{"code":-1003,"msg":"Too many requests."}`},
+		{http.StatusBadRequest, `{"code":-1007,"msg":"Timeout waiting for response from backend server."}`},
+	} {
+		status, body = inconclusive.status, inconclusive.body
+		service.CheckHealth()
+		if got := keys.FindAPIByID(user.ID, key.ID); got.HealthError != "" || !got.CanTrade {
+			t.Errorf("answer %d %s recorded health %q, can trade %v", status, body, got.HealthError, got.CanTrade)
+		}
+	}
+
+	status, body = http.StatusUnauthorized, `{"code":-2015,"msg":"Invalid API-key, IP, or permissions for action."}`
+	service.CheckHealth()
+	if got := keys.FindAPIByID(user.ID, key.ID); got.HealthError == "" {
+		t.Error("key Binance rejected has no health error")
+	}
+}
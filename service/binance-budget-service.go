@@ -0,0 +1,313 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/myomyintko/strategy_robot/helper"
+)
+
+// ErrBinanceBudget is returned instead of calling Binance when the call would
+// exceed the request weight or order limits, or while Binance asked us to back off
+var ErrBinanceBudget = helper.RateLimitedError("Binance request budget exhausted", 0, nil)
+
+// BudgetLimits are the Binance limits the budget keeps under
+type BudgetLimits struct {
+	// Weight is the request weight allowed per minute for this IP
+	Weight int
+	// CancelReserve is the weight kept free for cancels, new orders and
+	// queries stop when less is left
+	CancelReserve int
+	// Orders10s and OrdersDay are the new orders allowed per account
+	Orders10s int
+	OrdersDay int
+	// MaxWait is how long a call may be queued for the next window before it
+	// is rejected
+	MaxWait time.Duration
+}
+
+// BudgetUsage is a snapshot of what the budget has seen
+type BudgetUsage struct {
+	UsedWeight   int       `json:"used_weight"`
+	Weight       int       `json:"weight"`
+	BackoffUntil time.Time `json:"backoff_until"`
+}
+
+// BinanceBudget is shared by every Binance client of the process since the
+// weight limit applies to our IP
+type BinanceBudget interface {
+	// Transport wraps next so every request is checked against the budget and
+	// every response updates it
+	Transport(next http.RoundTripper) http.RoundTripper
+	Usage() BudgetUsage
+}
+
+type orderWindow struct {
+	count10s int
+	start10s time.Time
+	countDay int
+	startDay time.Time
+}
+
+type binanceBudget struct {
+	mu           sync.Mutex
+	limits       BudgetLimits
+	usedWeight   int
+	weightStart  time.Time
+	backoffUntil time.Time
+	orders       map[string]*orderWindow
+}
+
+//NewBinanceBudget creates the budget enforcing limits
+func NewBinanceBudget(limits BudgetLimits) BinanceBudget {
+	return &binanceBudget{
+		limits: limits,
+		orders: make(map[string]*orderWindow),
+	}
+}
+
+func (budget *binanceBudget) Usage() BudgetUsage {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	budget.roll(time.Now())
+	return BudgetUsage{
+		UsedWeight:   budget.usedWeight,
+		Weight:       budget.limits.Weight,
+		BackoffUntil: budget.backoffUntil,
+	}
+}
+
+func (budget *binanceBudget) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &budgetTransport{budget: budget, next: next}
+}
+
+type budgetTransport struct {
+	budget *binanceBudget
+	next   http.RoundTripper
+}
+
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	kind := classify(req)
+	weight := weightOf(req)
+	apiKey := req.Header.Get("X-MBX-APIKEY")
+	for {
+		wait, err := t.budget.reserve(kind, weight, apiKey, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if wait == 0 {
+			break
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.budget.observe(res, apiKey, time.Now())
+	return res, nil
+}
+
+type requestKind int
+
+const (
+	requestQuery requestKind = iota
+	requestOrder
+	requestCancel
+)
+
+func classify(req *http.Request) requestKind {
+	path := req.URL.Path
+	isOrder := strings.HasSuffix(path, "/order") || strings.HasSuffix(path, "/order/oco") || strings.HasSuffix(path, "/openOrders")
+	switch {
+	case isOrder && req.Method == http.MethodDelete:
+		return requestCancel
+	case isOrder && req.Method == http.MethodPost:
+		return requestOrder
+	default:
+		return requestQuery
+	}
+}
+
+// endpointWeights is the request weight of the endpoints we call whose weight
+// does not depend on their parameters, anything missing counts as 1
+var endpointWeights = map[string]int{
+	"GET /api/v3/account":                  20,
+	"GET /api/v3/allOrders":                20,
+	"GET /api/v3/myTrades":                 20,
+	"GET /api/v3/exchangeInfo":             20,
+	"GET /api/v3/order":                    4,
+	"GET /api/v3/aggTrades":                2,
+	"GET /api/v3/klines":                   2,
+	"POST /api/v3/userDataStream":          2,
+	"PUT /api/v3/userDataStream":           2,
+	"GET /sapi/v1/capital/deposit/address": 10,
+}
+
+//weightOf is the request weight Binance will charge for req, reserved up front
+//so a burst of heavy calls cannot overrun the limit before a response reports it
+func weightOf(req *http.Request) int {
+	path := req.URL.Path
+	query := req.URL.Query()
+	hasSymbol := query.Get("symbol") != ""
+	switch {
+	case path == "/api/v3/depth":
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		switch {
+		case limit > 1000:
+			return 250
+		case limit > 500:
+			return 50
+		case limit > 100:
+			return 25
+		default:
+			return 5
+		}
+	case path == "/api/v3/openOrders" && req.Method == http.MethodGet:
+		if hasSymbol {
+			return 6
+		}
+		return 80
+	case path == "/api/v3/ticker/price":
+		if hasSymbol {
+			return 2
+		}
+		return 4
+	case path == "/api/v3/ticker/24hr":
+		if hasSymbol {
+			return 2
+		}
+		return 80
+	}
+	if weight, ok := endpointWeights[req.Method+" "+path]; ok {
+		return weight
+	}
+	return 1
+}
+
+//reserve books a request of weight, returning how long to wait for the next window or an error when the call must not be made
+func (budget *binanceBudget) reserve(kind requestKind, weight int, apiKey string, now time.Time) (time.Duration, error) {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	if now.Before(budget.backoffUntil) {
+		return 0, fmt.Errorf("%w: backing off until %s", ErrBinanceBudget, budget.backoffUntil.Format(time.RFC3339))
+	}
+	budget.roll(now)
+
+	limit := budget.limits.Weight
+	if kind != requestCancel {
+		limit -= budget.limits.CancelReserve
+	}
+	if budget.usedWeight+weight > limit {
+		return budget.waitOrFail(budget.weightStart.Add(time.Minute).Sub(now), "request weight")
+	}
+
+	if kind == requestOrder {
+		window := budget.orderWindow(apiKey, now)
+		if window.countDay >= budget.limits.OrdersDay {
+			return 0, fmt.Errorf("%w: daily order limit reached", ErrBinanceBudget)
+		}
+		if window.count10s >= budget.limits.Orders10s {
+			return budget.waitOrFail(window.start10s.Add(10*time.Second).Sub(now), "order rate")
+		}
+		window.count10s++
+		window.countDay++
+	}
+	// counted locally until the response reports the real figure
+	budget.usedWeight += weight
+	return 0, nil
+}
+
+func (budget *binanceBudget) waitOrFail(wait time.Duration, limit string) (time.Duration, error) {
+	if wait > budget.limits.MaxWait {
+		return 0, fmt.Errorf("%w: %s limit reached, retry in %s", ErrBinanceBudget, limit, wait.Round(time.Second))
+	}
+	if wait <= 0 {
+		wait = time.Millisecond
+	}
+	return wait, nil
+}
+
+//observe takes the usage Binance reports and backs off when it refused the call
+func (budget *binanceBudget) observe(res *http.Response, apiKey string, now time.Time) {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	budget.roll(now)
+
+	if used, ok := headerInt(res.Header, "X-Mbx-Used-Weight-1m", "X-Mbx-Used-Weight"); ok {
+		budget.usedWeight = used
+	}
+	if apiKey != "" {
+		window := budget.orderWindow(apiKey, now)
+		if count, ok := headerInt(res.Header, "X-Mbx-Order-Count-10s"); ok {
+			window.count10s = count
+		}
+		if count, ok := headerInt(res.Header, "X-Mbx-Order-Count-1d"); ok {
+			window.countDay = count
+		}
+	}
+
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusTeapot {
+		backoff := time.Minute
+		if res.StatusCode == http.StatusTeapot {
+			backoff = 2 * time.Minute
+		}
+		if seconds, ok := headerInt(res.Header, "Retry-After"); ok && seconds > 0 {
+			backoff = time.Duration(seconds) * time.Second
+		}
+		budget.backoffUntil = now.Add(backoff)
+		log.Printf("Binance answered %d, backing off until %s", res.StatusCode, budget.backoffUntil.Format(time.RFC3339))
+	}
+}
+
+//roll starts a new weight window every minute, Binance counts per calendar minute
+func (budget *binanceBudget) roll(now time.Time) {
+	start := now.Truncate(time.Minute)
+	if !start.Equal(budget.weightStart) {
+		budget.weightStart = start
+		budget.usedWeight = 0
+	}
+}
+
+func (budget *binanceBudget) orderWindow(apiKey string, now time.Time) *orderWindow {
+	window, ok := budget.orders[apiKey]
+	if !ok {
+		window = &orderWindow{}
+		budget.orders[apiKey] = window
+	}
+	if start := now.Truncate(10 * time.Second); !start.Equal(window.start10s) {
+		window.start10s = start
+		window.count10s = 0
+	}
+	if start := now.UTC().Truncate(24 * time.Hour); !start.Equal(window.startDay) {
+		window.startDay = start
+		window.countDay = 0
+	}
+	return window
+}
+
+func headerInt(header http.Header, names ...string) (int, bool) {
+	for _, name := range names {
+		if v := header.Get(name); v != "" {
+			if n, err := strconv.Atoi(v); err == nil {
+				return n, true
+			}
+		}
+	}
+	return 0, false
+}
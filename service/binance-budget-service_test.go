@@ -0,0 +1,49 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWeightOf(t *testing.T) {
+	tests := []struct {
+		method, target string
+		want           int
+	}{
+		{http.MethodGet, "/api/v3/depth?symbol=BTCUSDT", 5},
+		{http.MethodGet, "/api/v3/depth?symbol=BTCUSDT&limit=500", 25},
+		{http.MethodGet, "/api/v3/depth?symbol=BTCUSDT&limit=1000", 50},
+		{http.MethodGet, "/api/v3/depth?symbol=BTCUSDT&limit=5000", 250},
+		{http.MethodGet, "/api/v3/account", 20},
+		{http.MethodGet, "/api/v3/openOrders?symbol=BTCUSDT", 6},
+		{http.MethodGet, "/api/v3/openOrders", 80},
+		{http.MethodDelete, "/api/v3/openOrders?symbol=BTCUSDT", 1},
+		{http.MethodPost, "/api/v3/order", 1},
+		{http.MethodGet, "/api/v3/order?symbol=BTCUSDT", 4},
+		{http.MethodGet, "/api/v3/ping", 1},
+	}
+	for _, tt := range tests {
+		if got := weightOf(httptest.NewRequest(tt.method, tt.target, nil)); got != tt.want {
+			t.Errorf("weightOf(%s %s) = %d, want %d", tt.method, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestReserveCountsTheWeightOfTheCall(t *testing.T) {
+	budget := NewBinanceBudget(BudgetLimits{Weight: 100, CancelReserve: 10, Orders10s: 10, OrdersDay: 100}).(*binanceBudget)
+	now := time.Now()
+	if wait, err := budget.reserve(requestQuery, 50, "", now); wait != 0 || err != nil {
+		t.Fatalf("first depth of 1000 = %s, %v", wait, err)
+	}
+	if _, err := budget.reserve(requestQuery, 50, "", now); err == nil {
+		t.Fatal("second depth of 1000 was let into the cancel reserve")
+	}
+	if wait, err := budget.reserve(requestCancel, 1, "", now); wait != 0 || err != nil {
+		t.Fatalf("cancel = %s, %v", wait, err)
+	}
+	if got := budget.usedWeight; got != 51 {
+		t.Errorf("used weight %d, want 51", got)
+	}
+}
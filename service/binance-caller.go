@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+)
+
+//RetryPolicy bounds the retries of a failed Binance call, delays grow exponentially from
+//BaseDelay up to MaxDelay and each one is drawn at random below that bound
+type RetryPolicy struct {
+	// Attempts counts the first try, 1 disables retries
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+//BinanceCaller runs Binance client calls with the retry policy and keeps them on server time
+type BinanceCaller interface {
+	//Call runs call with client. Transient failures are retried when idempotent is set, a -1021
+	//timestamp rejection resyncs the clock and is retried either way since Binance executed nothing.
+	//call must use the client it is given, a copy of client on server time once an offset is known
+	Call(ctx context.Context, client *binance.Client, idempotent bool, call func(client *binance.Client) error) error
+}
+
+type binanceCaller struct {
+	policy RetryPolicy
+	// offset is the last measured local minus server time in milliseconds, shared by every client
+	offset int64
+	randMu sync.Mutex
+	rand   *rand.Rand
+}
+
+//NewBinanceCaller creates a new instance of BinanceCaller
+func NewBinanceCaller(policy RetryPolicy) BinanceCaller {
+	if policy.Attempts < 1 {
+		policy.Attempts = 1
+	}
+	return &binanceCaller{
+		policy: policy,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (caller *binanceCaller) Call(ctx context.Context, client *binance.Client, idempotent bool, call func(client *binance.Client) error) error {
+	var err error
+	for attempt := 0; attempt < caller.policy.Attempts; attempt++ {
+		if attempt > 0 {
+			delay := caller.backoff(attempt)
+			log.Printf("Binance call failed, retrying in %s: %v", delay.Round(time.Millisecond), err)
+			if !sleep(ctx, delay) {
+				return err
+			}
+		}
+		err = call(caller.onServerTime(client))
+		switch {
+		case err == nil:
+			return nil
+		case isClockSkew(err):
+			if syncErr := caller.sync(ctx, client); syncErr != nil {
+				log.Printf("Failed to sync with Binance server time: %v", syncErr)
+				return err
+			}
+		case !idempotent || !isTransient(err):
+			return err
+		}
+	}
+	return err
+}
+
+//onServerTime is client when it already uses the shared offset and a copy using it otherwise.
+//Clients are shared by concurrent calls, so they are never written after they were built
+func (caller *binanceCaller) onServerTime(client *binance.Client) *binance.Client {
+	offset := atomic.LoadInt64(&caller.offset)
+	if client.TimeOffset == offset {
+		return client
+	}
+	synced := *client
+	synced.TimeOffset = offset
+	return &synced
+}
+
+//sync measures the offset to Binance server time, the following calls use it
+func (caller *binanceCaller) sync(ctx context.Context, client *binance.Client) error {
+	serverTime, err := client.NewServerTimeService().Do(ctx)
+	if err != nil {
+		return err
+	}
+	offset := time.Now().UnixNano()/int64(time.Millisecond) - serverTime
+	atomic.StoreInt64(&caller.offset, offset)
+	log.Printf("Synced with Binance server time, local clock is off by %dms", offset)
+	return nil
+}
+
+//backoff draws the delay before retry number attempt with full jitter
+func (caller *binanceCaller) backoff(attempt int) time.Duration {
+	bound := caller.policy.MaxDelay
+	if shift := uint(attempt - 1); shift < 32 && caller.policy.BaseDelay<<shift < bound {
+		bound = caller.policy.BaseDelay << shift
+	}
+	if bound <= 0 {
+		return 0
+	}
+	caller.randMu.Lock()
+	defer caller.randMu.Unlock()
+	return time.Duration(caller.rand.Int63n(int64(bound))) + 1
+}
+
+//sleep waits for d, it returns false when ctx ends first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+)
+
+func TestClockSkewIsCorrectedWithoutWritingTheSharedClient(t *testing.T) {
+	// Binance runs five seconds behind the local clock
+	const skew = 5000
+	serverNow := func() int64 { return time.Now().UnixNano()/int64(time.Millisecond) - skew }
+	client := newTestExchange(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v3/time" {
+			fmt.Fprintf(w, `{"serverTime":%d}`, serverNow())
+			return
+		}
+		timestamp, _ := strconv.ParseInt(r.URL.Query().Get("timestamp"), 10, 64)
+		if d := timestamp - serverNow(); d > 1000 || d < -1000 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code":-1021,"msg":"Timestamp for this request is outside of the recvWindow."}`)
+			return
+		}
+		fmt.Fprint(w, `{"canTrade":true,"balances":[]}`)
+	})
+	caller := NewBinanceCaller(RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	// concurrent calls share the client, -race flags any write to it
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := caller.Call(context.Background(), client, true, func(client *binance.Client) error {
+				_, err := client.NewGetAccountService().Do(context.Background())
+				return err
+			})
+			if err != nil {
+				t.Errorf("call after a clock skew: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if client.TimeOffset != 0 {
+		t.Errorf("shared client was written, its offset is %d", client.TimeOffset)
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"sync"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/myomyintko/strategy_robot/model"
+)
+
+// ClientRegistry hands out one Binance client per bound key so the secret is
+// decrypted once rather than on every request
+type ClientRegistry interface {
+	// ForUser returns the client of the key userID has bound
+	ForUser(userID uint64) (*binance.Client, error)
+	// Invalidate drops the client of key apiID after it was updated or unbound
+	Invalidate(apiID uint64)
+}
+
+type registeredClient struct {
+	client *binance.Client
+	// fingerprint is the stored credential the client was built from, a
+	// change made elsewhere is noticed on the next lookup
+	fingerprint string
+}
+
+type clientRegistry struct {
+	mu         sync.RWMutex
+	clients    map[uint64]registeredClient
+	apiService APIService
+}
+
+//NewClientRegistry creates an empty ClientRegistry opening clients through apiServ
+func NewClientRegistry(apiServ APIService) ClientRegistry {
+	return &clientRegistry{
+		clients:    make(map[uint64]registeredClient),
+		apiService: apiServ,
+	}
+}
+
+func (registry *clientRegistry) ForUser(userID uint64) (*binance.Client, error) {
+	key := registry.apiService.FindByUserID(userID)
+	if key.ID == 0 {
+		return nil, ErrNoBoundKey
+	}
+	// checked on every lookup, a cached client outlives the health check that
+	// found the key able to withdraw or broken
+	if err := usable(key); err != nil {
+		registry.Invalidate(key.ID)
+		return nil, err
+	}
+	fingerprint := fingerprintOf(key)
+
+	registry.mu.RLock()
+	registered, ok := registry.clients[key.ID]
+	registry.mu.RUnlock()
+	if ok && registered.fingerprint == fingerprint {
+		return registered.client, nil
+	}
+
+	client, err := registry.apiService.OpenClient(key)
+	if err != nil {
+		registry.Invalidate(key.ID)
+		return nil, err
+	}
+	registry.mu.Lock()
+	registry.clients[key.ID] = registeredClient{client: client, fingerprint: fingerprint}
+	registry.mu.Unlock()
+	return client, nil
+}
+
+func (registry *clientRegistry) Invalidate(apiID uint64) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	delete(registry.clients, apiID)
+}
+
+func fingerprintOf(key model.BinanceAPI) string {
+	return key.APIKey + "|" + key.SecretKey + "|" + key.DataKey
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/myomyintko/strategy_robot/model"
+	"github.com/myomyintko/strategy_robot/repository"
+)
+
+func TestForUserRefusesKeysTheHealthCheckFlagged(t *testing.T) {
+	db := newTestDB(t)
+	user := model.User{Name: "alice", Email: "alice@example.com", Password: "hash", Role: model.RoleTrader}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	secrets := NewSecretService(make([]byte, 32))
+	sealed, dataKey, err := secrets.Seal("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := repository.NewAPIRepository(db)
+	key := keys.InsertAPI(model.BinanceAPI{APIKey: "key", SecretKey: sealed, DataKey: dataKey, CanTrade: true, UserID: user.ID})
+	apiService := NewAPIService(keys, secrets, NewBinanceBudget(BudgetLimits{Weight: 1200, Orders10s: 50, OrdersDay: 1000}), NewBinanceCaller(RetryPolicy{Attempts: 1}))
+	registry := NewClientRegistry(apiService)
+
+	if _, err := registry.ForUser(user.ID); err != nil {
+		t.Fatalf("healthy key: %v", err)
+	}
+	for _, flagged := range []model.BinanceAPI{
+		{ID: key.ID, UserID: user.ID, CanTrade: true, CanWithdraw: true},
+		{ID: key.ID, UserID: user.ID, CanTrade: true, HealthError: "API key rejected: invalid signature"},
+	} {
+		keys.UpdateHealth(flagged)
+		if _, err := registry.ForUser(user.ID); !errors.Is(err, ErrKeyRejected) {
+			t.Errorf("cached client handed out for %+v: %v", flagged, err)
+		}
+	}
+	keys.UpdateHealth(model.BinanceAPI{ID: key.ID, UserID: user.ID, CanTrade: true})
+	if _, err := registry.ForUser(user.ID); err != nil {
+		t.Errorf("key healthy again: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/myomyintko/strategy_robot/helper"
+)
+
+// Binance error codes the service acts on, see the Binance spot API error code list
+const (
+	codeDisconnected     = -1001
+	codeTooManyRequests  = -1003
+	codeTimeout          = -1007
+	codeTooManyOrders    = -1015
+	codeServiceShutdown  = -1016
+	codeInvalidTimestamp = -1021
+	codeInvalidMessage   = -1013
+	codeOrderRejected    = -2010
+	codeCancelRejected   = -2011
+	codeNoSuchOrder      = -2013
+	codeBalanceNotEnough = -2018
+	codeMarginNotEnough  = -2019
+)
+
+var (
+	//ErrInsufficientBalance is an order the account cannot pay for
+	ErrInsufficientBalance = helper.UnprocessableError("Insufficient balance", nil)
+	//ErrFilterFailure is an order breaking a symbol filter such as lot size or tick size
+	ErrFilterFailure = helper.UnprocessableError("Order violates symbol filters", nil)
+	//ErrUnknownOrder is an order Binance does not know, or no longer lets us cancel
+	ErrUnknownOrder = helper.NotFoundError("Unknown order", nil)
+	//ErrTimestamp is a request signed outside recvWindow, our clock is off from Binance
+	ErrTimestamp = helper.ExchangeError("Timestamp outside recvWindow", codeInvalidTimestamp, nil)
+	//ErrExchangeRateLimited is Binance refusing requests or orders over its own limits
+	ErrExchangeRateLimited = helper.RateLimitedError("Binance rate limit exceeded", 0, nil)
+)
+
+// ExchangeError types an error returned by a Binance client call. Known Binance codes map
+// to the domain errors above and keep the code, budget errors stay rate limited and anything
+// else is a failed call
+func ExchangeError(message string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, ErrBinanceBudget) {
+		return err
+	}
+	var apiErr *common.APIError
+	if !errors.As(err, &apiErr) {
+		return helper.ExchangeError(message, 0, err)
+	}
+	code := int(apiErr.Code)
+	domain := domainError(apiErr)
+	if domain == nil {
+		return helper.ExchangeError(message, code, err)
+	}
+	return &helper.Error{
+		Kind:    domain.Kind,
+		Message: message,
+		Err:     fmt.Errorf("%w: %s", domain, apiErr.Message),
+		Code:    code,
+	}
+}
+
+// domainError maps a Binance rejection to a domain error, nil when it has no meaning to us
+func domainError(apiErr *common.APIError) *helper.Error {
+	switch apiErr.Code {
+	case codeBalanceNotEnough, codeMarginNotEnough:
+		return ErrInsufficientBalance
+	case codeOrderRejected:
+		// -2010 covers every rejected new order, the message tells which rule it broke
+		switch {
+		case strings.Contains(strings.ToLower(apiErr.Message), "insufficient balance"):
+			return ErrInsufficientBalance
+		case strings.HasPrefix(apiErr.Message, "Filter failure"):
+			return ErrFilterFailure
+		}
+	case codeInvalidMessage:
+		if strings.HasPrefix(apiErr.Message, "Filter failure") {
+			return ErrFilterFailure
+		}
+	case codeNoSuchOrder:
+		return ErrUnknownOrder
+	case codeCancelRejected:
+		if strings.HasPrefix(apiErr.Message, "Unknown order") {
+			return ErrUnknownOrder
+		}
+	case codeInvalidTimestamp:
+		return ErrTimestamp
+	case codeTooManyRequests, codeTooManyOrders:
+		return ErrExchangeRateLimited
+	}
+	return nil
+}
+
+// isTransient reports a failure that may pass on a second try. The call may have been
+// executed, so only idempotent calls are retried on it
+func isTransient(err error) bool {
+	if errors.Is(err, ErrBinanceBudget) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr *common.APIError
+	if !errors.As(err, &apiErr) {
+		// the request never got an answer
+		return true
+	}
+	switch apiErr.Code {
+	case codeDisconnected, codeTimeout, codeServiceShutdown:
+		return true
+	case 0:
+		// a status of 400 or more with a body that is not a Binance error, a gateway failure
+		return true
+	}
+	return false
+}
+
+// isClockSkew reports a -1021 rejection, Binance refused it before executing anything
+func isClockSkew(err error) bool {
+	var apiErr *common.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == codeInvalidTimestamp
+}
+
+//isUnknownOrder reports Binance not knowing the order asked for
+func isUnknownOrder(err error) bool {
+	var apiErr *common.APIError
+	return errors.As(err, &apiErr) && domainError(apiErr) == ErrUnknownOrder
+}
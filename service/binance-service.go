@@ -1,34 +1,213 @@
 package service
 
 import (
-	"log"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
 
-	"github.com/mashingan/smapping"
+	"github.com/adshao/go-binance/v2"
 	"github.com/myomyintko/strategy_robot/dto"
+	"github.com/myomyintko/strategy_robot/helper"
 	"github.com/myomyintko/strategy_robot/model"
 	"github.com/myomyintko/strategy_robot/repository"
 )
 
+var (
+	//ErrIdempotencyKeyReused is a key sent again with a different order
+	ErrIdempotencyKeyReused = helper.ConflictError("Idempotency key was used for a different order", nil)
+	//ErrOrderInFlight is a key sent again while its first submission is still running
+	ErrOrderInFlight = helper.ConflictError("Order is being submitted, retry with the same idempotency key later", nil)
+	//ErrOrderUnknown is a submission Binance neither confirmed nor denied yet
+	ErrOrderUnknown = helper.ExchangeError("Order status unknown, retry with the same idempotency key", 0, nil)
+)
+
+//orderSettleWindow is how long a pending or unknown order is left to its first submission,
+//after it a retry may look the order up on Binance and submit it when Binance has none
+const orderSettleWindow = 30 * time.Second
+
+//discrepancyLimit caps how many discrepancies are listed at once
+const discrepancyLimit = 100
+
 type BinanceService interface {
-	Insert(b dto.CreateOrderDTO) model.Order
+	PlaceOrder(ctx context.Context, client *binance.Client, b dto.CreateOrderDTO) (model.Order, error)
+	CheckRisk(b dto.CreateOrderDTO) error
+	Discrepancies(userID uint64) []model.OrderDiscrepancy
+	// FlushJournal marks the orders still being submitted unknown, reconciliation looks
+	// them up once the process is back. It returns how many were marked.
+	FlushJournal() int
 }
 
 type binanceService struct {
 	binanceRepository repository.BinanceRepository
+	risk              RiskLimits
+	caller            BinanceCaller
+
+	mu sync.Mutex
+	// journal holds the IDs of the orders this process is submitting
+	journal map[uint64]struct{}
 }
 
-func NewBinanceService(binRepo repository.BinanceRepository) BinanceService {
+func NewBinanceService(binRepo repository.BinanceRepository, risk RiskLimits, caller BinanceCaller) BinanceService {
 	return &binanceService{
 		binanceRepository: binRepo,
+		risk:              risk,
+		caller:            caller,
+		journal:           make(map[uint64]struct{}),
+	}
+}
+
+//PlaceOrder submits a limit order at most once per idempotency key. The order is stored
+//before submission under a client order ID derived from the key, a repeated key returns the
+//stored order and an ambiguous submission is looked up on Binance by that ID
+func (service *binanceService) PlaceOrder(ctx context.Context, client *binance.Client, o dto.CreateOrderDTO) (model.Order, error) {
+	if err := service.CheckRisk(o); err != nil {
+		return model.Order{}, err
+	}
+	clientOrderID := ClientOrderID(o.UserID, o.IdempotencyKey)
+	order := service.binanceRepository.FindOrderByClientID(clientOrderID)
+	if order.ID == 0 {
+		order, err := service.binanceRepository.InsertOrder(model.Order{
+			ClientOrderId:  clientOrderID,
+			IdempotencyKey: o.IdempotencyKey,
+			Symbol:         o.Symbol,
+			Side:           o.Side,
+			Price:          o.Price,
+			Quantity:       o.Quantity,
+			Status:         model.OrderPending,
+			UserID:         o.UserID,
+			RobotID:        o.RobotID,
+			OrderedAt:      time.Now(),
+		})
+		if errors.Is(err, repository.ErrDuplicateClientOrderID) {
+			// another request with the same key stored it first
+			return model.Order{}, ErrOrderInFlight
+		}
+		if err != nil {
+			return model.Order{}, helper.InternalError("Failed to store order", err)
+		}
+		return service.submit(ctx, client, order)
+	}
+	if order.Symbol != o.Symbol || order.Side != o.Side || order.Price != o.Price || order.Quantity != o.Quantity {
+		return model.Order{}, ErrIdempotencyKeyReused
+	}
+	settled := time.Since(order.UpdatedAt) > orderSettleWindow
+	switch order.Status {
+	case model.OrderPending:
+		if !settled {
+			return model.Order{}, ErrOrderInFlight
+		}
+		return service.reconcile(ctx, client, order, true)
+	case model.OrderUnknown:
+		return service.reconcile(ctx, client, order, settled)
+	case model.OrderRejected:
+		// nothing was placed, the key may try again
+		return service.submit(ctx, client, order)
 	}
+	return order, nil
+}
+
+//submit sends a stored order, a transient failure leaves it unknown and looks it up instead of resending
+func (service *binanceService) submit(ctx context.Context, client *binance.Client, order model.Order) (model.Order, error) {
+	service.mu.Lock()
+	service.journal[order.ID] = struct{}{}
+	service.mu.Unlock()
+	defer func() {
+		service.mu.Lock()
+		delete(service.journal, order.ID)
+		service.mu.Unlock()
+	}()
+
+	var res *binance.CreateOrderResponse
+	err := service.caller.Call(ctx, client, false, func(client *binance.Client) (err error) {
+		res, err = client.NewCreateOrderService().Symbol(order.Symbol).
+			Side(binance.SideType(order.Side)).Type(binance.OrderTypeLimit).
+			TimeInForce(binance.TimeInForceTypeGTC).Quantity(order.Quantity).
+			Price(order.Price).NewClientOrderID(order.ClientOrderId).Do(ctx)
+		return err
+	})
+	switch {
+	case err == nil:
+		order.OrderId = res.OrderID
+		order.Status = string(res.Status)
+		order.Error = ""
+		return service.binanceRepository.UpdateOrder(order), nil
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		// the request may have left before ctx ended, so Binance may hold the order.
+		// ctx is gone too, the lookup is left to reconciliation
+		order.Status = model.OrderUnknown
+		order.Error = truncate(err.Error(), 255)
+		service.binanceRepository.UpdateOrder(order)
+		return model.Order{}, helper.ExchangeError(ErrOrderUnknown.Message, 0, err)
+	case isTransient(err):
+		order.Status = model.OrderUnknown
+		order.Error = truncate(err.Error(), 255)
+		order = service.binanceRepository.UpdateOrder(order)
+		return service.reconcile(ctx, client, order, false)
+	}
+	order.Status = model.OrderRejected
+	order.Error = truncate(err.Error(), 255)
+	service.binanceRepository.UpdateOrder(order)
+	return model.Order{}, ExchangeError("Failed to place order", err)
+}
+
+//reconcile looks a stored order up on Binance by its client order ID. When Binance has none
+//it is submitted if resubmit is set, otherwise it stays unknown
+func (service *binanceService) reconcile(ctx context.Context, client *binance.Client, order model.Order, resubmit bool) (model.Order, error) {
+	var res *binance.Order
+	err := service.caller.Call(ctx, client, true, func(client *binance.Client) (err error) {
+		res, err = client.NewGetOrderService().Symbol(order.Symbol).
+			OrigClientOrderID(order.ClientOrderId).Do(ctx)
+		return err
+	})
+	switch {
+	case err == nil:
+		order.OrderId = res.OrderID
+		order.Status = string(res.Status)
+		order.Error = ""
+		return service.binanceRepository.UpdateOrder(order), nil
+	case isUnknownOrder(err) && resubmit:
+		return service.submit(ctx, client, order)
+	}
+	if order.Status != model.OrderUnknown {
+		order.Status = model.OrderUnknown
+		service.binanceRepository.UpdateOrder(order)
+	}
+	return model.Order{}, fmt.Errorf("%w: %v", ErrOrderUnknown, err)
+}
+
+func (service *binanceService) FlushJournal() int {
+	service.mu.Lock()
+	ids := make([]uint64, 0, len(service.journal))
+	for id := range service.journal {
+		ids = append(ids, id)
+	}
+	service.mu.Unlock()
+	if len(ids) == 0 {
+		return 0
+	}
+	return service.binanceRepository.MarkOrdersUnknown(ids)
+}
+
+//Discrepancies lists the latest differences reconciliation found in the orders of userID
+func (service *binanceService) Discrepancies(userID uint64) []model.OrderDiscrepancy {
+	return service.binanceRepository.FindDiscrepancies(userID, discrepancyLimit)
+}
+
+//ClientOrderID derives the newClientOrderId of an order from its owner and idempotency key,
+//it fits the 36 characters Binance allows
+func ClientOrderID(userID uint64, idempotencyKey string) string {
+	sum := sha256.Sum256([]byte(strconv.FormatUint(userID, 10) + ":" + idempotencyKey))
+	return "sr-" + hex.EncodeToString(sum[:16])
 }
 
-func (service *binanceService) Insert(o dto.CreateOrderDTO) model.Order {
-	order := model.Order{}
-	err := smapping.FillStruct(&order, smapping.MapFields(&o))
-	if err != nil {
-		log.Fatalf("Failed map %v: ", err)
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
 	}
-	res := service.binanceRepository.InsertOrder(order)
-	return res
+	return s[:n]
 }
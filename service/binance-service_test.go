@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/myomyintko/strategy_robot/dto"
+	"github.com/myomyintko/strategy_robot/helper"
+	"github.com/myomyintko/strategy_robot/model"
+	"github.com/myomyintko/strategy_robot/repository"
+	"gorm.io/gorm"
+)
+
+//newTestOrderFixture is a binance service on a test database and an order request of a robot
+func newTestOrderFixture(t *testing.T) (*gorm.DB, *binanceService, dto.CreateOrderDTO) {
+	t.Helper()
+	db := newTestDB(t)
+	user := model.User{Name: "alice", Email: "alice@example.com", Password: "hash", Role: model.RoleTrader}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	robot := repository.NewRobotRepository(db).InsertRobot(model.Robot{Kind: model.RobotKindSymbol, Symbol: "BTCUSDT", UserID: user.ID})
+	service := NewBinanceService(repository.NewBinanceRepository(db), RiskLimits{}, NewBinanceCaller(RetryPolicy{Attempts: 1})).(*binanceService)
+	return db, service, dto.CreateOrderDTO{IdempotencyKey: "key", Symbol: "BTCUSDT", Side: "BUY", Price: "1", Quantity: "1", RobotID: robot.ID, UserID: user.ID}
+}
+
+//newTestExchange is a client of a fake Binance answering every request with handler
+func newTestExchange(t *testing.T, handler http.HandlerFunc) *binance.Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client := binance.NewClient("key", "secret")
+	client.BaseURL = server.URL
+	return client
+}
+
+func TestCancelledSubmissionIsUnknownNotRejected(t *testing.T) {
+	db, service, order := newTestOrderFixture(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	client := newTestExchange(t, func(w http.ResponseWriter, r *http.Request) {
+		// the request reached Binance, then the caller gave up waiting
+		cancel()
+		<-release
+	})
+	defer close(release)
+
+	if _, err := service.PlaceOrder(ctx, client, order); err == nil {
+		t.Fatal("cancelled submission succeeded")
+	}
+	var stored model.Order
+	db.Where("client_order_id = ?", ClientOrderID(order.UserID, order.IdempotencyKey)).Take(&stored)
+	if stored.Status != model.OrderUnknown {
+		t.Fatalf("cancelled submission stored as %q, want %q", stored.Status, model.OrderUnknown)
+	}
+}
+
+func TestFlushJournalMarksOrdersBeingSubmittedUnknown(t *testing.T) {
+	db, service, order := newTestOrderFixture(t)
+	arrived := make(chan struct{})
+	release := make(chan struct{})
+	client := newTestExchange(t, func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-release
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, _ = service.PlaceOrder(ctx, client, order)
+	}()
+	<-arrived
+
+	if n := service.FlushJournal(); n != 1 {
+		t.Errorf("flushed %d orders, want 1", n)
+	}
+	var stored model.Order
+	db.Where("client_order_id = ?", ClientOrderID(order.UserID, order.IdempotencyKey)).Take(&stored)
+	if stored.Status != model.OrderUnknown {
+		t.Errorf("order being submitted stored as %q after the flush, want %q", stored.Status, model.OrderUnknown)
+	}
+	close(release)
+	<-done
+	if n := service.FlushJournal(); n != 0 {
+		t.Errorf("flushed %d orders after the submission returned", n)
+	}
+}
+
+//racingRepository misses an order another request stores between the lookup and the insert,
+//or fails every insert with insertErr when it is set
+type racingRepository struct {
+	repository.BinanceRepository
+	insertErr error
+}
+
+func (r racingRepository) FindOrderByClientID(string) model.Order {
+	return model.Order{}
+}
+
+func (r racingRepository) InsertOrder(order model.Order) (model.Order, error) {
+	if r.insertErr != nil {
+		return model.Order{}, r.insertErr
+	}
+	return r.BinanceRepository.InsertOrder(order)
+}
+
+func TestOnlyATakenClientOrderIDIsAnOrderInFlight(t *testing.T) {
+	_, service, order := newTestOrderFixture(t)
+	if _, err := service.binanceRepository.InsertOrder(model.Order{
+		ClientOrderId: ClientOrderID(order.UserID, order.IdempotencyKey),
+		Symbol:        order.Symbol,
+		Status:        model.OrderPending,
+		UserID:        order.UserID,
+		RobotID:       order.RobotID,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	service.binanceRepository = racingRepository{BinanceRepository: service.binanceRepository}
+	if _, err := service.PlaceOrder(context.Background(), nil, order); err != ErrOrderInFlight {
+		t.Errorf("order stored by a racing request = %v, want ErrOrderInFlight", err)
+	}
+
+	service.binanceRepository = racingRepository{BinanceRepository: service.binanceRepository, insertErr: errors.New("disk I/O error")}
+	_, err := service.PlaceOrder(context.Background(), nil, order)
+	if got := helper.AsError(err); got.Kind != helper.KindInternal || err == ErrOrderInFlight {
+		t.Errorf("order the database failed to store = %v, want an internal error", err)
+	}
+}
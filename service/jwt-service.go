@@ -1,22 +1,31 @@
 package service
 
 import (
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"strconv"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/myomyintko/strategy_robot/helper"
 )
 
 //JWTService is a contract of what jwtService can do
 type JWTService interface {
-	GenerateToken(userID string) string
+	GenerateToken(principal helper.Principal) (string, error)
 	ValidateToken(token string) (*jwt.Token, error)
+	ParsePrincipal(token string) (helper.Principal, error)
 }
 
+// AccessTokenTTL is kept short because access tokens are only revoked through their session
+const AccessTokenTTL = 15 * time.Minute
+
 type jwtCustomClaim struct {
-	UserID string `json:"user_id"`
+	UserID    string   `json:"user_id"`
+	SessionID uint64   `json:"sid"`
+	Roles     []string `json:"roles,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+	Imp       uint64   `json:"imp,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -26,43 +35,59 @@ type jwtService struct {
 }
 
 //NewJWTService method is creates a new instance of JWTService
-func NewJWTService() JWTService {
+func NewJWTService(secretKey, issuer string) JWTService {
 	return &jwtService{
-		issuer:    "marco",
-		secretKey: getSecretKey(),
-	}
-}
-
-func getSecretKey() string {
-	secretKey := os.Getenv("JWT_SECRET")
-	if secretKey != "" {
-		secretKey = "marco"
+		issuer:    issuer,
+		secretKey: secretKey,
 	}
-	return secretKey
 }
 
-func (j *jwtService) GenerateToken(UserID string) string {
+func (j *jwtService) GenerateToken(principal helper.Principal) (string, error) {
 	claims := &jwtCustomClaim{
-		UserID,
+		principal.ID(),
+		principal.SessionID,
+		principal.Roles,
+		principal.Scopes,
+		principal.ImpersonatorID,
 		jwt.StandardClaims{
-			ExpiresAt: time.Now().AddDate(1, 0, 0).Unix(),
+			ExpiresAt: time.Now().Add(AccessTokenTTL).Unix(),
 			Issuer:    j.issuer,
 			IssuedAt:  time.Now().Unix(),
 		},
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	t, err := token.SignedString([]byte(j.secretKey))
+	return token.SignedString([]byte(j.secretKey))
+}
+
+func (j *jwtService) ValidateToken(token string) (*jwt.Token, error) {
+	return jwt.Parse(token, j.keyFunc)
+}
+
+func (j *jwtService) ParsePrincipal(token string) (helper.Principal, error) {
+	claims := &jwtCustomClaim{}
+	parsed, err := jwt.ParseWithClaims(token, claims, j.keyFunc)
 	if err != nil {
-		log.Fatal(err)
+		return helper.Principal{}, err
 	}
-	return t
+	if !parsed.Valid {
+		return helper.Principal{}, errors.New("token is not valid")
+	}
+	userID, err := strconv.ParseUint(claims.UserID, 10, 64)
+	if err != nil {
+		return helper.Principal{}, fmt.Errorf("invalid user_id claim: %v", err)
+	}
+	return helper.Principal{
+		UserID:         userID,
+		SessionID:      claims.SessionID,
+		Roles:          claims.Roles,
+		Scopes:         claims.Scopes,
+		ImpersonatorID: claims.Imp,
+	}, nil
 }
 
-func (j *jwtService) ValidateToken(token string) (*jwt.Token, error) {
-	return jwt.Parse(token, func(t_ *jwt.Token) (interface{}, error) {
-		if _, ok := t_.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method %v", t_.Header["alg"])
-		}
-		return []byte(j.secretKey), nil
-	})
+func (j *jwtService) keyFunc(t_ *jwt.Token) (interface{}, error) {
+	if _, ok := t_.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method %v", t_.Header["alg"])
+	}
+	return []byte(j.secretKey), nil
 }
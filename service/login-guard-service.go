@@ -0,0 +1,151 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/myomyintko/strategy_robot/repository"
+)
+
+const (
+	// failures allowed before attempts are delayed
+	loginFreeAttempts = 3
+	// failures on one account before it is locked
+	loginLockoutAttempts = 10
+	loginLockout         = 30 * time.Minute
+	loginMaxBackoff      = 15 * time.Minute
+	// failures older than this are forgotten
+	loginAttemptWindow = 24 * time.Hour
+	// stale accounts and IPs are swept once this many of either are tracked
+	loginTracked = 10000
+)
+
+// LoginGuardService throttles password guessing per account and per client IP
+type LoginGuardService interface {
+	// Allow returns how long the caller must wait before trying again, zero when the attempt may proceed
+	Allow(email, ip string) time.Duration
+	// Fail records a failed attempt and reports whether the account just got locked
+	Fail(email, ip string) bool
+	// Succeed forgets the failures of the account
+	Succeed(email string)
+}
+
+type loginAttempts struct {
+	failures int
+	last     time.Time
+	until    time.Time
+}
+
+type loginGuardService struct {
+	mu          sync.Mutex
+	accounts    map[string]*loginAttempts
+	ips         map[string]*loginAttempts
+	mailService MailService
+	userRepo    repository.UserRepository
+}
+
+//NewLoginGuardService keeps attempts in memory, lockouts of registered accounts are mailed to their owner through mailServ
+func NewLoginGuardService(mailServ MailService, userRepo repository.UserRepository) LoginGuardService {
+	return &loginGuardService{
+		accounts:    make(map[string]*loginAttempts),
+		ips:         make(map[string]*loginAttempts),
+		mailService: mailServ,
+		userRepo:    userRepo,
+	}
+}
+
+func (service *loginGuardService) Allow(email, ip string) time.Duration {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	now := time.Now()
+	wait := waitFor(service.accounts[normalizeEmail(email)], now)
+	if ipWait := waitFor(service.ips[ip], now); ipWait > wait {
+		wait = ipWait
+	}
+	return wait
+}
+
+func (service *loginGuardService) Fail(email, ip string) bool {
+	service.mu.Lock()
+	now := time.Now()
+	account := record(service.accounts, normalizeEmail(email), now)
+	record(service.ips, ip, now)
+	// guesses against made up addresses grow accounts as fast as rotating
+	// addresses grow ips, so both are swept
+	if len(service.accounts) > loginTracked {
+		forget(service.accounts, now)
+	}
+	if len(service.ips) > loginTracked {
+		forget(service.ips, now)
+	}
+	locked := account.failures == loginLockoutAttempts
+	if locked {
+		account.until = now.Add(loginLockout)
+	}
+	service.mu.Unlock()
+
+	if locked {
+		go service.notifyLockout(email, ip)
+	}
+	return locked
+}
+
+func (service *loginGuardService) Succeed(email string) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	delete(service.accounts, normalizeEmail(email))
+}
+
+//notifyLockout mails the owner of email, addresses nobody registered are not mailed
+//so the lockout cannot be used to send mail to strangers
+func (service *loginGuardService) notifyLockout(email, ip string) {
+	user := service.userRepo.FindByEmail(email)
+	if user.ID == 0 {
+		return
+	}
+	body := fmt.Sprintf("Your account was locked for %d minutes after %d failed login attempts, the last one from %s.\n\nIf this was not you, reset your password once the lock expires.",
+		int(loginLockout.Minutes()), loginLockoutAttempts, ip)
+	if err := service.mailService.Send(user.Email, "Your account was locked", body); err != nil {
+		log.Printf("Failed to send lockout notice: %v", err)
+	}
+}
+
+func record(attempts map[string]*loginAttempts, key string, now time.Time) *loginAttempts {
+	a, ok := attempts[key]
+	if !ok || now.Sub(a.last) > loginAttemptWindow {
+		a = &loginAttempts{}
+		attempts[key] = a
+	}
+	a.failures++
+	a.last = now
+	if a.failures > loginFreeAttempts {
+		backoff := time.Second << uint(a.failures-loginFreeAttempts-1)
+		if backoff > loginMaxBackoff || backoff <= 0 {
+			backoff = loginMaxBackoff
+		}
+		a.until = now.Add(backoff)
+	}
+	return a
+}
+
+func forget(attempts map[string]*loginAttempts, now time.Time) {
+	for key, a := range attempts {
+		if now.Sub(a.last) > loginAttemptWindow {
+			delete(attempts, key)
+		}
+	}
+}
+
+func waitFor(a *loginAttempts, now time.Time) time.Duration {
+	if a == nil || !now.Before(a.until) {
+		return 0
+	}
+	return a.until.Sub(now)
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
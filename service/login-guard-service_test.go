@@ -0,0 +1,43 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/myomyintko/strategy_robot/model"
+	"github.com/myomyintko/strategy_robot/repository"
+)
+
+func newTestLoginGuard(t *testing.T) (*loginGuardService, *MemoryMailService, repository.UserRepository) {
+	t.Helper()
+	mail := NewMemoryMailService()
+	users := repository.NewUserRepository(newTestDB(t))
+	return NewLoginGuardService(mail, users).(*loginGuardService), mail, users
+}
+
+func TestLockoutIsMailedOnlyToRegisteredAccounts(t *testing.T) {
+	guard, mail, users := newTestLoginGuard(t)
+	users.InsertUser(model.User{Name: "Alice", Email: "alice@example.com", Password: "secret password", Role: model.RoleTrader})
+
+	guard.notifyLockout("stranger@example.com", "192.0.2.1")
+	if sent := mail.Sent(); len(sent) != 0 {
+		t.Fatalf("lockout of an unregistered address mailed %+v", sent)
+	}
+	guard.notifyLockout("alice@example.com", "192.0.2.1")
+	if sent := mail.Sent(); len(sent) != 1 || sent[0].To != "alice@example.com" {
+		t.Fatalf("lockout of alice mailed %+v", sent)
+	}
+}
+
+func TestFailSweepsStaleAccounts(t *testing.T) {
+	guard, _, _ := newTestLoginGuard(t)
+	stale := time.Now().Add(-2 * loginAttemptWindow)
+	for i := 0; i < loginTracked; i++ {
+		guard.accounts[fmt.Sprintf("guess%d@example.com", i)] = &loginAttempts{failures: 1, last: stale}
+	}
+	guard.Fail("alice@example.com", "192.0.2.1")
+	if len(guard.accounts) != 1 || guard.accounts["alice@example.com"] == nil {
+		t.Fatalf("%d accounts tracked after the sweep, want only alice", len(guard.accounts))
+	}
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+)
+
+//MailService is a contract of what an outbound mailer can do
+type MailService interface {
+	Send(to, subject, body string) error
+}
+
+type smtpMailService struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+//NewSMTPMailService sends plain text mail through host:port, user may be empty for relays without auth
+func NewSMTPMailService(host string, port int, user, password, from string) MailService {
+	var auth smtp.Auth
+	if user != "" {
+		auth = smtp.PlainAuth("", user, password, host)
+	}
+	return &smtpMailService{
+		addr: net.JoinHostPort(host, fmt.Sprint(port)),
+		auth: auth,
+		from: from,
+	}
+}
+
+func (m *smtpMailService) Send(to, subject, body string) error {
+	msg := strings.Join([]string{
+		"From: " + m.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg))
+}
+
+// Mail is a message captured by the in-memory mailer
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// MemoryMailService keeps every message in memory, it is meant for tests and local development
+type MemoryMailService struct {
+	mu   sync.Mutex
+	sent []Mail
+}
+
+//NewMemoryMailService creates a mailer that never leaves the process
+func NewMemoryMailService() *MemoryMailService {
+	return &MemoryMailService{}
+}
+
+func (m *MemoryMailService) Send(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, Mail{To: to, Subject: subject, Body: body})
+	log.Printf("Mail to %s not delivered, no SMTP configured: %s", to, subject)
+	return nil
+}
+
+//Sent returns a copy of every message sent so far
+func (m *MemoryMailService) Sent() []Mail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Mail(nil), m.sent...)
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/myomyintko/strategy_robot/helper"
+	"github.com/myomyintko/strategy_robot/model"
+)
+
+var (
+	//ErrTickerUnknown is returned for a symbol the streams did not mention yet
+	ErrTickerUnknown = helper.NotFoundError("No ticker for symbol", nil)
+	//ErrTickerStale is returned by the in-process API while the streams are quiet
+	ErrTickerStale = helper.UpstreamError("Market data is stale", nil)
+)
+
+//MarketDataService caches the ticker of every symbol from the all market mini ticker and book
+//ticker streams. A stream quiet for longer than the stale time marks its figures stale and is
+//reconnected
+type MarketDataService interface {
+	//Ticker is the cached ticker of symbol, ErrTickerStale when it is stale
+	Ticker(symbol string) (model.Ticker, error)
+	//Tickers lists the cached tickers by symbol, stale ones included, only symbols when given
+	Tickers(symbols ...string) []model.Ticker
+	//Prices is the last price of every symbol, false while the mini ticker stream is stale
+	Prices() (map[string]float64, bool)
+	//Run follows both streams until ctx is done
+	Run(ctx context.Context)
+}
+
+type marketDataService struct {
+	staleAfter time.Duration
+	serveStats func(handler binance.WsAllMiniMarketsStatServeHandler, errHandler binance.ErrHandler) (doneC, stopC chan struct{}, err error)
+	serveBook  func(handler binance.WsBookTickerHandler, errHandler binance.ErrHandler) (doneC, stopC chan struct{}, err error)
+	// statsAt and bookAt are when each stream last delivered, in unix nanoseconds
+	statsAt int64
+	bookAt  int64
+
+	mu      sync.RWMutex
+	tickers map[string]*model.Ticker
+}
+
+//NewMarketDataService creates a new instance of MarketDataService, figures older than staleAfter are stale
+func NewMarketDataService(staleAfter time.Duration) MarketDataService {
+	return &marketDataService{
+		staleAfter: staleAfter,
+		serveStats: binance.WsAllMiniMarketsStatServe,
+		serveBook:  binance.WsAllBookTickerServe,
+		tickers:    make(map[string]*model.Ticker),
+	}
+}
+
+func (service *marketDataService) Ticker(symbol string) (model.Ticker, error) {
+	service.mu.RLock()
+	ticker, ok := service.tickers[strings.ToUpper(symbol)]
+	service.mu.RUnlock()
+	if !ok {
+		return model.Ticker{}, fmt.Errorf("%w: %s", ErrTickerUnknown, strings.ToUpper(symbol))
+	}
+	t := service.view(ticker, time.Now())
+	if t.Stale {
+		return t, ErrTickerStale
+	}
+	return t, nil
+}
+
+func (service *marketDataService) Tickers(symbols ...string) []model.Ticker {
+	now := time.Now()
+	tickers := []model.Ticker{}
+	service.mu.RLock()
+	if len(symbols) == 0 {
+		for _, ticker := range service.tickers {
+			tickers = append(tickers, service.view(ticker, now))
+		}
+	}
+	for _, symbol := range symbols {
+		if ticker, ok := service.tickers[strings.ToUpper(symbol)]; ok {
+			tickers = append(tickers, service.view(ticker, now))
+		}
+	}
+	service.mu.RUnlock()
+	sort.Slice(tickers, func(i, j int) bool { return tickers[i].Symbol < tickers[j].Symbol })
+	return tickers
+}
+
+func (service *marketDataService) Prices() (map[string]float64, bool) {
+	if service.stale(&service.statsAt, time.Now()) {
+		return nil, false
+	}
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	prices := make(map[string]float64, len(service.tickers))
+	for symbol, ticker := range service.tickers {
+		if ticker.LastPrice > 0 {
+			prices[symbol] = ticker.LastPrice
+		}
+	}
+	return prices, len(prices) > 0
+}
+
+func (service *marketDataService) Run(ctx context.Context) {
+	var streams sync.WaitGroup
+	streams.Add(2)
+	go func() {
+		defer streams.Done()
+		service.keep(ctx, "mini ticker", &service.statsAt, func(errHandler binance.ErrHandler) (chan struct{}, chan struct{}, error) {
+			return service.serveStats(service.onStats, errHandler)
+		})
+	}()
+	go func() {
+		defer streams.Done()
+		service.keep(ctx, "book ticker", &service.bookAt, func(errHandler binance.ErrHandler) (chan struct{}, chan struct{}, error) {
+			return service.serveBook(service.onBook, errHandler)
+		})
+	}()
+	streams.Wait()
+}
+
+//view copies ticker, flagging it stale when either stream went quiet. Callers hold mu
+func (service *marketDataService) view(ticker *model.Ticker, now time.Time) model.Ticker {
+	t := *ticker
+	t.Stale = service.stale(&service.statsAt, now) || service.stale(&service.bookAt, now)
+	return t
+}
+
+func (service *marketDataService) stale(last *int64, now time.Time) bool {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(last))) > service.staleAfter
+}
+
+func (service *marketDataService) onStats(event binance.WsAllMiniMarketsStatEvent) {
+	atomic.StoreInt64(&service.statsAt, time.Now().UnixNano())
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	for _, stat := range event {
+		ticker := service.ticker(stat.Symbol)
+		ticker.LastPrice = parseFloat(stat.LastPrice)
+		ticker.OpenPrice = parseFloat(stat.OpenPrice)
+		ticker.HighPrice = parseFloat(stat.HighPrice)
+		ticker.LowPrice = parseFloat(stat.LowPrice)
+		ticker.BaseVolume = parseFloat(stat.BaseVolume)
+		ticker.QuoteVolume = parseFloat(stat.QuoteVolume)
+		ticker.PriceChangePercent = 0
+		if ticker.OpenPrice > 0 {
+			ticker.PriceChangePercent = (ticker.LastPrice - ticker.OpenPrice) / ticker.OpenPrice * 100
+		}
+		ticker.StatsAt = time.Unix(0, stat.Time*int64(time.Millisecond))
+	}
+}
+
+func (service *marketDataService) onBook(event *binance.WsBookTickerEvent) {
+	now := time.Now()
+	atomic.StoreInt64(&service.bookAt, now.UnixNano())
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	ticker := service.ticker(event.Symbol)
+	ticker.BidPrice = parseFloat(event.BestBidPrice)
+	ticker.BidQuantity = parseFloat(event.BestBidQty)
+	ticker.AskPrice = parseFloat(event.BestAskPrice)
+	ticker.AskQuantity = parseFloat(event.BestAskQty)
+	ticker.BookAt = now
+}
+
+//ticker is the entry of symbol, created when missing. Callers hold mu
+func (service *marketDataService) ticker(symbol string) *model.Ticker {
+	ticker, ok := service.tickers[symbol]
+	if !ok {
+		ticker = &model.Ticker{Symbol: symbol}
+		service.tickers[symbol] = ticker
+	}
+	return ticker
+}
+
+//keep reconnects a stream until ctx is done, waiting longer after every failure in a row
+func (service *marketDataService) keep(ctx context.Context, name string, last *int64, open func(binance.ErrHandler) (chan struct{}, chan struct{}, error)) {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := service.follow(ctx, last, open)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		log.Printf("Market %s stream stopped, reconnecting in %s: %v", name, backoff, err)
+		if !sleep(ctx, backoff) {
+			return
+		}
+		if backoff *= 2; backoff > bookMaxBackoff {
+			backoff = bookMaxBackoff
+		}
+	}
+}
+
+//follow serves one connection of a stream until it fails or stays quiet past the stale time
+func (service *marketDataService) follow(ctx context.Context, last *int64, open func(binance.ErrHandler) (chan struct{}, chan struct{}, error)) error {
+	errs := make(chan error, 1)
+	doneC, stopC, err := open(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		close(stopC)
+		<-doneC
+	}()
+	connectedAt := time.Now()
+	check := time.NewTicker(service.staleAfter / 2)
+	defer check.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			return err
+		case <-doneC:
+			return errors.New("stream closed")
+		case now := <-check.C:
+			// a connection gets the stale time to deliver its first message
+			heard := time.Unix(0, atomic.LoadInt64(last))
+			if heard.Before(connectedAt) {
+				heard = connectedAt
+			}
+			if now.Sub(heard) > service.staleAfter {
+				return fmt.Errorf("no message within %s", service.staleAfter)
+			}
+		}
+	}
+}
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+)
+
+//fakeStreams stands in for both Binance ticker streams, every connection opened hands its handlers over
+type fakeStreams struct {
+	stats chan binance.WsAllMiniMarketsStatServeHandler
+	book  chan binance.WsBookTickerHandler
+	// fail is called with the error handler of every connection
+	fail chan binance.ErrHandler
+}
+
+func newFakeStreams() *fakeStreams {
+	return &fakeStreams{
+		stats: make(chan binance.WsAllMiniMarketsStatServeHandler, 16),
+		book:  make(chan binance.WsBookTickerHandler, 16),
+		fail:  make(chan binance.ErrHandler, 32),
+	}
+}
+
+//connection is a stream that stays open until it is stopped
+func (streams *fakeStreams) connection(errHandler binance.ErrHandler) (doneC, stopC chan struct{}, err error) {
+	doneC, stopC = make(chan struct{}), make(chan struct{})
+	go func() {
+		<-stopC
+		close(doneC)
+	}()
+	streams.fail <- errHandler
+	return doneC, stopC, nil
+}
+
+//run follows the fake streams with a service of staleAfter until the test ends
+func (streams *fakeStreams) run(t *testing.T, staleAfter time.Duration) *marketDataService {
+	t.Helper()
+	service := NewMarketDataService(staleAfter).(*marketDataService)
+	service.serveStats = func(handler binance.WsAllMiniMarketsStatServeHandler, errHandler binance.ErrHandler) (chan struct{}, chan struct{}, error) {
+		streams.stats <- handler
+		return streams.connection(errHandler)
+	}
+	service.serveBook = func(handler binance.WsBookTickerHandler, errHandler binance.ErrHandler) (chan struct{}, chan struct{}, error) {
+		streams.book <- handler
+		return streams.connection(errHandler)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		service.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+	return service
+}
+
+func btcStats(last string) binance.WsAllMiniMarketsStatEvent {
+	return binance.WsAllMiniMarketsStatEvent{{Symbol: "BTCUSDT", LastPrice: last, OpenPrice: "80", Time: time.Now().UnixNano() / int64(time.Millisecond)}}
+}
+
+func btcBook() *binance.WsBookTickerEvent {
+	return &binance.WsBookTickerEvent{Symbol: "BTCUSDT", BestBidPrice: "99", BestBidQty: "1", BestAskPrice: "101", BestAskQty: "2"}
+}
+
+func TestTickerIsStaleWhileEitherStreamIsQuiet(t *testing.T) {
+	streams := newFakeStreams()
+	service := streams.run(t, 100*time.Millisecond)
+	onStats, onBook := <-streams.stats, <-streams.book
+
+	if _, err := service.Ticker("btcusdt"); !errors.Is(err, ErrTickerUnknown) {
+		t.Fatalf("ticker before any message: %v, want ErrTickerUnknown", err)
+	}
+	onStats(btcStats("100"))
+	onBook(btcBook())
+	ticker, err := service.Ticker("btcusdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticker.Stale || ticker.LastPrice != 100 || ticker.BidPrice != 99 || ticker.AskQuantity != 2 || ticker.PriceChangePercent != 25 {
+		t.Errorf("fresh ticker = %+v", ticker)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	onBook(btcBook())
+	ticker, err = service.Ticker("BTCUSDT")
+	if !errors.Is(err, ErrTickerStale) {
+		t.Fatalf("ticker with a quiet mini ticker stream: %v, want ErrTickerStale", err)
+	}
+	if !ticker.Stale || ticker.LastPrice != 100 {
+		t.Errorf("stale ticker = %+v, want the last figures flagged stale", ticker)
+	}
+	if tickers := service.Tickers(); len(tickers) != 1 || !tickers[0].Stale {
+		t.Errorf("tickers = %+v, want the stale one listed as stale", tickers)
+	}
+
+	onStats(btcStats("110"))
+	if ticker, err := service.Ticker("BTCUSDT"); err != nil || ticker.LastPrice != 110 {
+		t.Errorf("ticker once both streams delivered again = %+v, %v", ticker, err)
+	}
+}
+
+func TestPricesAreRefusedWhileTheMiniTickerStreamIsQuiet(t *testing.T) {
+	streams := newFakeStreams()
+	service := streams.run(t, 100*time.Millisecond)
+	onStats, onBook := <-streams.stats, <-streams.book
+
+	if _, ok := service.Prices(); ok {
+		t.Error("prices handed out before the first message")
+	}
+	onStats(btcStats("100"))
+	if prices, ok := service.Prices(); !ok || prices["BTCUSDT"] != 100 {
+		t.Errorf("prices = %v, %v, want BTCUSDT at 100", prices, ok)
+	}
+
+	// the book ticker stream keeps delivering, prices only come from the mini ticker one
+	deadline := time.Now().Add(150 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		onBook(btcBook())
+		time.Sleep(10 * time.Millisecond)
+	}
+	if prices, ok := service.Prices(); ok {
+		t.Errorf("prices = %v while the mini ticker stream is quiet", prices)
+	}
+	onStats(btcStats("110"))
+	if prices, ok := service.Prices(); !ok || prices["BTCUSDT"] != 110 {
+		t.Errorf("prices once the stream delivered again = %v, %v", prices, ok)
+	}
+}
+
+func TestQuietStreamIsReconnected(t *testing.T) {
+	streams := newFakeStreams()
+	streams.run(t, 100*time.Millisecond)
+	onStats := <-streams.stats
+	<-streams.book
+
+	// only the book ticker stream goes quiet, the mini ticker one keeps its connection
+	timeout := time.After(5 * time.Second)
+	beat := time.NewTicker(10 * time.Millisecond)
+	defer beat.Stop()
+	for {
+		select {
+		case <-streams.book:
+			select {
+			case <-streams.stats:
+				t.Error("mini ticker stream delivering every message was reconnected")
+			default:
+			}
+			return
+		case <-beat.C:
+			onStats(btcStats("100"))
+		case <-timeout:
+			t.Fatal("book ticker stream quiet past the stale time was not reconnected")
+		}
+	}
+}
+
+func TestFollowEndsOnAStreamError(t *testing.T) {
+	streams := newFakeStreams()
+	service := NewMarketDataService(time.Minute).(*marketDataService)
+	failure := errors.New("connection reset")
+	ended := make(chan error, 1)
+	go func() {
+		ended <- service.follow(context.Background(), &service.statsAt, streams.connection)
+	}()
+	(<-streams.fail)(failure)
+	select {
+	case err := <-ended:
+		if !errors.Is(err, failure) {
+			t.Errorf("follow = %v, want %v", err, failure)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("follow kept a failed connection")
+	}
+}
@@ -0,0 +1,460 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/myomyintko/strategy_robot/helper"
+	"github.com/myomyintko/strategy_robot/model"
+)
+
+var (
+	//ErrBookSyncing is returned while a local order book is not in line with Binance yet
+	ErrBookSyncing = helper.UpstreamError("Order book is syncing, retry shortly", nil)
+	//ErrTooManyBooks is returned when a new symbol would open more books than allowed
+	ErrTooManyBooks = helper.UnprocessableError("Too many order books are open", nil)
+	//errDepthGap is a diff that does not follow the last one applied, the book has to resync
+	errDepthGap = errors.New("depth update gap")
+)
+
+var symbolPattern = regexp.MustCompile(`^[A-Z0-9]{2,20}$`)
+
+const (
+	//depthSnapshotLimit is how many levels a side of the snapshot a book starts from holds
+	depthSnapshotLimit = 1000
+	//depthBuffer is how many diffs may queue while the snapshot loads
+	depthBuffer = 1000
+	//bookReadyTimeout is how long a request waits for a new book to sync
+	bookReadyTimeout = 5 * time.Second
+	bookMaxBackoff   = 30 * time.Second
+)
+
+//OrderBookService keeps a local order book per symbol from the diff depth stream. A book is
+//opened on first use, started from a REST snapshot, resynced whenever an update is missed
+//and closed once nobody asked for it within the idle time
+type OrderBookService interface {
+	//Depth is the best levels of symbol, levels per side
+	Depth(ctx context.Context, symbol string, levels int) (model.OrderBookDepth, error)
+	//EstimateFill walks the book of symbol as a market order of quantity on side would
+	EstimateFill(ctx context.Context, symbol string, side string, quantity float64) (model.FillEstimate, error)
+	//Watch closes idle books every interval until ctx is done, then waits for every book to stop
+	Watch(ctx context.Context, interval time.Duration)
+}
+
+//depthServe opens the diff depth stream of a symbol
+type depthServe func(symbol string, handler binance.WsDepthHandler, errHandler binance.ErrHandler) (doneC, stopC chan struct{}, err error)
+
+type orderBookService struct {
+	// ctx ends every book when the app shuts down
+	ctx    context.Context
+	client *binance.Client
+	caller BinanceCaller
+	idle   time.Duration
+	limit  int
+	serve  depthServe
+
+	mu      sync.Mutex
+	books   map[string]*localBook
+	running sync.WaitGroup
+}
+
+//NewOrderBookService creates a new instance of OrderBookService loading snapshots with client,
+//at most limit books are open at once
+func NewOrderBookService(ctx context.Context, client *binance.Client, caller BinanceCaller, idle time.Duration, limit int) OrderBookService {
+	return &orderBookService{
+		ctx:    ctx,
+		client: client,
+		caller: caller,
+		idle:   idle,
+		limit:  limit,
+		serve:  binance.WsDepthServe100Ms,
+		books:  make(map[string]*localBook),
+	}
+}
+
+func (service *orderBookService) Depth(ctx context.Context, symbol string, levels int) (model.OrderBookDepth, error) {
+	book, err := service.ready(ctx, symbol)
+	if err != nil {
+		return model.OrderBookDepth{}, err
+	}
+	return book.depth(levels)
+}
+
+func (service *orderBookService) EstimateFill(ctx context.Context, symbol string, side string, quantity float64) (model.FillEstimate, error) {
+	side = strings.ToUpper(side)
+	if side != string(binance.SideTypeBuy) && side != string(binance.SideTypeSell) {
+		return model.FillEstimate{}, helper.ValidationError("Invalid side", fmt.Errorf("side must be %s or %s", binance.SideTypeBuy, binance.SideTypeSell))
+	}
+	if quantity <= 0 {
+		return model.FillEstimate{}, helper.ValidationError("Invalid quantity", errors.New("quantity must be positive"))
+	}
+	book, err := service.ready(ctx, symbol)
+	if err != nil {
+		return model.FillEstimate{}, err
+	}
+	return book.estimate(side, quantity)
+}
+
+func (service *orderBookService) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			service.running.Wait()
+			return
+		case <-ticker.C:
+			service.evict(time.Now())
+		}
+	}
+}
+
+//ready opens the book of symbol when needed and waits for its first sync
+func (service *orderBookService) ready(ctx context.Context, symbol string) (*localBook, error) {
+	book, err := service.book(symbol)
+	if err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(bookReadyTimeout)
+	defer timer.Stop()
+	select {
+	case <-book.ready:
+	case <-timer.C:
+		return nil, ErrBookSyncing
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if err := book.failure(); err != nil {
+		service.drop(book)
+		return nil, err
+	}
+	return book, nil
+}
+
+func (service *orderBookService) book(symbol string) (*localBook, error) {
+	symbol = strings.ToUpper(symbol)
+	if !symbolPattern.MatchString(symbol) {
+		return nil, helper.ValidationError("Invalid symbol", fmt.Errorf("%q is not a symbol", symbol))
+	}
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	if book, ok := service.books[symbol]; ok {
+		book.touch()
+		return book, nil
+	}
+	if len(service.books) >= service.limit {
+		return nil, fmt.Errorf("%w: %d are open", ErrTooManyBooks, service.limit)
+	}
+	ctx, cancel := context.WithCancel(service.ctx)
+	book := newLocalBook(symbol, cancel)
+	service.books[symbol] = book
+	service.running.Add(1)
+	go func() {
+		defer service.running.Done()
+		service.maintain(ctx, book)
+	}()
+	return book, nil
+}
+
+func (service *orderBookService) drop(book *localBook) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	if service.books[book.symbol] == book {
+		delete(service.books, book.symbol)
+	}
+	book.cancel()
+}
+
+func (service *orderBookService) evict(now time.Time) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	for symbol, book := range service.books {
+		if now.Sub(book.lastUsed()) > service.idle {
+			delete(service.books, symbol)
+			book.cancel()
+		}
+	}
+}
+
+//maintain follows the stream of book until ctx is done, resyncing with a growing delay
+//while it keeps failing before the book is in sync
+func (service *orderBookService) maintain(ctx context.Context, book *localBook) {
+	backoff := time.Second
+	for {
+		synced, err := service.follow(ctx, book)
+		book.desync()
+		if ctx.Err() != nil || book.failure() != nil {
+			return
+		}
+		if synced {
+			backoff = time.Second
+		}
+		log.Printf("Order book %s out of sync, resyncing in %s: %v", book.symbol, backoff, err)
+		if !sleep(ctx, backoff) {
+			return
+		}
+		if backoff *= 2; backoff > bookMaxBackoff {
+			backoff = bookMaxBackoff
+		}
+	}
+}
+
+//follow opens the diff stream, loads a snapshot and applies the diffs buffered since on top
+//of it until the stream fails or skips an update. It reports whether the book got in sync
+func (service *orderBookService) follow(ctx context.Context, book *localBook) (bool, error) {
+	events := make(chan *binance.WsDepthEvent, depthBuffer)
+	errs := make(chan error, 1)
+	report := func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+	doneC, stopC, err := service.serve(book.symbol, func(event *binance.WsDepthEvent) {
+		select {
+		case events <- event:
+		default:
+			report(errors.New("depth buffer overflow"))
+		}
+	}, report)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		close(stopC)
+		<-doneC
+	}()
+
+	var snapshot *binance.DepthResponse
+	err = service.caller.Call(ctx, service.client, true, func(client *binance.Client) (err error) {
+		snapshot, err = client.NewDepthService().Symbol(book.symbol).Limit(depthSnapshotLimit).Do(ctx)
+		return err
+	})
+	if err != nil {
+		if ctx.Err() == nil && !isTransient(err) {
+			// an unknown symbol never syncs, the waiting request gets the error
+			book.fail(ExchangeError("Failed to load order book", err))
+		}
+		return false, err
+	}
+	book.load(snapshot)
+
+	synced := false
+	for {
+		select {
+		case <-ctx.Done():
+			return synced, nil
+		case err := <-errs:
+			return synced, err
+		case <-doneC:
+			return synced, errors.New("depth stream closed")
+		case event := <-events:
+			if err := book.apply(event); err != nil {
+				return synced, err
+			}
+			// only a diff on top of the snapshot proves the stream keeps up, a book that
+			// gaps before one keeps backing off instead of reloading the snapshot at once
+			synced = synced || book.isBridged()
+		}
+	}
+}
+
+//localBook is one symbol kept in line with Binance by its stream
+type localBook struct {
+	symbol string
+	cancel context.CancelFunc
+	// ready is closed once the book first synced or failed for good
+	ready     chan struct{}
+	readyOnce sync.Once
+	used      int64
+
+	mu       sync.RWMutex
+	bids     map[float64]float64
+	asks     map[float64]float64
+	updateID int64
+	synced   bool
+	// bridged is set once a diff followed the snapshot, later diffs must then follow without a gap
+	bridged   bool
+	updatedAt time.Time
+	err       error
+}
+
+func newLocalBook(symbol string, cancel context.CancelFunc) *localBook {
+	book := &localBook{symbol: symbol, cancel: cancel, ready: make(chan struct{})}
+	book.touch()
+	return book
+}
+
+func (book *localBook) touch() {
+	atomic.StoreInt64(&book.used, time.Now().UnixNano())
+}
+
+func (book *localBook) lastUsed() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&book.used))
+}
+
+func (book *localBook) load(snapshot *binance.DepthResponse) {
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	book.bids = make(map[float64]float64, len(snapshot.Bids))
+	book.asks = make(map[float64]float64, len(snapshot.Asks))
+	setLevels(book.bids, snapshot.Bids)
+	setLevels(book.asks, snapshot.Asks)
+	book.updateID = snapshot.LastUpdateID
+	book.updatedAt = time.Now()
+	// a quiet symbol may send no diff for long, the snapshot alone is a valid book
+	book.synced = true
+	book.bridged = false
+	book.readyOnce.Do(func() { close(book.ready) })
+}
+
+//apply adds a diff on top of the book. Diffs the snapshot already holds are dropped, the first
+//diff after it has to span the snapshot and every later one follow the previous without a gap.
+//A gap takes the book out of sync until it is loaded again
+func (book *localBook) apply(event *binance.WsDepthEvent) error {
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	if event.LastUpdateID <= book.updateID {
+		return nil
+	}
+	if event.FirstUpdateID > book.updateID+1 || (book.bridged && event.FirstUpdateID != book.updateID+1) {
+		book.synced = false
+		return fmt.Errorf("%w: expected %d, got %d to %d", errDepthGap, book.updateID+1, event.FirstUpdateID, event.LastUpdateID)
+	}
+	setLevels(book.bids, event.Bids)
+	setLevels(book.asks, event.Asks)
+	book.updateID = event.LastUpdateID
+	book.updatedAt = time.Unix(0, event.Time*int64(time.Millisecond))
+	book.bridged = true
+	return nil
+}
+
+func (book *localBook) desync() {
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	book.synced = false
+}
+
+func (book *localBook) inSync() bool {
+	book.mu.RLock()
+	defer book.mu.RUnlock()
+	return book.synced
+}
+
+//isBridged reports whether a diff followed the snapshot
+func (book *localBook) isBridged() bool {
+	book.mu.RLock()
+	defer book.mu.RUnlock()
+	return book.bridged
+}
+
+func (book *localBook) fail(err error) {
+	book.mu.Lock()
+	book.err = err
+	book.mu.Unlock()
+	book.readyOnce.Do(func() { close(book.ready) })
+}
+
+func (book *localBook) failure() error {
+	book.mu.RLock()
+	defer book.mu.RUnlock()
+	return book.err
+}
+
+func (book *localBook) depth(levels int) (model.OrderBookDepth, error) {
+	book.mu.RLock()
+	defer book.mu.RUnlock()
+	if !book.synced {
+		return model.OrderBookDepth{}, ErrBookSyncing
+	}
+	depth := model.OrderBookDepth{
+		Symbol:    book.symbol,
+		UpdateID:  book.updateID,
+		UpdatedAt: book.updatedAt,
+		Bids:      sortedLevels(book.bids, true, levels),
+		Asks:      sortedLevels(book.asks, false, levels),
+	}
+	if len(depth.Bids) > 0 {
+		depth.BestBid = depth.Bids[0].Price
+	}
+	if len(depth.Asks) > 0 {
+		depth.BestAsk = depth.Asks[0].Price
+	}
+	if depth.BestBid > 0 && depth.BestAsk > 0 {
+		depth.Spread = depth.BestAsk - depth.BestBid
+		depth.MidPrice = (depth.BestAsk + depth.BestBid) / 2
+	}
+	return depth, nil
+}
+
+//estimate takes quantity from the asks for a buy and from the bids for a sell, best price first
+func (book *localBook) estimate(side string, quantity float64) (model.FillEstimate, error) {
+	book.mu.RLock()
+	defer book.mu.RUnlock()
+	if !book.synced {
+		return model.FillEstimate{}, ErrBookSyncing
+	}
+	levels := sortedLevels(book.asks, false, 0)
+	if side == string(binance.SideTypeSell) {
+		levels = sortedLevels(book.bids, true, 0)
+	}
+	estimate := model.FillEstimate{Symbol: book.symbol, Side: side, Quantity: quantity}
+	for _, level := range levels {
+		if estimate.Filled >= quantity {
+			break
+		}
+		take := math.Min(quantity-estimate.Filled, level.Quantity)
+		estimate.Filled += take
+		estimate.Cost += take * level.Price
+		estimate.WorstPrice = level.Price
+	}
+	if estimate.Filled > 0 {
+		estimate.VWAP = estimate.Cost / estimate.Filled
+		estimate.Slippage = math.Abs(estimate.VWAP-levels[0].Price) / levels[0].Price
+	}
+	return estimate, nil
+}
+
+//setLevels applies price levels to side, a zero quantity removes the level
+func setLevels(side map[float64]float64, levels []common.PriceLevel) {
+	for _, level := range levels {
+		price, quantity, err := level.Parse()
+		if err != nil {
+			continue
+		}
+		if quantity == 0 {
+			delete(side, price)
+		} else {
+			side[price] = quantity
+		}
+	}
+}
+
+//sortedLevels lists side best price first, descending for bids, all of it when n is zero
+func sortedLevels(side map[float64]float64, descending bool, n int) []model.PriceLevel {
+	levels := make([]model.PriceLevel, 0, len(side))
+	for price, quantity := range side {
+		levels = append(levels, model.PriceLevel{Price: price, Quantity: quantity})
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		if descending {
+			return levels[i].Price > levels[j].Price
+		}
+		return levels[i].Price < levels[j].Price
+	})
+	if n > 0 && len(levels) > n {
+		levels = levels[:n]
+	}
+	return levels
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
+)
+
+func TestLocalBookApply(t *testing.T) {
+	snapshot := &binance.DepthResponse{
+		LastUpdateID: 100,
+		Bids:         []common.PriceLevel{{Price: "10", Quantity: "1"}},
+		Asks:         []common.PriceLevel{{Price: "11", Quantity: "1"}},
+	}
+	diff := func(first, last int64) *binance.WsDepthEvent {
+		return &binance.WsDepthEvent{
+			FirstUpdateID: first,
+			LastUpdateID:  last,
+			Bids:          []common.PriceLevel{{Price: "10", Quantity: "2"}},
+		}
+	}
+	tests := []struct {
+		name   string
+		events []*binance.WsDepthEvent
+		// gap is whether the last event has to resync the book
+		gap    bool
+		synced bool
+		bid    float64
+	}{
+		{"snapshot alone is in sync", nil, false, true, 1},
+		{"stale diff the snapshot holds is dropped", []*binance.WsDepthEvent{diff(90, 100)}, false, true, 1},
+		{"first diff spanning the snapshot is applied", []*binance.WsDepthEvent{diff(95, 105)}, false, true, 2},
+		{"first diff right after the snapshot is applied", []*binance.WsDepthEvent{diff(101, 105)}, false, true, 2},
+		{"first diff past the snapshot is a gap", []*binance.WsDepthEvent{diff(102, 105)}, true, false, 1},
+		{"diffs following each other are applied", []*binance.WsDepthEvent{diff(95, 105), diff(106, 110)}, false, true, 2},
+		{"stale diff after the first is dropped", []*binance.WsDepthEvent{diff(95, 105), diff(100, 104)}, false, true, 2},
+		{"skipped update after the first diff is a gap", []*binance.WsDepthEvent{diff(95, 105), diff(107, 110)}, true, false, 2},
+		{"overlapping diff after the first is a gap", []*binance.WsDepthEvent{diff(95, 105), diff(104, 110)}, true, false, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := newLocalBook("BTCUSDT", func() {})
+			book.load(snapshot)
+			var err error
+			for _, event := range tt.events {
+				if err = book.apply(event); err != nil {
+					break
+				}
+			}
+			if gap := errors.Is(err, errDepthGap); gap != tt.gap || (err != nil && !gap) {
+				t.Errorf("apply = %v, want gap %v", err, tt.gap)
+			}
+			if book.inSync() != tt.synced {
+				t.Errorf("in sync = %v, want %v", book.inSync(), tt.synced)
+			}
+			if got := book.bids[10]; got != tt.bid {
+				t.Errorf("bid quantity at 10 = %v, want %v", got, tt.bid)
+			}
+		})
+	}
+}
+
+func TestQuietBookIsReadyOnItsSnapshot(t *testing.T) {
+	book := newLocalBook("BTCUSDT", func() {})
+	book.load(&binance.DepthResponse{
+		LastUpdateID: 100,
+		Bids:         []common.PriceLevel{{Price: "10", Quantity: "1"}},
+		Asks:         []common.PriceLevel{{Price: "11", Quantity: "1"}},
+	})
+	select {
+	case <-book.ready:
+	default:
+		t.Fatal("book with a snapshot and no diff is not ready")
+	}
+	depth, err := book.depth(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if depth.BestBid != 10 || depth.BestAsk != 11 {
+		t.Errorf("depth of the snapshot = %+v", depth)
+	}
+}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/myomyintko/strategy_robot/model"
+	"github.com/myomyintko/strategy_robot/repository"
+)
+
+//OrderReconciler keeps the orders table in line with what Binance reports. Orders placed
+//on the Binance UI are imported as external and missed status changes are repaired, every
+//difference is logged and stored as a model.OrderDiscrepancy
+type OrderReconciler interface {
+	Reconcile(ctx context.Context)
+	Watch(ctx context.Context, interval time.Duration)
+}
+
+type orderReconciler struct {
+	binanceRepository repository.BinanceRepository
+	robotRepository   repository.RobotRepository
+	clients           ClientRegistry
+	caller            BinanceCaller
+	// lookback is how far back trades are scanned for orders we have no record of
+	lookback time.Duration
+}
+
+//NewOrderReconciler creates a new instance of OrderReconciler
+func NewOrderReconciler(binRepo repository.BinanceRepository, robotRepo repository.RobotRepository, clients ClientRegistry, caller BinanceCaller, lookback time.Duration) OrderReconciler {
+	return &orderReconciler{
+		binanceRepository: binRepo,
+		robotRepository:   robotRepo,
+		clients:           clients,
+		caller:            caller,
+		lookback:          lookback,
+	}
+}
+
+//Reconcile runs one pass per bound key over every symbol a robot trades and every symbol
+//with an unsettled or recent order, which covers the pairs a rebalance robot traded
+func (r *orderReconciler) Reconcile(ctx context.Context) {
+	// the first robot on a symbol owns the orders imported for it
+	owners := map[uint64]map[string]uint64{}
+	own := func(userID, robotID uint64, symbol string) {
+		if symbol == "" {
+			return
+		}
+		if owners[userID] == nil {
+			owners[userID] = map[string]uint64{}
+		}
+		if _, ok := owners[userID][symbol]; !ok {
+			owners[userID][symbol] = robotID
+		}
+	}
+	for _, robot := range r.robotRepository.AllRobot() {
+		own(robot.UserID, robot.ID, robot.Symbol)
+	}
+	since := time.Now().Add(-r.lookback)
+	for _, order := range r.binanceRepository.FindOrderedSymbols(since) {
+		own(order.UserID, order.RobotID, order.Symbol)
+	}
+	for userID, symbols := range owners {
+		client, err := r.clients.ForUser(userID)
+		if err != nil {
+			continue
+		}
+		for symbol, robotID := range symbols {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := r.reconcileSymbol(ctx, client, userID, robotID, symbol, since); err != nil {
+				log.Printf("Failed to reconcile orders of user %d on %s: %v", userID, symbol, err)
+			}
+		}
+	}
+}
+
+//Watch runs Reconcile right away, then every interval until ctx is done
+func (r *orderReconciler) Watch(ctx context.Context, interval time.Duration) {
+	// orders left unknown by the last shutdown are looked up without waiting an interval
+	r.Reconcile(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Reconcile(ctx)
+		}
+	}
+}
+
+//reconcileSymbol matches the open orders and recent trades of one symbol with our orders,
+//then settles our unsettled orders Binance did not mention
+func (r *orderReconciler) reconcileSymbol(ctx context.Context, client *binance.Client, userID, robotID uint64, symbol string, since time.Time) error {
+	var open []*binance.Order
+	err := r.caller.Call(ctx, client, true, func(client *binance.Client) (err error) {
+		open, err = client.NewListOpenOrdersService().Symbol(symbol).Do(ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	var trades []*binance.TradeV3
+	err = r.caller.Call(ctx, client, true, func(client *binance.Client) (err error) {
+		trades, err = client.NewListTradesService().Symbol(symbol).
+			StartTime(since.UnixNano() / int64(time.Millisecond)).Do(ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	seen := map[int64]bool{}
+	for _, order := range open {
+		seen[order.OrderID] = true
+		r.record(userID, robotID, order)
+	}
+	for _, trade := range trades {
+		if seen[trade.OrderID] {
+			continue
+		}
+		seen[trade.OrderID] = true
+		if local := r.binanceRepository.FindOrderByExchangeID(userID, trade.OrderID); local.ID != 0 && isFinal(local.Status) {
+			continue
+		}
+		order, err := r.lookup(ctx, client, symbol, trade.OrderID, "")
+		if err != nil {
+			return err
+		}
+		r.record(userID, robotID, order)
+	}
+
+	for _, local := range r.binanceRepository.FindUnsettledOrders(userID, symbol) {
+		if local.OrderId != 0 && seen[local.OrderId] {
+			continue
+		}
+		if (local.Status == model.OrderPending || local.Status == model.OrderUnknown) && time.Since(local.UpdatedAt) < orderSettleWindow {
+			// PlaceOrder may still be submitting it
+			continue
+		}
+		order, err := r.lookup(ctx, client, symbol, 0, local.ClientOrderId)
+		switch {
+		case err == nil:
+			r.record(userID, robotID, order)
+		case isUnknownOrder(err):
+			status := model.OrderMissing
+			if local.Status == model.OrderPending || local.Status == model.OrderUnknown {
+				// it never reached Binance, the idempotency key may submit it again
+				status = model.OrderRejected
+			}
+			r.repair(local, model.DiscrepancyMissing, status, local.OrderId)
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+//lookup asks Binance for an order on symbol by its exchange ID, or by its client order ID when orderID is zero
+func (r *orderReconciler) lookup(ctx context.Context, client *binance.Client, symbol string, orderID int64, clientOrderID string) (*binance.Order, error) {
+	var order *binance.Order
+	err := r.caller.Call(ctx, client, true, func(client *binance.Client) (err error) {
+		query := client.NewGetOrderService().Symbol(symbol)
+		if orderID != 0 {
+			query = query.OrderID(orderID)
+		} else {
+			query = query.OrigClientOrderID(clientOrderID)
+		}
+		order, err = query.Do(ctx)
+		return err
+	})
+	return order, err
+}
+
+//record matches a Binance order with our order of record, importing it when we have none
+func (r *orderReconciler) record(userID, robotID uint64, order *binance.Order) {
+	local := r.binanceRepository.FindOrderByClientID(order.ClientOrderID)
+	if local.ID == 0 || local.UserID != userID {
+		local = r.binanceRepository.FindOrderByExchangeID(userID, order.OrderID)
+	}
+	if local.ID != 0 {
+		if local.Status != string(order.Status) || local.OrderId != order.OrderID {
+			r.repair(local, model.DiscrepancyStatus, string(order.Status), order.OrderID)
+		}
+		return
+	}
+	imported, err := r.binanceRepository.InsertOrder(model.Order{
+		OrderId:       order.OrderID,
+		ClientOrderId: order.ClientOrderID,
+		Symbol:        order.Symbol,
+		Side:          string(order.Side),
+		Price:         order.Price,
+		Quantity:      order.OrigQuantity,
+		Status:        string(order.Status),
+		Source:        model.OrderSourceExternal,
+		UserID:        userID,
+		RobotID:       robotID,
+		OrderedAt:     time.Unix(0, order.Time*int64(time.Millisecond)),
+	})
+	if err != nil {
+		log.Printf("Failed to import order %d of user %d on %s: %v", order.OrderID, userID, order.Symbol, err)
+		return
+	}
+	r.report(imported, model.DiscrepancyImported, "", imported.Status)
+}
+
+//repair moves local to the status Binance reports and records the difference
+func (r *orderReconciler) repair(local model.Order, kind, status string, orderID int64) {
+	previous := local.Status
+	local.Status = status
+	local.OrderId = orderID
+	if kind == model.DiscrepancyStatus {
+		local.Error = ""
+	} else {
+		local.Error = "not found on Binance"
+	}
+	r.binanceRepository.UpdateOrder(local)
+	r.report(local, kind, previous, status)
+}
+
+func (r *orderReconciler) report(order model.Order, kind, localStatus, exchangeStatus string) {
+	log.Printf("Reconciled order %d of user %d on %s: %s %q -> %q", order.ID, order.UserID, order.Symbol, kind, localStatus, exchangeStatus)
+	r.binanceRepository.InsertDiscrepancy(model.OrderDiscrepancy{
+		UserID:         order.UserID,
+		OrderID:        order.ID,
+		Symbol:         order.Symbol,
+		ClientOrderId:  order.ClientOrderId,
+		Kind:           kind,
+		LocalStatus:    localStatus,
+		ExchangeStatus: exchangeStatus,
+		DetectedAt:     time.Now(),
+	})
+}
+
+func isFinal(status string) bool {
+	for _, final := range model.FinalOrderStatuses {
+		if status == final {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/myomyintko/strategy_robot/model"
+	"github.com/myomyintko/strategy_robot/repository"
+)
+
+//fixedRegistry hands out one client to every user
+type fixedRegistry struct {
+	client *binance.Client
+}
+
+func (r fixedRegistry) ForUser(uint64) (*binance.Client, error) {
+	return r.client, nil
+}
+
+func (r fixedRegistry) Invalidate(uint64) {}
+
+func TestWatchLooksUpOrdersOfRebalanceRobotsAtOnce(t *testing.T) {
+	db := newTestDB(t)
+	user := model.User{Name: "alice", Email: "alice@example.com", Password: "hash", Role: model.RoleTrader}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	robots := repository.NewRobotRepository(db)
+	orders := repository.NewBinanceRepository(db)
+	// a rebalance robot has no symbol of its own, only the orders it placed name one
+	robot := robots.InsertRobot(model.Robot{Kind: model.RobotKindRebalance, UserID: user.ID})
+	order, err := orders.InsertOrder(model.Order{ClientOrderId: "sr-left-unknown", Symbol: "ETHBTC", Status: model.OrderUnknown, UserID: user.ID, RobotID: robot.ID, OrderedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&order).UpdateColumn("updated_at", time.Now().Add(-time.Hour))
+
+	client := newTestExchange(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/order":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"code":-2013,"msg":"Order does not exist."}`))
+		default:
+			_, _ = w.Write([]byte(`[]`))
+		}
+	})
+	reconciler := NewOrderReconciler(orders, robots, fixedRegistry{client}, NewBinanceCaller(RetryPolicy{Attempts: 1}), time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reconciler.Watch(ctx, time.Hour)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if got := orders.FindOrderByClientID(order.ClientOrderId); got.Status == model.OrderRejected {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("order left unknown is %q, want %q before the first interval", orders.FindOrderByClientID(order.ClientOrderId).Status, model.OrderRejected)
+}
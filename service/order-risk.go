@@ -0,0 +1,38 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/myomyintko/strategy_robot/dto"
+	"github.com/myomyintko/strategy_robot/helper"
+)
+
+//ErrRiskLimit is returned for orders larger than the configured risk limits
+var ErrRiskLimit = helper.UnprocessableError("Order exceeds risk limits", nil)
+
+//RiskLimits caps single orders, zero disables a cap
+type RiskLimits struct {
+	MaxQuantity float64
+	MaxNotional float64
+}
+
+//CheckRisk refuses orders above the quantity or notional caps before they reach Binance,
+//PlaceOrder runs it first so every order path is covered
+func (service *binanceService) CheckRisk(o dto.CreateOrderDTO) error {
+	quantity, err := strconv.ParseFloat(o.Quantity, 64)
+	if err != nil || quantity <= 0 {
+		return helper.ValidationError("Invalid quantity", fmt.Errorf("%q is not a number", o.Quantity))
+	}
+	price, err := strconv.ParseFloat(o.Price, 64)
+	if err != nil || price <= 0 {
+		return helper.ValidationError("Invalid price", fmt.Errorf("%q is not a number", o.Price))
+	}
+	if service.risk.MaxQuantity > 0 && quantity > service.risk.MaxQuantity {
+		return helper.UnprocessableError(ErrRiskLimit.Message, fmt.Errorf("quantity %s is above %g", o.Quantity, service.risk.MaxQuantity))
+	}
+	if service.risk.MaxNotional > 0 && quantity*price > service.risk.MaxNotional {
+		return helper.UnprocessableError(ErrRiskLimit.Message, fmt.Errorf("notional %g is above %g", quantity*price, service.risk.MaxNotional))
+	}
+	return nil
+}
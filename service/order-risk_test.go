@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/myomyintko/strategy_robot/dto"
+	"github.com/myomyintko/strategy_robot/helper"
+)
+
+func TestCheckRisk(t *testing.T) {
+	service := NewBinanceService(nil, RiskLimits{MaxQuantity: 10, MaxNotional: 1000}, nil)
+	tests := []struct {
+		name, price, quantity string
+		status                int
+	}{
+		{"within caps", "50", "10", 0},
+		{"quantity above cap", "1", "11", http.StatusUnprocessableEntity},
+		{"notional above cap", "200", "6", http.StatusUnprocessableEntity},
+		{"quantity not a number", "1", "ten", http.StatusBadRequest},
+		{"price not positive", "0", "1", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		err := service.CheckRisk(dto.CreateOrderDTO{Price: tt.price, Quantity: tt.quantity})
+		switch {
+		case tt.status == 0 && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.status != 0 && (err == nil || helper.AsError(err).Status() != tt.status):
+			t.Errorf("%s: got %v, want status %d", tt.name, err, tt.status)
+		}
+	}
+}
+
+func TestPlaceOrderChecksRiskBeforeAnythingElse(t *testing.T) {
+	// no repository and no client, an order over the cap must not reach either
+	service := NewBinanceService(nil, RiskLimits{MaxQuantity: 1}, nil)
+	_, err := service.PlaceOrder(context.Background(), nil, dto.CreateOrderDTO{Price: "1", Quantity: "2", IdempotencyKey: "key"})
+	if err == nil || helper.AsError(err).Message != ErrRiskLimit.Message {
+		t.Fatalf("PlaceOrder above the cap = %v", err)
+	}
+}
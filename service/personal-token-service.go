@@ -0,0 +1,103 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/myomyintko/strategy_robot/dto"
+	"github.com/myomyintko/strategy_robot/helper"
+	"github.com/myomyintko/strategy_robot/model"
+	"github.com/myomyintko/strategy_robot/repository"
+)
+
+const (
+	// PersonalTokenPrefix marks bearer tokens that are personal access tokens rather than JWTs
+	PersonalTokenPrefix = "pat_"
+	defaultTokenDays    = 90
+	// last_used_at is only written once per interval to keep scripts from writing on every call
+	tokenTouchInterval = time.Minute
+)
+
+// ErrInvalidPersonalToken is returned for unknown, expired or revoked personal access tokens
+var ErrInvalidPersonalToken = helper.AuthError("Invalid personal access token", nil)
+
+//PersonalTokenService is a contract of what personalTokenService can do
+type PersonalTokenService interface {
+	Create(userID uint64, b dto.PersonalTokenCreateDTO) (model.PersonalAccessToken, error)
+	FindByUserID(userID uint64) []model.PersonalAccessToken
+	Revoke(userID, tokenID uint64) bool
+	Authenticate(token string) (helper.Principal, error)
+}
+
+type personalTokenService struct {
+	tokenRepository repository.PersonalTokenRepository
+	userRepository  repository.UserRepository
+}
+
+//NewPersonalTokenService creates a new instance of PersonalTokenService
+func NewPersonalTokenService(tokenRepo repository.PersonalTokenRepository, userRepo repository.UserRepository) PersonalTokenService {
+	return &personalTokenService{
+		tokenRepository: tokenRepo,
+		userRepository:  userRepo,
+	}
+}
+
+//Create returns the token with its plaintext set, it cannot be recovered afterwards
+func (service *personalTokenService) Create(userID uint64, b dto.PersonalTokenCreateDTO) (model.PersonalAccessToken, error) {
+	user := service.userRepository.FindByID(userID)
+	for _, scope := range b.Scopes {
+		// a token may never manage tokens, sessions or two-factor settings
+		if scope == model.PermProfileWrite || !model.RolesAllow([]string{user.Role}, scope) {
+			return model.PersonalAccessToken{}, helper.UnprocessableError("Scope cannot be granted", errors.New(scope))
+		}
+	}
+	days := b.ExpiresInDays
+	if days == 0 {
+		days = defaultTokenDays
+	}
+	raw := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return model.PersonalAccessToken{}, err
+	}
+	plain := PersonalTokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	token := service.tokenRepository.InsertToken(model.PersonalAccessToken{
+		UserID:    userID,
+		Name:      b.Name,
+		Prefix:    plain[:len(PersonalTokenPrefix)+6],
+		TokenHash: hashToken(plain),
+		Scopes:    strings.Join(b.Scopes, " "),
+		ExpiresAt: time.Now().AddDate(0, 0, days),
+	})
+	token.Token = plain
+	return token, nil
+}
+
+func (service *personalTokenService) FindByUserID(userID uint64) []model.PersonalAccessToken {
+	return service.tokenRepository.FindTokensByUserID(userID)
+}
+
+func (service *personalTokenService) Revoke(userID, tokenID uint64) bool {
+	return service.tokenRepository.RevokeToken(userID, tokenID)
+}
+
+//Authenticate resolves a personal access token to a principal limited to the token's scopes
+func (service *personalTokenService) Authenticate(plain string) (helper.Principal, error) {
+	token := service.tokenRepository.FindTokenByHash(hashToken(plain))
+	now := time.Now()
+	if token.ID == 0 || token.RevokedAt != nil || now.After(token.ExpiresAt) || token.User.DisabledAt != nil {
+		return helper.Principal{}, ErrInvalidPersonalToken
+	}
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > tokenTouchInterval {
+		service.tokenRepository.TouchToken(token.ID, now)
+	}
+	return helper.Principal{
+		UserID:  token.UserID,
+		TokenID: token.ID,
+		Roles:   []string{token.User.Role},
+		Scopes:  strings.Fields(token.Scopes),
+	}, nil
+}
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/myomyintko/strategy_robot/model"
+	"github.com/myomyintko/strategy_robot/repository"
+)
+
+//historyLimit caps how many snapshots one history request returns
+const historyLimit = 5000
+
+//PortfolioService values the balances of bound keys and keeps their equity history
+type PortfolioService interface {
+	//Valuate values the live balances of the key client belongs to in quote
+	Valuate(ctx context.Context, client *binance.Client, quote string) (model.Portfolio, error)
+	//Snapshot stores the valued balances of every bound key
+	Snapshot(ctx context.Context)
+	Watch(ctx context.Context, interval time.Duration)
+	History(userID uint64, quote string, from, to time.Time) model.PortfolioHistory
+}
+
+type portfolioService struct {
+	portfolioRepository repository.PortfolioRepository
+	apiService          APIService
+	clients             ClientRegistry
+	caller              BinanceCaller
+	market              MarketDataService
+}
+
+//NewPortfolioService creates a new instance of PortfolioService
+func NewPortfolioService(portfolioRepo repository.PortfolioRepository, apiServ APIService, clients ClientRegistry, caller BinanceCaller, market MarketDataService) PortfolioService {
+	return &portfolioService{
+		portfolioRepository: portfolioRepo,
+		apiService:          apiServ,
+		clients:             clients,
+		caller:              caller,
+		market:              market,
+	}
+}
+
+func (service *portfolioService) Valuate(ctx context.Context, client *binance.Client, quote string) (model.Portfolio, error) {
+	prices, err := loadPrices(ctx, service.caller, client, service.market)
+	if err != nil {
+		return model.Portfolio{}, err
+	}
+	balances, err := loadBalances(ctx, service.caller, client, prices)
+	if err != nil {
+		return model.Portfolio{}, err
+	}
+	portfolio := model.Portfolio{QuoteAsset: quote, Assets: []model.Allocation{}, ValuedAt: time.Now()}
+	for _, balance := range balances {
+		portfolio.Value += balance.Value(quote)
+		portfolio.Assets = append(portfolio.Assets, model.Allocation{
+			Asset:  balance.Asset,
+			Free:   balance.Free,
+			Locked: balance.Locked,
+			Value:  balance.Value(quote),
+		})
+	}
+	for i := range portfolio.Assets {
+		if portfolio.Value > 0 {
+			portfolio.Assets[i].Share = portfolio.Assets[i].Value / portfolio.Value
+		}
+	}
+	sort.SliceStable(portfolio.Assets, func(i, j int) bool {
+		return portfolio.Assets[i].Value > portfolio.Assets[j].Value
+	})
+	return portfolio, nil
+}
+
+func (service *portfolioService) Snapshot(ctx context.Context) {
+	// tickers are public, they are loaded once per pass with the first key that works
+	var prices priceBook
+	for _, key := range service.apiService.All() {
+		if ctx.Err() != nil {
+			return
+		}
+		client, err := service.clients.ForUser(key.UserID)
+		if err != nil {
+			continue
+		}
+		if prices == nil {
+			if prices, err = loadPrices(ctx, service.caller, client, service.market); err != nil {
+				log.Printf("Failed to load prices for portfolio snapshots: %v", err)
+				return
+			}
+		}
+		balances, err := loadBalances(ctx, service.caller, client, prices)
+		if err != nil {
+			log.Printf("Failed to snapshot portfolio of user %d: %v", key.UserID, err)
+			continue
+		}
+		snapshot := model.PortfolioSnapshot{UserID: key.UserID, APIID: key.ID, Balances: balances, TakenAt: time.Now()}
+		for _, balance := range balances {
+			snapshot.ValueUSDT += balance.ValueUSDT
+			snapshot.ValueBTC += balance.ValueBTC
+		}
+		service.portfolioRepository.InsertSnapshot(snapshot)
+	}
+}
+
+//Watch runs Snapshot every interval until ctx is done
+func (service *portfolioService) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			service.Snapshot(ctx)
+		}
+	}
+}
+
+//History is the equity of userID in quote between from and to, oldest first. A range past
+//historyLimit keeps its newest points
+func (service *portfolioService) History(userID uint64, quote string, from, to time.Time) model.PortfolioHistory {
+	history := model.PortfolioHistory{Points: []model.PortfolioPoint{}, Limit: historyLimit}
+	// one snapshot more than the limit tells whether older ones were left out
+	snapshots := service.portfolioRepository.FindSnapshots(userID, from, to, historyLimit+1)
+	if len(snapshots) > historyLimit {
+		snapshots = snapshots[1:]
+		history.Truncated = true
+	}
+	for _, snapshot := range snapshots {
+		history.Points = append(history.Points, model.PortfolioPoint{TakenAt: snapshot.TakenAt, Value: snapshot.Value(quote)})
+	}
+	return history
+}
+
+//loadBalances lists the non-empty balances of the key client belongs to, valued with prices
+func loadBalances(ctx context.Context, caller BinanceCaller, client *binance.Client, prices priceBook) ([]model.PortfolioBalance, error) {
+	var account *binance.Account
+	err := caller.Call(ctx, client, true, func(client *binance.Client) (err error) {
+		account, err = client.NewGetAccountService().Do(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, ExchangeError("Failed to load account", err)
+	}
+	var balances []model.PortfolioBalance
+	for _, b := range account.Balances {
+		free, _ := strconv.ParseFloat(b.Free, 64)
+		locked, _ := strconv.ParseFloat(b.Locked, 64)
+		if free+locked == 0 {
+			continue
+		}
+		valueUSDT, valueBTC := prices.value(b.Asset, free+locked)
+		balances = append(balances, model.PortfolioBalance{
+			Asset:     b.Asset,
+			Free:      free,
+			Locked:    locked,
+			ValueUSDT: valueUSDT,
+			ValueBTC:  valueBTC,
+		})
+	}
+	return balances, nil
+}
+
+//loadPrices is the last price of every symbol, from market while its streams are fresh and from REST otherwise
+func loadPrices(ctx context.Context, caller BinanceCaller, client *binance.Client, market MarketDataService) (priceBook, error) {
+	if prices, ok := market.Prices(); ok {
+		return prices, nil
+	}
+	var res []*binance.SymbolPrice
+	err := caller.Call(ctx, client, true, func(client *binance.Client) (err error) {
+		res, err = client.NewListPricesService().Do(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, ExchangeError("Failed to load prices", err)
+	}
+	prices := priceBook{}
+	for _, p := range res {
+		if price, err := strconv.ParseFloat(p.Price, 64); err == nil && price > 0 {
+			prices[p.Symbol] = price
+		}
+	}
+	return prices, nil
+}
+
+//priceBook is the last price of every symbol
+type priceBook map[string]float64
+
+//value is amount of asset in USDT and in BTC, zero when Binance has no market to price it
+func (prices priceBook) value(asset string, amount float64) (float64, float64) {
+	usdt := amount * prices.inUSDT(asset)
+	switch {
+	case asset == model.QuoteBTC:
+		return usdt, amount
+	case prices["BTCUSDT"] > 0:
+		return usdt, usdt / prices["BTCUSDT"]
+	}
+	return usdt, 0
+}
+
+func (prices priceBook) inUSDT(asset string) float64 {
+	if asset == model.QuoteUSDT {
+		return 1
+	}
+	if price, ok := prices[asset+model.QuoteUSDT]; ok {
+		return price
+	}
+	if price, ok := prices[model.QuoteUSDT+asset]; ok {
+		return 1 / price
+	}
+	if price, ok := prices[asset+model.QuoteBTC]; ok {
+		return price * prices["BTCUSDT"]
+	}
+	return 0
+}
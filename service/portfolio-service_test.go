@@ -0,0 +1,40 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/myomyintko/strategy_robot/model"
+	"github.com/myomyintko/strategy_robot/repository"
+	"gorm.io/gorm"
+)
+
+func TestHistoryPastTheLimitKeepsTheNewestPoints(t *testing.T) {
+	db := newTestDB(t)
+	user := model.User{Name: "alice", Email: "alice@example.com", Password: "hash", Role: model.RoleTrader}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	snapshots := make([]model.PortfolioSnapshot, historyLimit+10)
+	for i := range snapshots {
+		snapshots[i] = model.PortfolioSnapshot{UserID: user.ID, APIID: 1, ValueUSDT: float64(i), TakenAt: start.Add(time.Duration(i) * time.Minute)}
+	}
+	if err := db.Session(&gorm.Session{CreateBatchSize: 500}).Create(&snapshots).Error; err != nil {
+		t.Fatal(err)
+	}
+	service := NewPortfolioService(repository.NewPortfolioRepository(db), nil, nil, nil, nil)
+
+	history := service.History(user.ID, model.QuoteUSDT, start, start.Add(time.Duration(len(snapshots))*time.Minute))
+	if !history.Truncated || history.Limit != historyLimit || len(history.Points) != historyLimit {
+		t.Fatalf("history of %d snapshots: truncated %v, limit %d, %d points", len(snapshots), history.Truncated, history.Limit, len(history.Points))
+	}
+	if first, last := history.Points[0].Value, history.Points[len(history.Points)-1].Value; first != 10 || last != float64(len(snapshots)-1) {
+		t.Errorf("history runs from value %v to %v, want the newest points oldest first", first, last)
+	}
+
+	short := service.History(user.ID, model.QuoteUSDT, start, start.Add(time.Hour))
+	if short.Truncated || len(short.Points) != 61 {
+		t.Errorf("history of an hour: truncated %v, %d points", short.Truncated, len(short.Points))
+	}
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Rate is a token bucket holding up to Burst requests and refilled with Burst
+// requests every Per
+type Rate struct {
+	Burst int
+	Per   time.Duration
+}
+
+// PerMinute allows n requests a minute with bursts of up to n
+func PerMinute(n int) Rate {
+	return Rate{Burst: n, Per: time.Minute}
+}
+
+// RateLimitStore keeps the buckets, the in-memory store serves a single
+// instance and a shared store can implement the same contract
+type RateLimitStore interface {
+	// Take spends one request from the bucket under key, when it is empty it
+	// returns false and how long until a request is available again
+	Take(key string, rate Rate) (bool, time.Duration)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+type memoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// buckets idle this long are full again and can be dropped
+const rateLimitSweepInterval = 10 * time.Minute
+
+//NewMemoryRateLimitStore creates a RateLimitStore local to this process
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{
+		buckets: make(map[string]*bucket),
+		swept:   time.Now(),
+	}
+}
+
+func (store *memoryRateLimitStore) Take(key string, rate Rate) (bool, time.Duration) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	now := time.Now()
+	if now.Sub(store.swept) > rateLimitSweepInterval {
+		store.sweep(now)
+	}
+
+	perSecond := float64(rate.Burst) / rate.Per.Seconds()
+	b, ok := store.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rate.Burst), updated: now}
+		store.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(rate.Burst), b.tokens+now.Sub(b.updated).Seconds()*perSecond)
+	b.updated = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+func (store *memoryRateLimitStore) sweep(now time.Time) {
+	for key, b := range store.buckets {
+		if now.Sub(b.updated) > rateLimitSweepInterval {
+			delete(store.buckets, key)
+		}
+	}
+	store.swept = now
+}
@@ -0,0 +1,391 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/myomyintko/strategy_robot/dto"
+	"github.com/myomyintko/strategy_robot/helper"
+	"github.com/myomyintko/strategy_robot/model"
+	"github.com/myomyintko/strategy_robot/repository"
+)
+
+//ErrNotRebalanceRobot is returned when a plan is asked of a symbol robot
+var ErrNotRebalanceRobot = helper.UnprocessableError("Robot does not rebalance", nil)
+
+//rebalanceSlippage prices rebalance orders past the last price so they fill at once
+const rebalanceSlippage = 0.005
+
+//Rebalancer returns rebalance robots to their target allocation. Each asset only moves one
+//way per run, overweight assets are sold straight into underweight ones when Binance lists
+//the pair and through the quote asset of the robot otherwise
+type Rebalancer interface {
+	//Plan is what robot would trade right now
+	Plan(ctx context.Context, client *binance.Client, robot model.Robot) (model.RebalancePlan, error)
+	//Check rebalances every robot that is due or drifted past its threshold. It starts no robot
+	//and no order once stop is done, the order being placed then still finishes under ctx
+	Check(stop, ctx context.Context)
+	//Watch runs Check every interval until stop is done
+	Watch(stop, ctx context.Context, interval time.Duration)
+}
+
+type rebalancer struct {
+	robotRepository   repository.RobotRepository
+	binanceRepository repository.BinanceRepository
+	binanceService    BinanceService
+	clients           ClientRegistry
+	caller            BinanceCaller
+	market            MarketDataService
+}
+
+//NewRebalancer creates a new instance of Rebalancer placing its orders through binServ
+func NewRebalancer(robotRepo repository.RobotRepository, binRepo repository.BinanceRepository, binServ BinanceService, clients ClientRegistry, caller BinanceCaller, market MarketDataService) Rebalancer {
+	return &rebalancer{
+		robotRepository:   robotRepo,
+		binanceRepository: binRepo,
+		binanceService:    binServ,
+		clients:           clients,
+		caller:            caller,
+		market:            market,
+	}
+}
+
+func (r *rebalancer) Plan(ctx context.Context, client *binance.Client, robot model.Robot) (model.RebalancePlan, error) {
+	if robot.Kind != model.RobotKindRebalance {
+		return model.RebalancePlan{}, ErrNotRebalanceRobot
+	}
+	prices, err := loadPrices(ctx, r.caller, client, r.market)
+	if err != nil {
+		return model.RebalancePlan{}, err
+	}
+	markets, err := loadMarkets(ctx, r.caller, client)
+	if err != nil {
+		return model.RebalancePlan{}, err
+	}
+	return r.plan(ctx, client, robot, prices, markets)
+}
+
+func (r *rebalancer) Check(stop, ctx context.Context) {
+	// prices and markets are public, they are loaded once per pass with the first key that works
+	var prices priceBook
+	var markets marketBook
+	for _, robot := range r.robotRepository.AllRobot() {
+		if robot.Kind != model.RobotKindRebalance {
+			continue
+		}
+		if stop.Err() != nil || ctx.Err() != nil {
+			return
+		}
+		if open := r.binanceRepository.FindUnsettledOrdersOfRobot(robot.ID); len(open) > 0 {
+			// the balances do not show what those orders will still trade, planning now
+			// would trade the same drift again
+			log.Printf("Skipped rebalancing robot %d, %d of its orders are still open", robot.ID, len(open))
+			continue
+		}
+		client, err := r.clients.ForUser(robot.UserID)
+		if err != nil {
+			continue
+		}
+		if prices == nil {
+			if prices, err = loadPrices(ctx, r.caller, client, r.market); err != nil {
+				log.Printf("Failed to load prices for rebalancing: %v", err)
+				return
+			}
+			if markets, err = loadMarkets(ctx, r.caller, client); err != nil {
+				log.Printf("Failed to load markets for rebalancing: %v", err)
+				return
+			}
+		}
+		plan, err := r.plan(ctx, client, robot, prices, markets)
+		if err != nil {
+			log.Printf("Failed to plan rebalance of robot %d: %v", robot.ID, err)
+			continue
+		}
+		due := robot.IntervalMinutes > 0 && (robot.RebalancedAt == nil ||
+			time.Since(*robot.RebalancedAt) >= time.Duration(robot.IntervalMinutes)*time.Minute)
+		drifted := robot.Threshold > 0 && plan.Drift >= robot.Threshold
+		if due || drifted {
+			r.execute(stop, ctx, client, robot, plan)
+		}
+	}
+}
+
+func (r *rebalancer) Watch(stop, ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop.Done():
+			return
+		case <-ticker.C:
+			r.Check(stop, ctx)
+		}
+	}
+}
+
+//execute places the trades of plan, sells first so buys routed through the quote asset can pay.
+//Once stop is done the remaining trades are left to the run resumed after the restart
+func (r *rebalancer) execute(stop, ctx context.Context, client *binance.Client, robot model.Robot, plan model.RebalancePlan) {
+	run := r.robotRepository.StartRebalanceRun(robot.ID, time.Now())
+	for _, trade := range plan.Trades {
+		if trade.Skipped != "" {
+			continue
+		}
+		if stop.Err() != nil {
+			log.Printf("Stopped rebalancing robot %d after %d orders, shutting down", robot.ID, run.Placed)
+			return
+		}
+		_, err := r.binanceService.PlaceOrder(ctx, client, dto.CreateOrderDTO{
+			// a run places each symbol and side once, also when it is resumed
+			IdempotencyKey: fmt.Sprintf("rebalance-%d-%d-%s-%s", robot.ID, run.ID, trade.Symbol, trade.Side),
+			Symbol:         trade.Symbol,
+			Side:           trade.Side,
+			Price:          trade.Price,
+			Quantity:       trade.Quantity,
+			RobotID:        robot.ID,
+			UserID:         robot.UserID,
+		})
+		switch {
+		case errors.Is(err, ErrIdempotencyKeyReused):
+			// the run placed this leg before it was cut short, the new plan sized it anew
+			run.Placed++
+		case err != nil:
+			log.Printf("Failed to rebalance robot %d with %s %s %s: %v", robot.ID, trade.Side, trade.Quantity, trade.Symbol, err)
+			run.Failed++
+		default:
+			run.Placed++
+		}
+	}
+	// a run whose every order failed leaves the robot due, the next check tries again
+	rebalanced := run.Placed > 0 || run.Failed == 0
+	if err := r.robotRepository.FinishRebalanceRun(run, time.Now(), rebalanced); err != nil {
+		log.Printf("Failed to finish rebalance run %d of robot %d: %v", run.ID, robot.ID, err)
+		return
+	}
+	if !rebalanced {
+		log.Printf("Failed to rebalance robot %d of user %d, all %d orders failed", robot.ID, robot.UserID, run.Failed)
+		return
+	}
+	log.Printf("Rebalanced robot %d of user %d with %d orders at a drift of %.2f points", robot.ID, robot.UserID, run.Placed, plan.Drift)
+}
+
+//plan compares the balances of the targets with their weights and turns the difference into trades
+func (r *rebalancer) plan(ctx context.Context, client *binance.Client, robot model.Robot, prices priceBook, markets marketBook) (model.RebalancePlan, error) {
+	balances, err := loadBalances(ctx, r.caller, client, prices)
+	if err != nil {
+		return model.RebalancePlan{}, err
+	}
+	held := map[string]model.PortfolioBalance{}
+	for _, balance := range balances {
+		held[balance.Asset] = balance
+	}
+	plan := model.RebalancePlan{Assets: []model.AssetDrift{}, Trades: []model.RebalanceTrade{}}
+	for _, target := range robot.Targets {
+		if prices.inUSDT(target.Asset) == 0 {
+			return model.RebalancePlan{}, helper.UnprocessableError("Target has no price", fmt.Errorf("Binance has no market pricing %s", target.Asset))
+		}
+		plan.Value += held[target.Asset].ValueUSDT
+	}
+	if plan.Value == 0 {
+		return plan, nil
+	}
+
+	// flows are the USDT worth each asset has to sell or buy, sells are capped by the free balance
+	var sells, buys []flow
+	var sold, bought float64
+	for _, target := range robot.Targets {
+		balance := held[target.Asset]
+		current := balance.ValueUSDT / plan.Value * 100
+		plan.Assets = append(plan.Assets, model.AssetDrift{Asset: target.Asset, Target: target.Weight, Current: current, Value: balance.ValueUSDT})
+		plan.Drift = math.Max(plan.Drift, math.Abs(current-target.Weight))
+		delta := target.Weight/100*plan.Value - balance.ValueUSDT
+		if delta > 0 {
+			buys = append(buys, flow{target.Asset, delta})
+			bought += delta
+		} else if delta < 0 {
+			delta = math.Min(-delta, balance.Free*prices.inUSDT(target.Asset))
+			sells = append(sells, flow{target.Asset, delta})
+			sold += delta
+		}
+	}
+	turnover := math.Min(sold, bought)
+	if limit := robot.MaxTurnover / 100 * plan.Value; robot.MaxTurnover > 0 && turnover > limit {
+		sells = scale(sells, limit/turnover)
+		buys = scale(buys, limit/turnover)
+	}
+
+	// the largest seller pays the largest buyer until either is settled
+	sort.SliceStable(sells, func(i, j int) bool { return sells[i].value > sells[j].value })
+	sort.SliceStable(buys, func(i, j int) bool { return buys[i].value > buys[j].value })
+	legs := &legBook{index: map[string]int{}}
+	for i, j := 0, 0; i < len(sells) && j < len(buys); {
+		value := math.Min(sells[i].value, buys[j].value)
+		if reason := legs.route(markets, robot.QuoteAsset, sells[i].asset, buys[j].asset, value); reason != "" {
+			plan.Trades = append(plan.Trades, model.RebalanceTrade{
+				Symbol:  sells[i].asset + "/" + buys[j].asset,
+				Value:   value,
+				Skipped: reason,
+			})
+		}
+		sells[i].value -= value
+		buys[j].value -= value
+		if sells[i].value <= 0 {
+			i++
+		}
+		if buys[j].value <= 0 {
+			j++
+		}
+	}
+	sort.SliceStable(legs.legs, func(i, j int) bool {
+		return legs.legs[i].side == binance.SideTypeSell && legs.legs[j].side == binance.SideTypeBuy
+	})
+	for _, leg := range legs.legs {
+		plan.Trades = append(plan.Trades, leg.trade(prices, held[leg.market.BaseAsset].Free))
+	}
+	return plan, nil
+}
+
+//flow is the USDT worth an asset sells or buys
+type flow struct {
+	asset string
+	value float64
+}
+
+func scale(flows []flow, factor float64) []flow {
+	for i := range flows {
+		flows[i].value *= factor
+	}
+	return flows
+}
+
+//marketBook holds the trading symbols by "BASE/QUOTE"
+type marketBook map[string]binance.Symbol
+
+//between is the symbol and side that turn from into to
+func (markets marketBook) between(from, to string) (binance.Symbol, binance.SideType, bool) {
+	if market, ok := markets[from+"/"+to]; ok {
+		return market, binance.SideTypeSell, true
+	}
+	if market, ok := markets[to+"/"+from]; ok {
+		return market, binance.SideTypeBuy, true
+	}
+	return binance.Symbol{}, "", false
+}
+
+func loadMarkets(ctx context.Context, caller BinanceCaller, client *binance.Client) (marketBook, error) {
+	var info *binance.ExchangeInfo
+	err := caller.Call(ctx, client, true, func(client *binance.Client) (err error) {
+		info, err = client.NewExchangeInfoService().Do(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, ExchangeError("Failed to load markets", err)
+	}
+	markets := marketBook{}
+	for _, market := range info.Symbols {
+		if market.Status == "TRADING" {
+			markets[market.BaseAsset+"/"+market.QuoteAsset] = market
+		}
+	}
+	return markets, nil
+}
+
+//leg is the USDT worth traded on one symbol and side, transfers sharing it are merged into one order
+type leg struct {
+	market binance.Symbol
+	side   binance.SideType
+	value  float64
+}
+
+type legBook struct {
+	legs  []*leg
+	index map[string]int
+}
+
+//route adds the legs turning value of from into to, directly or through hub. It returns
+//why nothing was added when neither way is listed
+func (book *legBook) route(markets marketBook, hub, from, to string, value float64) string {
+	if market, side, ok := markets.between(from, to); ok {
+		book.add(market, side, value)
+		return ""
+	}
+	sell, sellSide, sellOK := markets.between(from, hub)
+	buy, buySide, buyOK := markets.between(hub, to)
+	if from == hub || to == hub || !sellOK || !buyOK {
+		return fmt.Sprintf("no market between %s and %s", from, to)
+	}
+	book.add(sell, sellSide, value)
+	book.add(buy, buySide, value)
+	return ""
+}
+
+func (book *legBook) add(market binance.Symbol, side binance.SideType, value float64) {
+	key := market.Symbol + string(side)
+	if i, ok := book.index[key]; ok {
+		book.legs[i].value += value
+		return
+	}
+	book.index[key] = len(book.legs)
+	book.legs = append(book.legs, &leg{market: market, side: side, value: value})
+}
+
+//trade sizes the leg in the base asset and applies the lot size, price and min notional filters,
+//a sell never exceeds free
+func (l *leg) trade(prices priceBook, free float64) model.RebalanceTrade {
+	trade := model.RebalanceTrade{Symbol: l.market.Symbol, Side: string(l.side), Value: l.value}
+	last, basePrice := prices[l.market.Symbol], prices.inUSDT(l.market.BaseAsset)
+	if last == 0 || basePrice == 0 {
+		trade.Skipped = "no price for " + l.market.Symbol
+		return trade
+	}
+	quantity := l.value / basePrice
+	price := last * (1 + rebalanceSlippage)
+	if l.side == binance.SideTypeSell {
+		quantity = math.Min(quantity, free)
+		price = last * (1 - rebalanceSlippage)
+	}
+	var minQuantity, minNotional float64
+	step, tick := "", ""
+	if f := l.market.LotSizeFilter(); f != nil {
+		step = f.StepSize
+		minQuantity, _ = strconv.ParseFloat(f.MinQuantity, 64)
+	}
+	if f := l.market.PriceFilter(); f != nil {
+		tick = f.TickSize
+	}
+	if f := l.market.MinNotionalFilter(); f != nil {
+		minNotional, _ = strconv.ParseFloat(f.MinNotional, 64)
+	}
+	trade.Quantity, quantity = floorTo(quantity, step)
+	trade.Price, price = floorTo(price, tick)
+	switch {
+	case quantity <= 0 || quantity < minQuantity:
+		trade.Skipped = "below the minimum quantity"
+	case quantity*price < minNotional:
+		trade.Skipped = "below the minimum notional"
+	}
+	return trade
+}
+
+//floorTo rounds v down to a multiple of step and formats it with the decimals of step
+func floorTo(v float64, step string) (string, float64) {
+	size, err := strconv.ParseFloat(step, 64)
+	if err != nil || size <= 0 {
+		return strconv.FormatFloat(v, 'f', -1, 64), v
+	}
+	decimals := 0
+	if i := strings.IndexByte(step, '.'); i >= 0 {
+		decimals = len(strings.TrimRight(step[i+1:], "0"))
+	}
+	formatted := strconv.FormatFloat(math.Floor(v/size+1e-9)*size, 'f', decimals, 64)
+	rounded, _ := strconv.ParseFloat(formatted, 64)
+	return formatted, rounded
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/myomyintko/strategy_robot/dto"
+	"github.com/myomyintko/strategy_robot/model"
+	"github.com/myomyintko/strategy_robot/repository"
+)
+
+//recordingService keeps the idempotency keys it is asked to place and fails each order with err
+type recordingService struct {
+	BinanceService
+	keys []string
+	err  error
+}
+
+func (s *recordingService) PlaceOrder(ctx context.Context, client *binance.Client, o dto.CreateOrderDTO) (model.Order, error) {
+	s.keys = append(s.keys, o.IdempotencyKey)
+	return model.Order{}, s.err
+}
+
+//refusingRegistry fails t when a client is asked for
+type refusingRegistry struct {
+	t *testing.T
+}
+
+func (r refusingRegistry) ForUser(userID uint64) (*binance.Client, error) {
+	r.t.Errorf("client of user %d was asked for", userID)
+	return nil, errors.New("no client")
+}
+
+func (r refusingRegistry) Invalidate(uint64) {}
+
+func newTestRebalancer(t *testing.T, orders *recordingService) (*rebalancer, model.Robot) {
+	t.Helper()
+	db := newTestDB(t)
+	user := model.User{Name: "alice", Email: "alice@example.com", Password: "hash", Role: model.RoleTrader}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	robots := repository.NewRobotRepository(db)
+	robot := robots.InsertRobot(model.Robot{Kind: model.RobotKindRebalance, Threshold: 1, UserID: user.ID})
+	r := NewRebalancer(robots, repository.NewBinanceRepository(db), orders, refusingRegistry{t}, nil, nil).(*rebalancer)
+	return r, robot
+}
+
+var testPlan = model.RebalancePlan{Trades: []model.RebalanceTrade{
+	{Symbol: "BTCUSDT", Side: "SELL", Quantity: "0.1", Price: "1"},
+	{Symbol: "ETHUSDT", Side: "BUY", Quantity: "1", Price: "1"},
+}}
+
+func TestRunWhoseOrdersAllFailIsRetriedUnderItsRunID(t *testing.T) {
+	orders := &recordingService{err: errors.New("exchange down")}
+	r, robot := newTestRebalancer(t, orders)
+	ctx := context.Background()
+
+	r.execute(ctx, ctx, nil, robot, testPlan)
+	if got := r.robotRepository.FindRobotByID(robot.UserID, robot.ID); got.RebalancedAt != nil {
+		t.Errorf("robot whose orders all failed was marked rebalanced at %v", got.RebalancedAt)
+	}
+	orders.err = nil
+	r.execute(ctx, ctx, nil, robot, testPlan)
+	if got := r.robotRepository.FindRobotByID(robot.UserID, robot.ID); got.RebalancedAt == nil {
+		t.Error("robot was not marked rebalanced once its orders were placed")
+	}
+
+	if len(orders.keys) != 4 {
+		t.Fatalf("placed %d orders, want 4", len(orders.keys))
+	}
+	for _, key := range orders.keys {
+		if !strings.HasPrefix(key, "rebalance-") {
+			t.Errorf("key %q is not a rebalance key", key)
+		}
+	}
+	if orders.keys[0] == orders.keys[2] {
+		t.Errorf("two runs placed under the same key %q", orders.keys[0])
+	}
+}
+
+func TestRobotWithOpenOrdersIsNotRebalanced(t *testing.T) {
+	orders := &recordingService{}
+	r, robot := newTestRebalancer(t, orders)
+	if _, err := r.binanceRepository.InsertOrder(model.Order{ClientOrderId: "sr-open", Symbol: "BTCUSDT", Status: "NEW", UserID: robot.UserID, RobotID: robot.ID}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	r.Check(ctx, ctx)
+	if len(orders.keys) != 0 {
+		t.Errorf("robot with an open order placed %d more", len(orders.keys))
+	}
+}
@@ -1,20 +1,23 @@
 package service
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
 
-	"github.com/mashingan/smapping"
 	"github.com/myomyintko/strategy_robot/dto"
+	"github.com/myomyintko/strategy_robot/helper"
 	"github.com/myomyintko/strategy_robot/model"
 	"github.com/myomyintko/strategy_robot/repository"
 )
 
 type RobotService interface {
-	Insert(b dto.RobotCreateDTO) model.Robot
-	Update(b dto.RobotUpdateDTO) model.Robot
+	Insert(b dto.RobotCreateDTO) (model.Robot, error)
+	Update(b dto.RobotUpdateDTO) (model.Robot, error)
 	Delete(b model.Robot)
 	All() []model.Robot
-	FindByID(robotID uint64) model.Robot
+	FindByID(userID, robotID uint64) model.Robot
 	FindByUserID(userID uint64) model.Robot
 	IsUserExistRobot(symbol string, userID uint64) bool
 	IsAllowedToEdit(userID, robotID uint64) bool
@@ -30,24 +33,70 @@ func NewRobotService(robotRepo repository.RobotRepository) RobotService {
 	}
 }
 
-func (service *robotService) Insert(b dto.RobotCreateDTO) model.Robot {
-	robot := model.Robot{}
-	err := smapping.FillStruct(&robot, smapping.MapFields(&b))
+func (service *robotService) Insert(b dto.RobotCreateDTO) (model.Robot, error) {
+	robot, err := robotOf(b.RobotSettingsDTO)
 	if err != nil {
-		log.Fatalf("Failed map %v: ", err)
+		return model.Robot{}, err
 	}
+	robot.UserID = b.UserID
 	res := service.robotRepository.InsertRobot(robot)
-	return res
+	return res, nil
 }
 
-func (service *robotService) Update(b dto.RobotUpdateDTO) model.Robot {
-	robot := model.Robot{}
-	err := smapping.FillStruct(&robot, smapping.MapFields(&b))
+func (service *robotService) Update(b dto.RobotUpdateDTO) (model.Robot, error) {
+	robot, err := robotOf(b.RobotSettingsDTO)
 	if err != nil {
-		log.Fatalf("Failed map %v: ", err)
+		return model.Robot{}, err
 	}
+	robot.ID = b.ID
+	robot.UserID = b.UserID
 	res := service.robotRepository.UpdateRobot(robot)
-	return res
+	if res.ID == 0 {
+		return model.Robot{}, helper.NotFoundError("Robot not found", errors.New("Invalid user or no robot"))
+	}
+	return res, nil
+}
+
+//robotOf builds a robot of the given kind, a rebalance robot needs at least two distinct
+//assets weighing 100 together and a threshold or an interval to run on
+func robotOf(b dto.RobotSettingsDTO) (model.Robot, error) {
+	if b.Kind != model.RobotKindRebalance {
+		if b.Symbol == "" {
+			return model.Robot{}, helper.ValidationError("Symbol is required", errors.New("A symbol robot needs a symbol"))
+		}
+		return model.Robot{Kind: model.RobotKindSymbol, Symbol: strings.ToUpper(b.Symbol)}, nil
+	}
+	robot := model.Robot{
+		Kind:            model.RobotKindRebalance,
+		QuoteAsset:      strings.ToUpper(b.QuoteAsset),
+		Threshold:       b.Threshold,
+		IntervalMinutes: b.IntervalMinutes,
+		MaxTurnover:     b.MaxTurnover,
+	}
+	if robot.QuoteAsset == "" {
+		robot.QuoteAsset = model.QuoteUSDT
+	}
+	if len(b.Targets) < 2 {
+		return model.Robot{}, helper.ValidationError("Invalid targets", errors.New("A rebalance robot needs at least two targets"))
+	}
+	seen := map[string]bool{}
+	total := 0.0
+	for _, target := range b.Targets {
+		asset := strings.ToUpper(target.Asset)
+		if seen[asset] {
+			return model.Robot{}, helper.ValidationError("Invalid targets", fmt.Errorf("%s is targeted twice", asset))
+		}
+		seen[asset] = true
+		total += target.Weight
+		robot.Targets = append(robot.Targets, model.RobotTarget{Asset: asset, Weight: target.Weight})
+	}
+	if math.Abs(total-100) > 0.01 {
+		return model.Robot{}, helper.ValidationError("Invalid targets", fmt.Errorf("Weights add up to %g instead of 100", total))
+	}
+	if robot.Threshold == 0 && robot.IntervalMinutes == 0 {
+		return model.Robot{}, helper.ValidationError("Invalid schedule", errors.New("Set a threshold, an interval or both"))
+	}
+	return robot, nil
 }
 
 func (service *robotService) Delete(b model.Robot) {
@@ -58,8 +107,8 @@ func (service *robotService) All() []model.Robot {
 	return service.robotRepository.AllRobot()
 }
 
-func (service *robotService) FindByID(robotID uint64) model.Robot {
-	return service.robotRepository.FindRobotByID(robotID)
+func (service *robotService) FindByID(userID, robotID uint64) model.Robot {
+	return service.robotRepository.FindRobotByID(userID, robotID)
 }
 
 func (service *robotService) FindByUserID(userID uint64) model.Robot {
@@ -73,7 +122,6 @@ func (service *robotService) IsUserExistRobot(symbol string, userID uint64) bool
 }
 
 func (service *robotService) IsAllowedToEdit(userID, robotID uint64) bool {
-	robot := service.robotRepository.FindRobotByID(robotID)
-	id := robot.UserID
-	return userID == id
+	robot := service.robotRepository.FindRobotByID(userID, robotID)
+	return robot.ID != 0
 }
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+)
+
+// SecretService is a contract of what secretService can do. Secrets are sealed
+// with a random per-secret data key which is in turn wrapped by the master key.
+type SecretService interface {
+	Seal(plain string) (sealed string, dataKey string, err error)
+	Open(sealed string, dataKey string) (string, error)
+}
+
+type secretService struct {
+	masterKey []byte
+}
+
+// NewSecretService creates a new instance of SecretService using a 32 byte master key
+func NewSecretService(masterKey []byte) SecretService {
+	return &secretService{
+		masterKey: masterKey,
+	}
+}
+
+func (s *secretService) Seal(plain string) (string, string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", "", err
+	}
+	sealed, err := gcmSeal(dataKey, []byte(plain))
+	if err != nil {
+		return "", "", err
+	}
+	wrapped, err := gcmSeal(s.masterKey, dataKey)
+	if err != nil {
+		return "", "", err
+	}
+	return sealed, wrapped, nil
+}
+
+func (s *secretService) Open(sealed string, wrapped string) (string, error) {
+	dataKey, err := gcmOpen(s.masterKey, wrapped)
+	if err != nil {
+		return "", err
+	}
+	plain, err := gcmOpen(dataKey, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func gcmSeal(key, plain []byte) (string, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, nil)), nil
+}
+
+func gcmOpen(key []byte, encoded string) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < aead.NonceSize() {
+		return nil, errors.New("sealed secret is too short")
+	}
+	return aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,19 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/myomyintko/strategy_robot/internal/testdb"
+	"github.com/myomyintko/strategy_robot/migration"
+	"gorm.io/gorm"
+)
+
+//newTestDB is a migrated database closed when t ends, see testdb.Open for the drivers it runs on
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := testdb.Open(t)
+	if _, err := migration.Up(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/myomyintko/strategy_robot/helper"
+	"github.com/myomyintko/strategy_robot/model"
+	"github.com/myomyintko/strategy_robot/repository"
+)
+
+// RefreshTokenTTL is how long a session survives without being refreshed
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+var (
+	// ErrInvalidRefreshToken is returned for unknown, expired or revoked refresh tokens
+	ErrInvalidRefreshToken = helper.AuthError("Invalid refresh token", nil)
+	// ErrRefreshTokenReused is returned when a rotated token is presented again, the session is revoked
+	ErrRefreshTokenReused = helper.AuthError("Refresh token reused, session revoked", nil)
+)
+
+//SessionService is a contract of what sessionService can do
+type SessionService interface {
+	Start(userID uint64, userAgent, ip string) (model.Session, string)
+	Refresh(refreshToken, userAgent, ip string) (model.Session, string, error)
+	Revoke(userID, sessionID uint64) bool
+	RevokeAll(userID uint64)
+	RevokeOthers(userID, keepSessionID uint64)
+	IsActive(sessionID uint64) bool
+	FindByUserID(userID uint64) []model.Session
+}
+
+type sessionService struct {
+	sessionRepository repository.SessionRepository
+}
+
+//NewSessionService creates a new instance of SessionService
+func NewSessionService(sessionRepo repository.SessionRepository) SessionService {
+	return &sessionService{
+		sessionRepository: sessionRepo,
+	}
+}
+
+func (service *sessionService) Start(userID uint64, userAgent, ip string) (model.Session, string) {
+	now := time.Now()
+	session := service.sessionRepository.InsertSession(model.Session{
+		UserID:     userID,
+		UserAgent:  userAgent,
+		IP:         ip,
+		ExpiresAt:  now.Add(RefreshTokenTTL),
+		LastUsedAt: now,
+	})
+	return session, service.issue(session)
+}
+
+//Refresh rotates refreshToken, presenting an already rotated token revokes the whole session
+func (service *sessionService) Refresh(refreshToken, userAgent, ip string) (model.Session, string, error) {
+	token := service.sessionRepository.FindRefreshTokenByHash(hashToken(refreshToken))
+	if token.ID == 0 {
+		return model.Session{}, "", ErrInvalidRefreshToken
+	}
+	session := service.sessionRepository.FindSessionByID(token.SessionID)
+	if !isActive(session) {
+		return model.Session{}, "", ErrInvalidRefreshToken
+	}
+	if token.UsedAt != nil || !service.sessionRepository.MarkRefreshTokenUsed(token) {
+		service.sessionRepository.RevokeSession(session.UserID, session.ID)
+		log.Printf("Refresh token reuse on session %d of user %d", session.ID, session.UserID)
+		return model.Session{}, "", ErrRefreshTokenReused
+	}
+
+	now := time.Now()
+	session.UserAgent = userAgent
+	session.IP = ip
+	session.LastUsedAt = now
+	session.ExpiresAt = now.Add(RefreshTokenTTL)
+	session = service.sessionRepository.UpdateSession(session)
+	return session, service.issue(session), nil
+}
+
+func (service *sessionService) Revoke(userID, sessionID uint64) bool {
+	return service.sessionRepository.RevokeSession(userID, sessionID)
+}
+
+func (service *sessionService) RevokeAll(userID uint64) {
+	service.sessionRepository.RevokeSessionsByUserID(userID)
+}
+
+//RevokeOthers ends every session of the user except keepSessionID
+func (service *sessionService) RevokeOthers(userID, keepSessionID uint64) {
+	for _, session := range service.sessionRepository.ActiveSessionsByUserID(userID) {
+		if session.ID != keepSessionID {
+			service.sessionRepository.RevokeSession(userID, session.ID)
+		}
+	}
+}
+
+func (service *sessionService) IsActive(sessionID uint64) bool {
+	return isActive(service.sessionRepository.FindSessionByID(sessionID))
+}
+
+func (service *sessionService) FindByUserID(userID uint64) []model.Session {
+	return service.sessionRepository.ActiveSessionsByUserID(userID)
+}
+
+func (service *sessionService) issue(session model.Session) string {
+	token := randomToken()
+	service.sessionRepository.InsertRefreshToken(model.RefreshToken{
+		SessionID: session.ID,
+		TokenHash: hashToken(token),
+	})
+	return token
+}
+
+func isActive(session model.Session) bool {
+	return session.ID != 0 && session.RevokedAt == nil && session.ExpiresAt.After(time.Now())
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
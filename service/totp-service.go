@@ -0,0 +1,174 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/myomyintko/strategy_robot/helper"
+	"github.com/myomyintko/strategy_robot/model"
+	"github.com/myomyintko/strategy_robot/repository"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	totpIssuer        = "strategy_robot"
+	totpPeriod        = 30
+	recoveryCodeCount = 10
+)
+
+var (
+	// ErrTOTPRequired is returned when an action needs a code but the user has not enabled TOTP
+	ErrTOTPRequired = helper.ForbiddenError("Two-factor authentication must be enabled", nil)
+	// ErrTOTPInvalid is returned for wrong, expired or replayed codes
+	ErrTOTPInvalid = helper.AuthError("Invalid two-factor code", nil)
+)
+
+//TOTPService is a contract of what totpService can do
+type TOTPService interface {
+	Enroll(userID uint64) (secret string, uri string, err error)
+	Activate(userID uint64, code string) ([]string, error)
+	Disable(userID uint64, code string) error
+	Verify(userID uint64, code string) error
+	VerifyLogin(userID uint64, code string) error
+}
+
+type totpService struct {
+	userRepository         repository.UserRepository
+	recoveryCodeRepository repository.RecoveryCodeRepository
+	secretService          SecretService
+}
+
+//NewTOTPService creates a new instance of TOTPService
+func NewTOTPService(userRepo repository.UserRepository, recoveryRepo repository.RecoveryCodeRepository, secretServ SecretService) TOTPService {
+	return &totpService{
+		userRepository:         userRepo,
+		recoveryCodeRepository: recoveryRepo,
+		secretService:          secretServ,
+	}
+}
+
+//Enroll stores a new pending secret, it only takes effect once Activate confirms a code
+func (service *totpService) Enroll(userID uint64) (string, string, error) {
+	user := service.userRepository.FindByID(userID)
+	if user.TOTPEnabled {
+		return "", "", helper.ConflictError("Two-factor authentication is already enabled", nil)
+	}
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: user.Email,
+		Period:      totpPeriod,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	sealed, dataKey, err := service.secretService.Seal(key.Secret())
+	if err != nil {
+		return "", "", err
+	}
+	user.TOTPSecret = sealed
+	user.TOTPDataKey = dataKey
+	user.TOTPLastStep = 0
+	service.userRepository.UpdateTOTP(user)
+	return key.Secret(), key.URL(), nil
+}
+
+//Activate enables TOTP once the pending secret produced code and returns fresh recovery codes
+func (service *totpService) Activate(userID uint64, code string) ([]string, error) {
+	user := service.userRepository.FindByID(userID)
+	if user.TOTPSecret == "" {
+		return nil, helper.ConflictError("No pending two-factor enrollment", nil)
+	}
+	if err := service.check(user, code); err != nil {
+		return nil, err
+	}
+	user = service.userRepository.FindByID(userID)
+	user.TOTPEnabled = true
+	service.userRepository.UpdateTOTP(user)
+	return service.newRecoveryCodes(userID), nil
+}
+
+func (service *totpService) Disable(userID uint64, code string) error {
+	if err := service.Verify(userID, code); err != nil {
+		return err
+	}
+	user := service.userRepository.FindByID(userID)
+	user.TOTPSecret = ""
+	user.TOTPDataKey = ""
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	service.userRepository.UpdateTOTP(user)
+	service.recoveryCodeRepository.DeleteRecoveryCodes(userID)
+	return nil
+}
+
+//Verify accepts a TOTP code only, it is what sensitive actions require
+func (service *totpService) Verify(userID uint64, code string) error {
+	user := service.userRepository.FindByID(userID)
+	if !user.TOTPEnabled {
+		return ErrTOTPRequired
+	}
+	return service.check(user, code)
+}
+
+//VerifyLogin also accepts an unused recovery code in place of a TOTP code
+func (service *totpService) VerifyLogin(userID uint64, code string) error {
+	err := service.Verify(userID, code)
+	if errors.Is(err, ErrTOTPInvalid) && service.recoveryCodeRepository.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(code))) {
+		return nil
+	}
+	return err
+}
+
+//check validates code within one period of skew and refuses to accept the same period twice
+func (service *totpService) check(user model.User, code string) error {
+	secret, err := service.secretService.Open(user.TOTPSecret, user.TOTPDataKey)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, skew := range []int64{0, -1, 1} {
+		at := now.Add(time.Duration(skew*totpPeriod) * time.Second)
+		expected, err := totp.GenerateCodeCustom(secret, at, totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			if !service.userRepository.ClaimTOTPStep(user.ID, at.Unix()/totpPeriod) {
+				return ErrTOTPInvalid
+			}
+			return nil
+		}
+	}
+	return ErrTOTPInvalid
+}
+
+func (service *totpService) newRecoveryCodes(userID uint64) []string {
+	plain := make([]string, recoveryCodeCount)
+	codes := make([]model.RecoveryCode, recoveryCodeCount)
+	for i := range plain {
+		raw := make([]byte, 5)
+		if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+			panic(fmt.Sprintf("failed to generate recovery code: %v", err))
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(raw))
+		plain[i] = code[:4] + "-" + code[4:]
+		codes[i] = model.RecoveryCode{UserID: userID, CodeHash: hashToken(code)}
+	}
+	service.recoveryCodeRepository.ReplaceRecoveryCodes(userID, codes)
+	return plain
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"fmt"
 	"log"
 
 	"github.com/mashingan/smapping"
@@ -11,28 +12,43 @@ import (
 
 //UserService is a contract.....
 type UserService interface {
-	Update(user dto.UserUpdateDTO) model.User
+	Update(user dto.UserUpdateDTO, sessionID uint64) (model.User, error)
 	Profile(userID string) model.User
 }
 
 type userService struct {
 	userRepository repository.UserRepository
+	sessionService SessionService
+	authService    AuthService
 }
 
-func NewUserService(userRepo repository.UserRepository) UserService {
+func NewUserService(userRepo repository.UserRepository, sessionServ SessionService, authServ AuthService) UserService {
 	return &userService{
 		userRepository: userRepo,
+		sessionService: sessionServ,
+		authService:    authServ,
 	}
 }
 
-func (service *userService) Update(user dto.UserUpdateDTO) model.User {
+//Update changes the profile, a new password ends every session but sessionID and a new email
+//is mailed a verification link
+func (service *userService) Update(user dto.UserUpdateDTO, sessionID uint64) (model.User, error) {
 	userToUpdate := model.User{}
 	err := smapping.FillStruct(&userToUpdate, smapping.MapFields(&user))
 	if err != nil {
-		log.Fatalf("Failed map %v:", err)
+		return model.User{}, fmt.Errorf("failed map: %w", err)
 	}
+	previous := service.userRepository.FindByID(user.ID)
 	updatedUser := service.userRepository.UpdateUser(userToUpdate)
-	return updatedUser
+	if user.Password != "" {
+		service.sessionService.RevokeOthers(user.ID, sessionID)
+	}
+	if updatedUser.Email != previous.Email {
+		if err := service.authService.SendVerification(updatedUser); err != nil {
+			log.Printf("Failed to send verification mail to user %d: %v", updatedUser.ID, err)
+		}
+	}
+	return updatedUser, nil
 }
 
 func (service *userService) Profile(userID string) model.User {